@@ -0,0 +1,193 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"bot-api/db"
+	s "bot-api/server"
+)
+
+// content type and file extension of each export format
+var exportFormats = map[string]struct {
+	contentType string
+	extension   string
+}{
+	"json":  {"application/json", "json"},
+	"csv":   {"text/csv", "csv"},
+	"colon": {"text/plain", "txt"},
+}
+
+// imports accounts from a json, csv or colon separated body. with dry_run=true nothing is written and
+// the response shows what would happen to every row
+func importAccounts(c *gin.Context) {
+	format := importFormat(c)
+	dryRun := c.Query("dry_run") == "true"
+
+	records, err := s.ParseAccounts(c.Request.Body, format)
+	if err != nil {
+		writeError(c, http.StatusBadRequest, "could not parse "+format+" import: "+err.Error())
+		return
+	}
+
+	result, err := server.ImportAccounts(records, dryRun, requestActor(c))
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	status := http.StatusOK
+	if result.Invalid > 0 {
+		status = http.StatusUnprocessableEntity
+		if result.Invalid < result.Total {
+			// some rows were imported
+			status = http.StatusMultiStatus
+		}
+	}
+
+	c.IndentedJSON(status, result)
+}
+
+// the import format from the format query parameter, falling back to the content type
+func importFormat(c *gin.Context) string {
+	if format := c.Query("format"); format != "" {
+		return format
+	}
+
+	switch ct := c.ContentType(); {
+	case strings.Contains(ct, "csv"):
+		return "csv"
+	case strings.HasPrefix(ct, "text/plain"):
+		return "colon"
+	}
+
+	return "json"
+}
+
+// exports all accounts as json, csv or colon separated text. passwords are only included with
+// include_credentials=true, which requires an admin key
+func exportAccounts(c *gin.Context) {
+	format := c.DefaultQuery("format", "json")
+	exportFormat, ok := exportFormats[format]
+	if !ok {
+		writeError(c, http.StatusBadRequest, "unsupported format: "+format)
+		return
+	}
+
+	includeCredentials := c.Query("include_credentials") == "true"
+	if includeCredentials {
+		if key, ok := requestAPIKey(c); !ok || !s.Role(key.Role).Allows(s.RoleAdmin) {
+			writeError(c, http.StatusForbidden, "exporting credentials requires the admin role")
+			return
+		}
+	}
+
+	records, err := server.ExportAccounts(includeCredentials)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := s.FormatAccounts(&buf, format, records); err != nil {
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="accounts.`+exportFormat.extension+`"`)
+	c.Data(http.StatusOK, exportFormat.contentType, buf.Bytes())
+
+	// reading credentials is audited even though it doesn't change anything
+	if includeCredentials {
+		writeAuditEntry(c, "")
+	}
+}
+
+// the ETag of an account, derived from its version
+func accountETag(acc db.Account) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d/%s", acc.ID, acc.UpdatedAt)))
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// partially updates an account, fields that are left out are unchanged. with an If-Match header the
+// update is only applied if the account still has that ETag. responds with the updated account
+func patchAccount(c *gin.Context) {
+	acc, ok := accountParam(c)
+	if !ok {
+		return
+	}
+
+	ifMatch := c.GetHeader("If-Match")
+	if ifMatch != "" && ifMatch != "*" && ifMatch != accountETag(acc) {
+		writeError(c, http.StatusPreconditionFailed, "account was modified, fetch it again")
+		return
+	}
+
+	var req struct {
+		Email    *string `json:"email"`
+		Username *string `json:"username"`
+		Status   *string `json:"status"`
+		Reason   string  `json:"reason"`
+		Notes    *string `json:"notes"`
+		Member   *bool   `json:"member"`
+
+		// raw to tell an explicit null, which clears the date, from a missing field
+		MembershipExpires json.RawMessage `json:"membership_expires"`
+	}
+
+	dec := json.NewDecoder(c.Request.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeError(c, http.StatusBadRequest, "invalid account body: "+err.Error())
+		return
+	}
+
+	update := s.AccountUpdate{
+		Email:    req.Email,
+		Username: req.Username,
+		Status:   req.Status,
+		Notes:    req.Notes,
+		Member:   req.Member,
+		Reason:   req.Reason,
+	}
+	if len(req.MembershipExpires) > 0 {
+		update.SetMembershipExpires = true
+		if err := json.Unmarshal(req.MembershipExpires, &update.MembershipExpires); err != nil {
+			writeError(c, http.StatusBadRequest, "membership_expires must be a date string or null")
+			return
+		}
+	}
+
+	updated, fieldErrs, err := server.UpdateAccount(acc, update, requestActor(c))
+	if err != nil {
+		if _, ok := err.(*s.TransitionError); ok {
+			writeError(c, http.StatusConflict, err.Error())
+			return
+		}
+		if err == db.ErrAccountModified {
+			if ifMatch != "" {
+				writeError(c, http.StatusPreconditionFailed, "account was modified, fetch it again")
+				return
+			}
+			writeError(c, http.StatusConflict, "account was modified concurrently, retry")
+			return
+		}
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if len(fieldErrs) > 0 {
+		writeErrorDetails(c, http.StatusUnprocessableEntity, "invalid account", fieldErrs)
+		return
+	}
+
+	c.Header("ETag", accountETag(updated))
+	c.IndentedJSON(http.StatusOK, updated)
+}
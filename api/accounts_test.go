@@ -0,0 +1,247 @@
+package api
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-sql-driver/mysql"
+
+	"bot-api/db"
+	s "bot-api/server"
+)
+
+// fakeAccounts is a database/sql driver holding an accounts table in memory. it answers the statements
+// of db.GetAccount, db.GetAccountByEmail and db.PatchAccount, other queries return no rows
+type fakeAccounts struct {
+	mu       sync.Mutex
+	accounts map[int64]*fakeAccount
+	version  int
+
+	// emails the UPDATE finds taken although the lookup by email doesn't, like a concurrent change would
+	taken map[string]bool
+}
+
+type fakeAccount struct {
+	username, email, status, updatedAt string
+}
+
+var (
+	fakeDB       = &fakeAccounts{}
+	registerFake sync.Once
+)
+
+func (f *fakeAccounts) Open(string) (driver.Conn, error) { return fakeConn{f}, nil }
+
+type fakeConn struct{ f *fakeAccounts }
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt{c.f, query}, nil }
+func (c fakeConn) Close() error                              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)                 { return fakeTx{}, nil }
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeStmt struct {
+	f     *fakeAccounts
+	query string
+}
+
+func (st fakeStmt) Close() error  { return nil }
+func (st fakeStmt) NumInput() int { return -1 }
+
+func (st fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	f := st.f
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !strings.HasPrefix(st.query, "UPDATE accounts SET ") {
+		return driver.RowsAffected(0), nil
+	}
+
+	set, where, _ := strings.Cut(strings.TrimPrefix(st.query, "UPDATE accounts SET "), " WHERE ")
+	changes := map[string]string{}
+	i := 0
+	for _, assignment := range strings.Split(set, ", ") {
+		column, value, _ := strings.Cut(assignment, " = ")
+		if value == "?" {
+			changes[column] = fmt.Sprint(args[i])
+			i++
+		}
+	}
+	if where != "id = ? AND updated_at = ?" {
+		return nil, fmt.Errorf("unexpected update: %s", st.query)
+	}
+
+	acc := f.accounts[args[i].(int64)]
+	if acc == nil || acc.updatedAt != args[i+1] {
+		return driver.RowsAffected(0), nil
+	}
+	if email, ok := changes["email"]; ok && f.taken[email] {
+		return nil, &mysql.MySQLError{Number: 1062, Message: "Duplicate entry '" + email + "' for key 'email'"}
+	}
+	if email, ok := changes["email"]; ok {
+		acc.email = email
+	}
+	if username, ok := changes["username"]; ok {
+		acc.username = username
+	}
+	f.version++
+	acc.updatedAt = fmt.Sprintf("2026-10-19 12:00:00.%06d", f.version)
+
+	return driver.RowsAffected(1), nil
+}
+
+func (st fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	f := st.f
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	rows := &fakeRows{}
+	switch {
+	case strings.HasPrefix(st.query, "SELECT EXISTS"):
+		_, ok := f.accounts[args[0].(int64)]
+		rows.values = [][]driver.Value{{ok}}
+	case strings.HasSuffix(st.query, "FROM accounts a WHERE a.id = ?"):
+		id, _ := args[0].(string)
+		for accID, acc := range f.accounts {
+			if fmt.Sprint(accID) == id {
+				rows.values = append(rows.values, acc.row(accID))
+			}
+		}
+	case strings.HasSuffix(st.query, "FROM accounts a WHERE a.email = ?"):
+		for accID, acc := range f.accounts {
+			if strings.EqualFold(acc.email, args[0].(string)) {
+				rows.values = append(rows.values, acc.row(accID))
+			}
+		}
+	}
+
+	return rows, nil
+}
+
+// the db.accountColumns of an account
+func (acc *fakeAccount) row(id int64) []driver.Value {
+	return []driver.Value{id, acc.username, acc.email, acc.status, "", int64(0), nil, acc.updatedAt, nil}
+}
+
+type fakeRows struct {
+	values [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	if len(r.values) == 0 {
+		return nil
+	}
+	return make([]string, len(r.values[0]))
+}
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+// patchRouter serves PATCH /accounts/:id on a fake database holding two accounts
+func patchRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	registerFake.Do(func() { sql.Register("fakeaccounts", fakeDB) })
+	fakeDB.mu.Lock()
+	fakeDB.accounts = map[int64]*fakeAccount{
+		12: {username: "Zezima", email: "bot@example.com", status: s.StatusActive, updatedAt: "2026-10-19 11:00:00.000000"},
+		13: {username: "Woox", email: "other@example.com", status: s.StatusActive, updatedAt: "2026-10-19 11:00:00.000000"},
+	}
+	fakeDB.taken = map[string]bool{}
+	fakeDB.mu.Unlock()
+
+	conn, err := sql.Open("fakeaccounts", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	server = &s.Server{DB: &db.Database{Driver: conn}, Bus: s.NewBus()}
+
+	router := gin.New()
+	router.PATCH("/accounts/:id", patchAccount)
+	router.GET("/accounts/:id", getAccountByID)
+	return router
+}
+
+func patch(router *gin.Engine, body string, ifMatch string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPatch, "/accounts/12", strings.NewReader(body))
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestPatchAccountIfMatch(t *testing.T) {
+	router := patchRouter(t)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/accounts/12", nil))
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || etag == "" {
+		t.Fatalf("GET: status %d, ETag %q", rec.Code, etag)
+	}
+
+	// matching ETag, the response carries the new one
+	rec = patch(router, `{"username": "Zezima2"}`, etag)
+	next := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || next == "" || next == etag || !strings.Contains(rec.Body.String(), `"Zezima2"`) {
+		t.Fatalf("PATCH with a matching ETag: status %d, ETag %q, body %s", rec.Code, next, rec.Body)
+	}
+
+	// the ETag from before the change is stale
+	if rec = patch(router, `{"username": "Zezima3"}`, etag); rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("PATCH with a stale ETag: status %d, want 412", rec.Code)
+	}
+
+	// chaining the returned ETag works
+	if rec = patch(router, `{"username": "Zezima3"}`, next); rec.Code != http.StatusOK {
+		t.Errorf("PATCH with the returned ETag: status %d, body %s", rec.Code, rec.Body)
+	}
+
+	// without If-Match the change is applied unconditionally
+	if rec = patch(router, `{"username": "Zezima4"}`, ""); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"Zezima4"`) {
+		t.Errorf("PATCH without If-Match: status %d, body %s", rec.Code, rec.Body)
+	}
+}
+
+func TestPatchAccountEmailTaken(t *testing.T) {
+	router := patchRouter(t)
+
+	// caught by the lookup before the update
+	rec := patch(router, `{"email": "other@example.com"}`, "")
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), `"email"`) {
+		t.Errorf("PATCH to the email of another account: status %d, body %s", rec.Code, rec.Body)
+	}
+
+	// taken between the lookup and the update
+	fakeDB.mu.Lock()
+	fakeDB.taken["new@example.com"] = true
+	fakeDB.mu.Unlock()
+
+	rec = patch(router, `{"email": "new@example.com"}`, "")
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), `"email"`) {
+		t.Errorf("PATCH to an email taken concurrently: status %d, body %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,345 @@
+package api
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"bot-api/db"
+	s "bot-api/server"
+)
+
+// returns the alerts, newest first. only the firing ones unless ?status=resolved or ?status=all, filtered
+// with ?severity=, ?rule_id= and ?account_id=
+func getAlerts(c *gin.Context) {
+	filter := db.AlertFilter{Status: c.DefaultQuery("status", db.AlertFiring), Severity: c.Query("severity")}
+
+	switch filter.Status {
+	case db.AlertFiring, db.AlertResolved:
+	case "all":
+		filter.Status = ""
+	default:
+		writeError(c, http.StatusBadRequest, "invalid status: "+filter.Status+", must be one of firing, resolved, all")
+		return
+	}
+
+	if filter.Severity != "" && !validSeverity(filter.Severity) {
+		writeError(c, http.StatusBadRequest, "invalid severity: "+filter.Severity+", must be one of "+strings.Join(s.AlertSeverities, ", "))
+		return
+	}
+
+	for name, dest := range map[string]*int{"rule_id": &filter.RuleID, "account_id": &filter.AccountID} {
+		if v := c.Query(name); v != "" {
+			id, err := strconv.Atoi(v)
+			if err != nil {
+				writeError(c, http.StatusBadRequest, "invalid "+name+": "+v)
+				return
+			}
+			*dest = id
+		}
+	}
+
+	page, err := pageRequest(c)
+	if err != nil {
+		writeError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if page.Sort == "" {
+		page.Sort, page.Desc = "id", true
+	}
+
+	alerts, next, err := server.DB.ListAlerts(filter, page)
+	if err != nil {
+		listError(c, err)
+		return
+	}
+
+	writePage(c, alerts, next)
+}
+
+func validSeverity(severity string) bool {
+	for _, sev := range s.AlertSeverities {
+		if sev == severity {
+			return true
+		}
+	}
+
+	return false
+}
+
+func getAlertRules(c *gin.Context) {
+	rules, err := server.DB.GetAlertRules()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, rules)
+}
+
+// alertRuleRequest is the body of createAlertRule and patchAlertRule, absent fields are left unchanged
+type alertRuleRequest struct {
+	Name          *string  `json:"name"`
+	Kind          *string  `json:"kind"`
+	Severity      *string  `json:"severity"`
+	Script        *string  `json:"script"`
+	Threshold     *float64 `json:"threshold"`
+	WindowSeconds *int     `json:"window_seconds"`
+	Enabled       *bool    `json:"enabled"`
+}
+
+func (req alertRuleRequest) apply(r *db.AlertRule) {
+	if req.Name != nil {
+		r.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Kind != nil {
+		r.Kind = *req.Kind
+	}
+	if req.Severity != nil {
+		r.Severity = *req.Severity
+	}
+	if req.Script != nil {
+		r.Script = strings.TrimSpace(*req.Script)
+	}
+	if req.Threshold != nil {
+		r.Threshold = *req.Threshold
+	}
+	if req.WindowSeconds != nil {
+		r.WindowSeconds = *req.WindowSeconds
+	}
+	if req.Enabled != nil {
+		r.Enabled = *req.Enabled
+	}
+}
+
+// creates an alert rule, enabled and of warning severity unless the body says otherwise
+func createAlertRule(c *gin.Context) {
+	var req alertRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	rule := db.AlertRule{Severity: s.SeverityWarning, Enabled: true}
+	req.apply(&rule)
+
+	if errs := s.ValidateAlertRule(rule); len(errs) > 0 {
+		writeErrorDetails(c, http.StatusUnprocessableEntity, "invalid alert rule", errs)
+		return
+	}
+
+	id, err := server.DB.InsertAlertRule(rule)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	rule, err = server.DB.GetAlertRule(id)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.IndentedJSON(http.StatusCreated, rule)
+}
+
+func getAlertRule(c *gin.Context) {
+	rule, ok := alertRuleParam(c)
+	if !ok {
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, rule)
+}
+
+// updates the given fields of an alert rule. its firing alerts are re-evaluated against the new rule by
+// the next evaluation
+func patchAlertRule(c *gin.Context) {
+	rule, ok := alertRuleParam(c)
+	if !ok {
+		return
+	}
+
+	var req alertRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	req.apply(&rule)
+
+	if errs := s.ValidateAlertRule(rule); len(errs) > 0 {
+		writeErrorDetails(c, http.StatusUnprocessableEntity, "invalid alert rule", errs)
+		return
+	}
+
+	if err := server.DB.UpdateAlertRule(rule); err != nil {
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, rule)
+}
+
+// deletes an alert rule, its firing alerts are resolved by the next evaluation
+func deleteAlertRule(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		writeError(c, http.StatusBadRequest, "Invalid rule ID")
+		return
+	}
+
+	if err := server.DB.DeleteAlertRule(id); err != nil {
+		if err == sql.ErrNoRows {
+			writeError(c, http.StatusNotFound, "alert rule not found")
+			return
+		}
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"message": "alert rule deleted"})
+}
+
+// reads the alert rule of the :id path parameter, writing the error response if there is none
+func alertRuleParam(c *gin.Context) (db.AlertRule, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		writeError(c, http.StatusBadRequest, "Invalid rule ID")
+		return db.AlertRule{}, false
+	}
+
+	rule, err := server.DB.GetAlertRule(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeError(c, http.StatusNotFound, "alert rule not found")
+			return rule, false
+		}
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return rule, false
+	}
+
+	return rule, true
+}
+
+// returns the current and upcoming silences
+func getAlertSilences(c *gin.Context) {
+	silences, err := server.DB.GetAlertSilences(time.Now())
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, silences)
+}
+
+// creates a silence for a rule and/or an account, or for every alert when neither is given. it starts
+// at starts_at (RFC 3339, default now) and ends at ends_at or after duration (e.g. "2h")
+func createAlertSilence(c *gin.Context) {
+	var req struct {
+		RuleID    *int   `json:"rule_id"`
+		AccountID *int   `json:"account_id"`
+		StartsAt  string `json:"starts_at"`
+		EndsAt    string `json:"ends_at"`
+		Duration  string `json:"duration"`
+		Reason    string `json:"reason"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	errs := []s.FieldError{}
+
+	starts := time.Now()
+	if req.StartsAt != "" {
+		t, err := time.Parse(time.RFC3339, req.StartsAt)
+		if err != nil {
+			errs = append(errs, s.FieldError{Field: "starts_at", Message: "must be an RFC 3339 time"})
+		}
+		starts = t.Local()
+	}
+
+	var ends time.Time
+	switch {
+	case req.EndsAt != "" && req.Duration != "":
+		errs = append(errs, s.FieldError{Field: "ends_at", Message: "can't be combined with duration"})
+	case req.EndsAt != "":
+		t, err := time.Parse(time.RFC3339, req.EndsAt)
+		if err != nil {
+			errs = append(errs, s.FieldError{Field: "ends_at", Message: "must be an RFC 3339 time"})
+		}
+		ends = t.Local()
+	case req.Duration != "":
+		d, err := time.ParseDuration(req.Duration)
+		if err != nil || d <= 0 {
+			errs = append(errs, s.FieldError{Field: "duration", Message: "must be a positive duration such as 90m or 2h"})
+		}
+		ends = starts.Add(d)
+	default:
+		errs = append(errs, s.FieldError{Field: "ends_at", Message: "ends_at or duration is required"})
+	}
+	if len(errs) == 0 && !ends.After(starts) {
+		errs = append(errs, s.FieldError{Field: "ends_at", Message: "must be after starts_at"})
+	}
+
+	if req.RuleID != nil {
+		if _, err := server.DB.GetAlertRule(*req.RuleID); err == sql.ErrNoRows {
+			errs = append(errs, s.FieldError{Field: "rule_id", Message: "no such alert rule"})
+		} else if err != nil {
+			writeError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	if len(errs) > 0 {
+		writeErrorDetails(c, http.StatusUnprocessableEntity, "invalid silence", errs)
+		return
+	}
+
+	silence := db.AlertSilence{
+		RuleID:    req.RuleID,
+		AccountID: req.AccountID,
+		StartsAt:  starts.Format(db.TimeFormat),
+		EndsAt:    ends.Format(db.TimeFormat),
+		Reason:    req.Reason,
+		CreatedBy: requestActor(c),
+	}
+	id, err := server.DB.InsertAlertSilence(silence)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	silence, err = server.DB.GetAlertSilence(id)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.IndentedJSON(http.StatusCreated, silence)
+}
+
+// deletes a silence, ending it early
+func deleteAlertSilence(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		writeError(c, http.StatusBadRequest, "Invalid silence ID")
+		return
+	}
+
+	if err := server.DB.DeleteAlertSilence(id); err != nil {
+		if err == sql.ErrNoRows {
+			writeError(c, http.StatusNotFound, "silence not found")
+			return
+		}
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"message": "silence deleted"})
+}
@@ -3,17 +3,21 @@ package api
 import (
 	"database/sql"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
 	b "bot-api/bot"
+	"bot-api/db"
+	"bot-api/prices"
 	s "bot-api/server"
 )
 
-var server s.Server
+var server *s.Server
 
 type StartBotCommand struct {
 	ID     string   `json:"id"`
@@ -21,47 +25,147 @@ type StartBotCommand struct {
 	Params []string `json:"params"`
 }
 
-func Start(s *s.Server) {
-	server = *s
+// prefix of the current api version
+const apiVersionPrefix = "/v1"
 
-	router := gin.Default()
+func Start(srv *s.Server) {
+	server = srv
 
-	router.GET("/bots/active", getActiveBots)
-	router.GET("/bots/inactive", getInactiveBots)
+	router := gin.New()
+	router.Use(requestID(), requestLogger(), httpMetrics(), recovery())
+	router.HandleMethodNotAllowed = true
+	router.NoRoute(noRoute)
+	router.NoMethod(noMethod)
 
-	router.GET("/bots/activity", getBotActivity)
-	router.GET("/bots/activity/:id", getBotActivityByID)
-	// router.GET("/bots/heartbeat", getBotHeartbeat)
+	registerRoutes(router.Group(apiVersionPrefix))
 
-	router.POST("/bots", startBot)
-	router.GET("/bots/:id", getBotByID)
-	router.DELETE("/bots/:id", deleteBot)
+	// the unversioned routes are kept as deprecated aliases of /v1
+	registerRoutes(router.Group("/", deprecated()))
 
-	router.POST("/heartbeat", handleHeartbeat)
-
-	router.POST("/accounts", insertAccount)
-	router.GET("/accounts", getAccounts)
-	router.GET("/accounts/:id", getAccountByID)
-	router.PUT("/accounts/:id", updateAccount)
-	router.DELETE("/accounts/:id", deleteAccount)
-
-	router.GET("/levels/:id", getLevelsByID)
-
-	router.GET("/activity/:id/xp", getActivityXP)
-	router.GET("/accounts/:id/xp", getAccountXP)
+	registerDocs(router)
+	registerMetrics(router)
 
 	server.Start()
+	initHTTPMetrics(server.Metrics)
 
 	// needs to be the last line in the function
 	// TODO - research best practice for this
 	router.Run("192.168.1.171:8080")
 }
 
+// registerRoutes adds every api route to the given group
+func registerRoutes(r *gin.RouterGroup) {
+	// heartbeats authenticate with the per-activity token of the client instead of an api key
+	r.POST("/heartbeat", handleHeartbeat)
+	r.GET("/heartbeat/schema", getHeartbeatSchema)
+
+	viewer := r.Group("/", requireRole(s.RoleViewer))
+	operator := r.Group("/", requireRole(s.RoleOperator), auditLog())
+	admin := r.Group("/", requireRole(s.RoleAdmin), auditLog())
+
+	viewer.GET("/bots/active", getActiveBots)
+	viewer.GET("/bots/inactive", getInactiveBots)
+
+	viewer.GET("/bots/activity", getBotActivity)
+	viewer.GET("/bots/activity/:id", getBotActivityByID)
+	viewer.GET("/bots/heartbeat", getBotHeartbeat)
+
+	operator.POST("/bots", startBot)
+	viewer.GET("/bots/:id", getBotByID)
+	operator.DELETE("/bots/:id", deleteBot)
+	viewer.GET("/bots/:id/heartbeats", getBotHeartbeatsByID)
+
+	viewer.GET("/heartbeat/quarantine", getQuarantinedHeartbeats)
+
+	viewer.GET("/events", streamEvents)
+	viewer.GET("/events/ws", streamEventsWebSocket)
+	viewer.GET("/events/subscribers", getEventSubscribers)
+
+	operator.POST("/accounts", insertAccount)
+	viewer.GET("/accounts", getAccounts)
+	operator.POST("/accounts/import", importAccounts)
+	viewer.GET("/accounts/export", exportAccounts)
+	viewer.GET("/accounts/:id", getAccountByID)
+	operator.PUT("/accounts/:id", patchAccount)
+	operator.PATCH("/accounts/:id", patchAccount)
+	operator.DELETE("/accounts/:id", deleteAccount)
+	operator.POST("/accounts/:id/restore", restoreAccount)
+	operator.PUT("/accounts/:id/credentials", putAccountCredentials)
+	operator.PUT("/accounts/:id/tags", putAccountTags)
+	operator.PUT("/accounts/:id/notes", putAccountNotes)
+	viewer.GET("/accounts/:id/status-history", getAccountStatusHistory)
+	viewer.GET("/accounts/statuses", getAccountStatuses)
+	operator.PUT("/accounts/:id/membership", putAccountMembership)
+	viewer.GET("/accounts/memberships/expiring", getExpiringMemberships)
+
+	viewer.GET("/groups", getGroups)
+	operator.POST("/groups", createGroup)
+	viewer.GET("/groups/:name", getGroup)
+	operator.DELETE("/groups/:name", deleteGroup)
+	operator.POST("/groups/:name/members", addGroupMembers)
+	operator.DELETE("/groups/:name/members/:account_id", removeGroupMember)
+	operator.POST("/groups/:name/bots", startGroupBots)
+	operator.DELETE("/groups/:name/bots", stopGroupBots)
+
+	viewer.GET("/levels/:id", getLevelsByID)
+
+	viewer.GET("/activity/:id/xp", getActivityXP)
+	viewer.GET("/accounts/:id/xp", getAccountXP)
+
+	viewer.GET("/activity/:id/loot", getActivityLoot)
+	viewer.GET("/accounts/:id/income", getAccountIncome)
+
+	viewer.GET("/bots/:id/inventory", getBotInventory)
+	viewer.GET("/bots/:id/bank", getBotBank)
+
+	viewer.GET("/alerts", getAlerts)
+	viewer.GET("/alerts/rules", getAlertRules)
+	operator.POST("/alerts/rules", createAlertRule)
+	viewer.GET("/alerts/rules/:id", getAlertRule)
+	operator.PATCH("/alerts/rules/:id", patchAlertRule)
+	operator.DELETE("/alerts/rules/:id", deleteAlertRule)
+	viewer.GET("/alerts/silences", getAlertSilences)
+	operator.POST("/alerts/silences", createAlertSilence)
+	operator.DELETE("/alerts/silences/:id", deleteAlertSilence)
+
+	viewer.GET("/prices", getPriceStatus)
+	operator.POST("/prices/refresh", refreshPrices)
+	viewer.GET("/prices/:item_id", getItemPrice)
+
+	admin.POST("/keys", createAPIKey)
+	admin.GET("/keys", getAPIKeys)
+	admin.DELETE("/keys/:id", revokeAPIKey)
+
+	admin.GET("/audit", getAuditLog)
+
+	admin.GET("/webhooks", getWebhooks)
+	admin.POST("/webhooks", createWebhook)
+	admin.GET("/webhooks/dead-letters", getWebhookDeadLetters)
+	admin.POST("/webhooks/deliveries/:delivery_id/retry", retryWebhookDelivery)
+	admin.GET("/webhooks/:id", getWebhook)
+	admin.PATCH("/webhooks/:id", patchWebhook)
+	admin.DELETE("/webhooks/:id", deleteWebhook)
+	admin.GET("/webhooks/:id/deliveries", getWebhookDeliveries)
+}
+
+// context key set on requests to the legacy unversioned routes
+const deprecatedContextKey = "deprecated"
+
+// deprecated marks responses of the legacy unversioned routes and points to their /v1 successor
+func deprecated() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(deprecatedContextKey, true)
+		c.Header("Deprecation", "true")
+		c.Header("Link", fmt.Sprintf("<%s%s>; rel=\"successor-version\"", apiVersionPrefix, c.Request.URL.Path))
+		c.Next()
+	}
+}
+
 // return info on all bots currently running on the server
 func getActiveBots(c *gin.Context) {
 	bots, err := server.DB.GetActiveBots()
 	if err != nil {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		writeError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -71,7 +175,7 @@ func getActiveBots(c *gin.Context) {
 func getInactiveBots(c *gin.Context) {
 	bots, err := server.DB.GetInactiveBots()
 	if err != nil {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		writeError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -81,57 +185,42 @@ func getInactiveBots(c *gin.Context) {
 // starts a new dreambot client with the given parameters
 func startBot(c *gin.Context) {
 	var startCmd StartBotCommand
-	if err := c.BindJSON(&startCmd); err != nil {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := c.ShouldBindJSON(&startCmd); err != nil {
+		writeError(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
 	if startCmd.ID == "" {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "ID is empty"})
+		writeError(c, http.StatusBadRequest, "ID is empty")
 		return
 	}
 
 	acc, err := server.DB.GetAccount(startCmd.ID)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Account not found for ID: " + startCmd.ID})
+			writeError(c, http.StatusNotFound, "Account not found for ID: "+startCmd.ID)
 			return
 		}
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		writeError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	bots, err := server.DB.GetActiveBots()
+	bot, err := server.LaunchBot(acc, startCmd.Script, startCmd.Params)
 	if err != nil {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-
-	for _, b := range bots {
-		if b.ID == startCmd.ID {
-			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "bot with given ID is already running"})
+		if err == s.ErrBotAlreadyRunning {
+			writeError(c, http.StatusConflict, err.Error())
 			return
 		}
+		if err == s.ErrAccountNotRunnable || err == s.ErrAccountDeleted {
+			writeError(c, http.StatusConflict, err.Error()+": "+acc.Status)
+			return
+		}
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return
 	}
 
-	var newBot b.Bot
-	newBot.ID = fmt.Sprint(acc.ID)
-	newBot.Username = acc.Username
-	newBot.Email = acc.Email
-	newBot.Status = "Stopped"
-	newBot.Script = startCmd.Script
-	newBot.Params = startCmd.Params
-	newBot.Start()
-
-	server.AddBot(newBot)
-
-	command := startCmd.Script
-	if len(startCmd.Params) > 0 {
-		command += " " + strings.Join(startCmd.Params, " ")
-	}
-	server.DB.InsertActivity(acc.ID, command, newBot.PID)
-
-	c.IndentedJSON(http.StatusCreated, startCmd)
+	c.Header("Location", apiVersionPrefix+"/bots/"+bot.ID)
+	c.IndentedJSON(http.StatusCreated, bot)
 }
 
 // return info on a specific bot
@@ -140,18 +229,60 @@ func getBotByID(c *gin.Context) {
 
 	bots, err := server.DB.GetActiveBots()
 	if err != nil {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		writeError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
 	for _, b := range bots {
 		if b.ID == id {
-			c.IndentedJSON(http.StatusOK, b)
+			c.IndentedJSON(http.StatusOK, botDetails(b))
 			return
 		}
 	}
 
-	c.IndentedJSON(http.StatusNotFound, gin.H{"message": "album not found"})
+	writeError(c, http.StatusNotFound, "Bot not found for ID: "+id)
+}
+
+// BotDetails is a running bot together with the in-game state from its latest heartbeat
+type BotDetails struct {
+	b.Bot
+	State           *db.BotState `json:"state,omitempty"`
+	LastHeartbeatAt string       `json:"last_heartbeat_at,omitempty"`
+}
+
+// adds the latest reported state to a bot
+func botDetails(bot b.Bot) BotDetails {
+	details := BotDetails{Bot: bot}
+
+	accountID, _ := strconv.Atoi(bot.ID)
+	details.State, details.LastHeartbeatAt = latestState(accountID, bot.Email)
+
+	return details
+}
+
+// returns the in-game state of an account, preferring the in-memory heartbeat and falling back to the
+// last stored one (e.g. after a restart), and the time the last heartbeat was stored
+func latestState(accountID int, email string) (*db.BotState, string) {
+	var state *db.BotState
+	if hb, ok := server.LatestHeartbeat(email); ok && !hb.BotState.IsZero() {
+		st := hb.BotState
+		state = &st
+	}
+
+	if accountID == 0 {
+		return state, ""
+	}
+
+	heartbeats, err := server.DB.GetHeartbeats(db.HeartbeatFilter{AccountID: accountID, Limit: 1})
+	if err != nil || len(heartbeats) == 0 {
+		return state, ""
+	}
+
+	if state == nil {
+		state = heartbeats[0].State
+	}
+
+	return state, heartbeats[0].ReceivedAt
 }
 
 func deleteBot(c *gin.Context) {
@@ -162,60 +293,171 @@ func deleteBot(c *gin.Context) {
 		return
 	}
 
-	c.IndentedJSON(http.StatusNotFound, gin.H{"message": "bot not found"})
+	writeError(c, http.StatusNotFound, "Bot not found for ID: "+id)
 }
 
 func handleHeartbeat(c *gin.Context) {
 	// parse heartbeat
 	var hb s.Heartbeat
-	if err := c.BindJSON(&hb); err != nil {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		fmt.Printf("Error parsing heartbeat: %s\n", c.Request.Body)
+	if err := c.ShouldBindJSON(&hb); err != nil {
+		server.RecordHeartbeatRejected(s.HeartbeatMalformed)
+		writeError(c, http.StatusBadRequest, "invalid heartbeat body: "+err.Error())
+		slog.WarnContext(c.Request.Context(), "malformed heartbeat", "client_ip", c.ClientIP(), "error", err)
 		return
 	}
 
-	server.HandleHeartbeat(hb)
-}
+	if errs := hb.Validate(); len(errs) > 0 {
+		server.RecordHeartbeatRejected(s.HeartbeatInvalid)
+		writeErrorDetails(c, http.StatusUnprocessableEntity, "invalid heartbeat", errs)
+		slog.WarnContext(c.Request.Context(), "invalid heartbeat", "email", hb.Email, "client_ip", c.ClientIP(), "errors", errs)
+		return
+	}
 
-func updateAccount(c *gin.Context) {
+	account, err := server.AuthenticateHeartbeat(bearerToken(c), hb)
+	if err != nil {
+		if err != s.ErrHeartbeatUnauthenticated && err != s.ErrHeartbeatForbidden {
+			writeError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
 
-	id := c.Param("id")
-	if id == "" {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "ID is empty"})
+		slog.WarnContext(c.Request.Context(), "unauthenticated heartbeat", "email", hb.Email, "client_ip", c.ClientIP(), "error", err)
+		if err == s.ErrHeartbeatForbidden {
+			server.RecordHeartbeatRejected(s.HeartbeatForbidden)
+		} else {
+			server.RecordHeartbeatRejected(s.HeartbeatUnauthenticated)
+		}
+		if server.Config.HeartbeatAuthMode == s.HeartbeatAuthQuarantine {
+			if qErr := server.QuarantineHeartbeat(hb, c.ClientIP(), err.Error()); qErr != nil {
+				writeError(c, http.StatusInternalServerError, qErr.Error())
+				return
+			}
+			c.IndentedJSON(http.StatusAccepted, gin.H{"message": "heartbeat quarantined", "reason": err.Error()})
+			return
+		}
+
+		status := http.StatusUnauthorized
+		if err == s.ErrHeartbeatForbidden {
+			status = http.StatusForbidden
+		}
+		writeError(c, status, err.Error())
 		return
 	}
 
-	var account struct {
-		Status string `json:"status"`
+	server.HandleHeartbeat(hb, account)
+
+	c.IndentedJSON(http.StatusOK, gin.H{"message": "heartbeat received", "version": hb.Version})
+}
+
+// returns the token from an "Authorization: Bearer <token>" header, or an empty string
+func bearerToken(c *gin.Context) string {
+	header := c.GetHeader("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+
+	return ""
+}
+
+// return the most recent heartbeats that failed authentication
+func getQuarantinedHeartbeats(c *gin.Context) {
+	limit := 100
+	if l := c.Query("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit <= 0 {
+			writeError(c, http.StatusBadRequest, "invalid limit: "+l)
+			return
+		}
 	}
 
-	if err := c.BindJSON(&account); err != nil {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	heartbeats, err := server.DB.GetQuarantinedHeartbeats(limit)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	status := account.Status
+	c.IndentedJSON(http.StatusOK, heartbeats)
+}
+
+// return the JSON Schema of the latest heartbeat protocol version
+func getHeartbeatSchema(c *gin.Context) {
+	c.Data(http.StatusOK, "application/schema+json", s.HeartbeatSchema)
+}
 
-	if id == "" || status == "" {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "id or status is empty"})
+// return the status changes of an account, newest first
+func getAccountStatusHistory(c *gin.Context) {
+	acc, ok := accountParam(c)
+	if !ok {
 		return
 	}
 
-	server.DB.UpdateAccountStatus(id, status)
-	c.IndentedJSON(http.StatusOK, gin.H{"message": "account updated"})
+	history, err := server.DB.GetAccountStatusHistory(acc.ID)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, history)
+}
+
+// return the account statuses and the statuses each may change to
+func getAccountStatuses(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, s.Statuses())
 }
 
+// soft deletes an account, stopping its bot if it is running. with ?purge=true the account and all of
+// its history are deleted permanently, which requires the admin role and also works on soft deleted
+// accounts
 func deleteAccount(c *gin.Context) {
-	id := c.Param("id")
-	if id == "" {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "ID is empty"})
+	purge := c.Query("purge") == "true"
+	if purge {
+		if key, ok := requestAPIKey(c); !ok || !s.Role(key.Role).Allows(s.RoleAdmin) {
+			writeError(c, http.StatusForbidden, "purging accounts requires the admin role")
+			return
+		}
+	}
+
+	acc, ok := lookupAccount(c, purge)
+	if !ok {
+		return
+	}
+
+	if err := server.DeleteAccount(acc, purge); err != nil {
+		writeError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	server.DB.DeleteAccount(id)
+	if purge {
+		c.IndentedJSON(http.StatusOK, gin.H{"message": "account purged"})
+		return
+	}
 	c.IndentedJSON(http.StatusOK, gin.H{"message": "account deleted"})
 }
 
+// restores a soft deleted account
+func restoreAccount(c *gin.Context) {
+	acc, ok := lookupAccount(c, true)
+	if !ok {
+		return
+	}
+
+	if acc.DeletedAt == nil {
+		writeError(c, http.StatusConflict, "account is not deleted")
+		return
+	}
+
+	if err := server.DB.RestoreAccount(acc.ID); err != nil {
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	acc, ok = accountParam(c)
+	if !ok {
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, acc)
+}
+
 func insertAccount(c *gin.Context) {
 
 	var account struct {
@@ -224,142 +466,379 @@ func insertAccount(c *gin.Context) {
 		Status   string `json:"status"`
 	}
 
-	if err := c.BindJSON(&account); err != nil {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := c.ShouldBindJSON(&account); err != nil {
+		writeError(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	email := account.Email
-	username := account.Username
-	status := account.Status
+	if account.Email == "" || account.Username == "" {
+		writeError(c, http.StatusBadRequest, "email or username is empty")
+		return
+	}
 
-	if email == "" || username == "" || status == "" {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "email, username, or status is empty"})
+	// a single account goes through the same validation, transition checks and history as an import
+	record := s.AccountRecord{Email: account.Email, Username: account.Username, Status: account.Status}
+	result, err := server.ImportAccounts([]s.AccountRecord{record}, false, requestActor(c))
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	server.DB.InsertAccount(email, username, status)
-	c.IndentedJSON(http.StatusCreated, gin.H{"message": "account inserted"})
-}
+	row := result.Rows[0]
+	if row.Action == "invalid" {
+		writeErrorDetails(c, http.StatusUnprocessableEntity, "invalid account", row.Errors)
+		return
+	}
 
-func getAccounts(c *gin.Context) {
-	accounts, err := server.DB.GetAccounts()
+	created, err := server.DB.GetAccount(strconv.Itoa(row.ID))
 	if err != nil {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		writeError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	c.IndentedJSON(http.StatusOK, accounts)
+	// posting an existing email updates that account
+	status := http.StatusOK
+	if row.Action == "created" {
+		status = http.StatusCreated
+		c.Header("Location", apiVersionPrefix+"/accounts/"+strconv.Itoa(row.ID))
+	}
+	c.IndentedJSON(status, created)
 }
 
-func getAccountByID(c *gin.Context) {
-	id := c.Param("id")
-	if id == "" {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "ID is empty"})
+// return a page of accounts, filtered by tag, group, status and member. with ?deleted=true only soft
+// deleted accounts are returned
+func getAccounts(c *gin.Context) {
+	page, err := legacyPageRequest(c)
+	if err != nil {
+		writeError(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	a, err := server.DB.GetAccount(id)
+	filter := db.AccountFilter{
+		Tag:     c.Query("tag"),
+		Group:   c.Query("group"),
+		Status:  c.Query("status"),
+		Deleted: c.Query("deleted") == "true",
+	}
+	if m := c.Query("member"); m != "" {
+		member, err := strconv.ParseBool(m)
+		if err != nil {
+			writeError(c, http.StatusBadRequest, "invalid member: "+m)
+			return
+		}
+		filter.Member = &member
+	}
+
+	accounts, next, err := server.DB.ListAccounts(filter, page)
 	if err != nil {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		listError(c, err)
 		return
 	}
 
-	c.IndentedJSON(http.StatusOK, a)
+	writePage(c, accounts, next)
 }
 
-func getLevelsByID(c *gin.Context) {
-	id := c.Param("id")
-	if id == "" {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "ID is empty"})
+func getAccountByID(c *gin.Context) {
+	a, ok := accountParam(c)
+	if !ok {
 		return
 	}
 
-	a, err := server.DB.GetAccount(id)
-	if err != nil {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	c.Header("ETag", accountETag(a))
+	c.IndentedJSON(http.StatusOK, a)
+}
+
+func getLevelsByID(c *gin.Context) {
+	a, ok := accountParam(c)
+	if !ok {
 		return
 	}
 
 	levels, err := server.DB.GetLevelsForAccount(a.ID)
 	if err != nil {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		writeError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
 	c.IndentedJSON(http.StatusOK, levels)
 }
 
+// return a page of activities, filtered by account_id, script, since, until (of the start time), status
+// (running or stopped) and exit_reason
 func getBotActivity(c *gin.Context) {
-	activity, err := server.DB.GetBotActivity()
+	filter, err := activityFilter(c)
 	if err != nil {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		writeError(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	c.IndentedJSON(http.StatusOK, activity)
+	if id := c.Query("account_id"); id != "" {
+		filter.AccountID, err = strconv.Atoi(id)
+		if err != nil {
+			writeError(c, http.StatusBadRequest, "Invalid account ID")
+			return
+		}
+	}
+
+	listActivity(c, filter)
 }
 
+// return a page of the activities of an account, with the same filters as getBotActivity
 func getBotActivityByID(c *gin.Context) {
-	id := c.Param("id")
-	if id == "" {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "ID is empty"})
+	filter, err := activityFilter(c)
+	if err != nil {
+		writeError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	filter.AccountID, err = strconv.Atoi(c.Param("id"))
+	if err != nil {
+		writeError(c, http.StatusBadRequest, "Invalid account ID")
+		return
+	}
+
+	listActivity(c, filter)
+}
+
+func listActivity(c *gin.Context, filter db.ActivityFilter) {
+	page, err := legacyPageRequest(c)
+	if err != nil {
+		writeError(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	activity, err := server.DB.GetBotActivityByID(id)
+	activity, next, err := server.DB.ListActivity(filter, page)
 	if err != nil {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		listError(c, err)
 		return
 	}
 
-	c.IndentedJSON(http.StatusOK, activity)
+	writePage(c, activity, next)
+}
+
+// reads the activity filters shared by the activity list endpoints
+func activityFilter(c *gin.Context) (db.ActivityFilter, error) {
+	since, err := timeParam(c, "since")
+	if err != nil {
+		return db.ActivityFilter{}, err
+	}
+
+	until, err := timeParam(c, "until")
+	if err != nil {
+		return db.ActivityFilter{}, err
+	}
+
+	return db.ActivityFilter{
+		Script:     c.Query("script"),
+		Since:      since,
+		Until:      until,
+		Status:     c.Query("status"),
+		ExitReason: c.Query("exit_reason"),
+	}, nil
 }
 
 func getActivityXP(c *gin.Context) {
 	id := c.Param("id")
 	if id == "" {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "ID is empty"})
+		writeError(c, http.StatusBadRequest, "ID is empty")
 		return
 	}
 
 	activityID, err := strconv.Atoi(id)
 	if err != nil {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Invalid activity ID"})
+		writeError(c, http.StatusBadRequest, "Invalid activity ID")
 		return
 	}
 
 	xp, err := server.DB.GetActivityXP(activityID)
 	if err != nil {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		writeError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
 	c.IndentedJSON(http.StatusOK, xp)
 }
 
+// return a page of the xp gained by an account, filtered by skill, activity_id and since/until (of the
+// activity start time)
 func getAccountXP(c *gin.Context) {
+	accountID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		writeError(c, http.StatusBadRequest, "Invalid account ID")
+		return
+	}
+
+	page, err := legacyPageRequest(c)
+	if err != nil {
+		writeError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	since, err := timeParam(c, "since")
+	if err != nil {
+		writeError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	until, err := timeParam(c, "until")
+	if err != nil {
+		writeError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	filter := db.XPFilter{Skill: c.Query("skill"), Since: since, Until: until}
+	if id := c.Query("activity_id"); id != "" {
+		filter.ActivityID, err = strconv.Atoi(id)
+		if err != nil {
+			writeError(c, http.StatusBadRequest, "Invalid activity ID")
+			return
+		}
+	}
+
+	xp, next, err := server.DB.ListAccountXP(accountID, filter, page)
+	if err != nil {
+		listError(c, err)
+		return
+	}
+
+	writePage(c, xp, next)
+}
+
+// return the items and gp gained during an activity session
+func getActivityLoot(c *gin.Context) {
+	activityID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		writeError(c, http.StatusBadRequest, "Invalid activity ID")
+		return
+	}
+
+	loot, err := server.DB.GetActivityLoot(activityID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeError(c, http.StatusNotFound, "Activity not found for ID: "+c.Param("id"))
+			return
+		}
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	items := make([]db.ItemDelta, 0, len(loot.Items))
+	for _, item := range loot.Items {
+		items = append(items, item.ItemDelta)
+	}
+
+	c.IndentedJSON(http.StatusOK, struct {
+		db.ActivityLoot
+		Value prices.LootValuation `json:"value"`
+	}{loot, server.Prices.ValueLoot(items, loot.Seconds)})
+}
+
+// return the items and gp gained across all activity sessions of an account
+func getAccountIncome(c *gin.Context) {
+	accountID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		writeError(c, http.StatusBadRequest, "Invalid account ID")
+		return
+	}
+
+	income, err := server.DB.GetAccountIncome(accountID)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, struct {
+		db.AccountIncome
+		Value prices.LootValuation `json:"value"`
+	}{income, server.Prices.ValueLoot(income.Items, income.Seconds)})
+}
+
+// return stored heartbeats for all bots, optionally filtered by account_id, since, until and limit
+func getBotHeartbeat(c *gin.Context) {
+	filter, err := heartbeatFilter(c)
+	if err != nil {
+		writeError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if id := c.Query("account_id"); id != "" {
+		accountID, err := strconv.Atoi(id)
+		if err != nil {
+			writeError(c, http.StatusBadRequest, "Invalid account ID")
+			return
+		}
+		filter.AccountID = accountID
+	}
+
+	listHeartbeats(c, filter)
+}
+
+// return stored heartbeats for a specific bot, optionally filtered by since, until and limit
+func getBotHeartbeatsByID(c *gin.Context) {
 	id := c.Param("id")
-	if id == "" {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "ID is empty"})
+	accountID, err := strconv.Atoi(id)
+	if err != nil {
+		writeError(c, http.StatusBadRequest, "Invalid account ID")
 		return
 	}
 
-	xp, err := server.DB.GetActivityXPByAccountID(id)
+	filter, err := heartbeatFilter(c)
 	if err != nil {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		writeError(c, http.StatusBadRequest, err.Error())
 		return
 	}
+	filter.AccountID = accountID
 
-	c.IndentedJSON(http.StatusOK, xp)
+	listHeartbeats(c, filter)
 }
 
-// func getBotHeartbeat(c *gin.Context) {
-// 	heartbeats, err := server.DB.GetHeartbeats()
-// 	if err != nil {
-// 		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-// 		return
-// 	}
+// parses the since and until (RFC 3339) query parameters shared by the heartbeat endpoints
+func heartbeatFilter(c *gin.Context) (db.HeartbeatFilter, error) {
+	var filter db.HeartbeatFilter
+	var err error
+
+	if filter.Since, err = timeParam(c, "since"); err != nil {
+		return filter, err
+	}
 
-// 	c.IndentedJSON(http.StatusOK, heartbeats)
-// }
+	if filter.Until, err = timeParam(c, "until"); err != nil {
+		return filter, err
+	}
+
+	return filter, nil
+}
+
+// writes a page of heartbeats, newest first unless another order is requested
+func listHeartbeats(c *gin.Context, filter db.HeartbeatFilter) {
+	page, err := pageRequest(c)
+	if err != nil {
+		writeError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if page.Sort == "" {
+		page.Sort, page.Desc = "received_at", true
+	}
+
+	heartbeats, next, err := server.DB.ListHeartbeats(filter, page)
+	if err != nil {
+		listError(c, err)
+		return
+	}
+
+	writePage(c, heartbeats, next)
+}
+
+// parses an optional RFC 3339 query parameter, returning the zero time if it is not set
+func timeParam(c *gin.Context, name string) (time.Time, error) {
+	v := c.Query(name)
+	if v == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s: %s", name, v)
+	}
+
+	return t.Local(), nil
+}
@@ -0,0 +1,157 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"bot-api/db"
+)
+
+// routes whose request bodies contain secrets. no digest is stored for them, as a digest of a weak
+// password can be brute forced
+var sensitiveRoutes = map[string]bool{
+	"PUT /accounts/:id/credentials": true,
+	"POST /accounts/import":         true,
+}
+
+// auditLog records every mutating request in the audit log once it has been handled. it must run
+// after requireRole so the actor is known
+func auditLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead || c.Request.Method == http.MethodOptions {
+			c.Next()
+			return
+		}
+
+		var digest string
+		if c.Request.Body != nil && !sensitiveRoutes[c.Request.Method+" "+routePath(c)] {
+			body, err := io.ReadAll(c.Request.Body)
+			if err != nil {
+				abortError(c, http.StatusBadRequest, err.Error())
+				return
+			}
+			c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+			if len(body) > 0 {
+				sum := sha256.Sum256(body)
+				digest = hex.EncodeToString(sum[:])
+			}
+		}
+
+		c.Next()
+
+		writeAuditEntry(c, digest)
+	}
+}
+
+// records a handled or denied request in the audit log
+func writeAuditEntry(c *gin.Context, bodyDigest string) {
+	entry := db.AuditEntry{
+		Action:     c.Request.Method + " " + routePath(c),
+		Target:     auditTarget(c),
+		BodySHA256: bodyDigest,
+		Status:     c.Writer.Status(),
+		Result:     "success",
+		RemoteAddr: c.ClientIP(),
+	}
+	if entry.Status == http.StatusUnauthorized || entry.Status == http.StatusForbidden {
+		entry.Result = "denied"
+	} else if entry.Status >= 400 {
+		entry.Result = "failure"
+	}
+	if key, ok := requestAPIKey(c); ok {
+		entry.Actor = key.Name
+		entry.ActorKeyID = &key.ID
+	}
+
+	if err := server.DB.InsertAuditEntry(entry); err != nil {
+		slog.ErrorContext(c.Request.Context(), "error writing audit log entry", "action", entry.Action, "actor", entry.Actor, "error", err)
+	}
+}
+
+// the route of a request without the version prefix, so /v1 and legacy requests are audited alike
+func routePath(c *gin.Context) string {
+	return strings.TrimPrefix(c.FullPath(), apiVersionPrefix)
+}
+
+// the path parameters of a request, e.g. "id=12"
+func auditTarget(c *gin.Context) string {
+	parts := make([]string, 0, len(c.Params))
+	for _, p := range c.Params {
+		parts = append(parts, p.Key+"="+p.Value)
+	}
+
+	return strings.Join(parts, ",")
+}
+
+// return a page of audit log entries, newest first, filtered by actor, action, target, result, since and
+// until. with format=jsonl the entries are exported as newline delimited JSON
+func getAuditLog(c *gin.Context) {
+	since, err := timeParam(c, "since")
+	if err != nil {
+		writeError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	until, err := timeParam(c, "until")
+	if err != nil {
+		writeError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	filter := db.AuditFilter{
+		Actor:  c.Query("actor"),
+		Action: c.Query("action"),
+		Target: c.Query("target"),
+		Result: c.Query("result"),
+		Since:  since,
+		Until:  until,
+		Limit:  1000,
+	}
+
+	if limit := c.Query("limit"); limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil || l < 0 {
+			writeError(c, http.StatusBadRequest, "invalid limit: "+limit)
+			return
+		}
+		// 0 removes the limit, useful for full exports
+		filter.Limit = l
+	}
+
+	page := db.PageRequest{Limit: filter.Limit, Cursor: c.Query("cursor"), Desc: true}
+	entries, next, err := server.DB.ListAuditLog(filter, page)
+	if err != nil {
+		listError(c, err)
+		return
+	}
+
+	if c.Query("format") == "jsonl" {
+		if next != "" {
+			c.Header("X-Next-Cursor", next)
+		}
+		c.Header("Content-Type", "application/x-ndjson")
+		c.Header("Content-Disposition", `attachment; filename="audit.jsonl"`)
+		c.Status(http.StatusOK)
+
+		enc := json.NewEncoder(c.Writer)
+		for _, e := range entries {
+			if err := enc.Encode(e); err != nil {
+				slog.ErrorContext(c.Request.Context(), "error exporting audit log", "error", err)
+				return
+			}
+		}
+		return
+	}
+
+	writePage(c, entries, next)
+}
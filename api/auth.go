@@ -0,0 +1,172 @@
+package api
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"bot-api/db"
+	s "bot-api/server"
+)
+
+// context key holding the db.APIKey of an authenticated request
+const apiKeyContextKey = "api_key"
+
+// requireRole rejects requests that don't present an api key with at least the given role, recording them
+// in the audit log. keys are read from the X-API-Key header or an "Authorization: Bearer" header
+func requireRole(role s.Role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader("X-API-Key")
+		if key == "" {
+			key = bearerToken(c)
+		}
+
+		apiKey, err := server.AuthenticateAPIKey(key)
+		if err != nil {
+			if err == s.ErrInvalidAPIKey {
+				abortError(c, http.StatusUnauthorized, err.Error())
+				writeAuditEntry(c, "")
+				return
+			}
+			abortError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if !s.Role(apiKey.Role).Allows(role) {
+			// recorded as the key that was turned away
+			c.Set(apiKeyContextKey, apiKey)
+			abortError(c, http.StatusForbidden, "this route requires the "+string(role)+" role")
+			writeAuditEntry(c, "")
+			return
+		}
+
+		c.Set(apiKeyContextKey, apiKey)
+		c.Next()
+	}
+}
+
+// returns the api key that authenticated the request
+func requestAPIKey(c *gin.Context) (db.APIKey, bool) {
+	v, ok := c.Get(apiKeyContextKey)
+	if !ok {
+		return db.APIKey{}, false
+	}
+
+	key, ok := v.(db.APIKey)
+	return key, ok
+}
+
+// returns the name of the api key that made the request, recorded as the actor of changes
+func requestActor(c *gin.Context) string {
+	key, _ := requestAPIKey(c)
+	return key.Name
+}
+
+// creates a new api key, the key itself is only returned in this response
+func createAPIKey(c *gin.Context) {
+	var req struct {
+		Name string `json:"name"`
+		Role string `json:"role"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if req.Name == "" {
+		writeError(c, http.StatusBadRequest, "name is empty")
+		return
+	}
+
+	role, err := s.ParseRole(req.Role)
+	if err != nil {
+		writeError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	key, apiKey, err := server.CreateAPIKey(req.Name, role)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.IndentedJSON(http.StatusCreated, struct {
+		db.APIKey
+		Key string `json:"key"`
+	}{apiKey, key})
+}
+
+func getAPIKeys(c *gin.Context) {
+	keys, err := server.DB.GetAPIKeys()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, keys)
+}
+
+func revokeAPIKey(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		writeError(c, http.StatusBadRequest, "Invalid key ID")
+		return
+	}
+
+	if current, ok := requestAPIKey(c); ok && current.ID == id {
+		writeError(c, http.StatusBadRequest, "an api key can't revoke itself")
+		return
+	}
+
+	if err := server.DB.RevokeAPIKey(id); err != nil {
+		if err == sql.ErrNoRows {
+			writeError(c, http.StatusNotFound, "api key not found")
+			return
+		}
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"message": "api key revoked"})
+}
+
+// stores the credentials of an account in the vault. credentials can't be read back through the api
+func putAccountCredentials(c *gin.Context) {
+	id := c.Param("id")
+
+	acc, err := server.DB.GetAccount(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeError(c, http.StatusNotFound, "Account not found for ID: "+id)
+			return
+		}
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	var creds s.Credentials
+	if err := c.ShouldBindJSON(&creds); err != nil {
+		// the binding error may quote the body, so don't echo it
+		writeError(c, http.StatusBadRequest, "invalid credentials body")
+		return
+	}
+
+	if creds.Password == "" {
+		writeError(c, http.StatusBadRequest, "password is empty")
+		return
+	}
+
+	if err := server.SetCredentials(acc.ID, creds); err != nil {
+		if err == s.ErrVaultDisabled {
+			writeError(c, http.StatusServiceUnavailable, err.Error())
+			return
+		}
+		writeError(c, http.StatusInternalServerError, "could not store credentials")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
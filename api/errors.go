@@ -0,0 +1,113 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/gin-gonic/gin"
+
+	"bot-api/logging"
+)
+
+// header carrying the id of a request, taken from the client if it sent one
+const requestIDHeader = "X-Request-ID"
+
+// context key holding the request id
+const requestIDContextKey = "request_id"
+
+// ErrorResponse is the body of every error response
+type ErrorResponse struct {
+	Error APIError `json:"error"`
+}
+
+// APIError describes what went wrong with a request
+type APIError struct {
+	Code      string      `json:"code"` // machine readable, derived from the status, e.g. "not_found"
+	Message   string      `json:"message"`
+	Details   interface{} `json:"details,omitempty"` // e.g. the invalid fields of a body
+	RequestID string      `json:"request_id"`
+}
+
+// error codes of the statuses returned by the api
+var errorCodes = map[int]string{
+	http.StatusBadRequest:            "bad_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusMethodNotAllowed:      "method_not_allowed",
+	http.StatusConflict:              "conflict",
+	http.StatusPreconditionFailed:    "precondition_failed",
+	http.StatusUnprocessableEntity:   "validation_failed",
+	http.StatusInternalServerError:   "internal_error",
+	http.StatusBadGateway:            "bad_gateway",
+	http.StatusServiceUnavailable:    "unavailable",
+	http.StatusRequestEntityTooLarge: "too_large",
+}
+
+func errorCode(status int) string {
+	if code, ok := errorCodes[status]; ok {
+		return code
+	}
+
+	return "error"
+}
+
+// writes an error response
+func writeError(c *gin.Context, status int, message string) {
+	writeErrorDetails(c, status, message, nil)
+}
+
+// writes an error response with details, e.g. a list of invalid fields
+func writeErrorDetails(c *gin.Context, status int, message string, details interface{}) {
+	c.IndentedJSON(status, errorResponse(c, status, message, details))
+}
+
+// writes an error response and stops the handler chain
+func abortError(c *gin.Context, status int, message string) {
+	c.AbortWithStatusJSON(status, errorResponse(c, status, message, nil))
+}
+
+func errorResponse(c *gin.Context, status int, message string, details interface{}) ErrorResponse {
+	return ErrorResponse{APIError{
+		Code:      errorCode(status),
+		Message:   message,
+		Details:   details,
+		RequestID: c.GetString(requestIDContextKey),
+	}}
+}
+
+// requestID assigns every request an id, echoed in the X-Request-ID header and in error responses
+func requestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIDHeader)
+		if id == "" || len(id) > 64 {
+			buf := make([]byte, 8)
+			rand.Read(buf)
+			id = hex.EncodeToString(buf)
+		}
+
+		c.Set(requestIDContextKey, id)
+		c.Request = c.Request.WithContext(logging.WithRequestID(c.Request.Context(), id))
+		c.Header(requestIDHeader, id)
+		c.Next()
+	}
+}
+
+// recovery turns panics in handlers into internal error responses
+func recovery() gin.HandlerFunc {
+	return gin.CustomRecovery(func(c *gin.Context, err interface{}) {
+		slog.ErrorContext(c.Request.Context(), "panic handling request", "method", c.Request.Method, "path", c.Request.URL.Path, "error", err, "stack", string(debug.Stack()))
+		abortError(c, http.StatusInternalServerError, "internal server error")
+	})
+}
+
+func noRoute(c *gin.Context) {
+	writeError(c, http.StatusNotFound, "no route for "+c.Request.Method+" "+c.Request.URL.Path)
+}
+
+func noMethod(c *gin.Context) {
+	writeError(c, http.StatusMethodNotAllowed, "method "+c.Request.Method+" is not allowed for "+c.Request.URL.Path)
+}
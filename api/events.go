@@ -0,0 +1,210 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/websocket"
+
+	s "bot-api/server"
+)
+
+// sent first when a stream is resumed from an event that is no longer buffered, the client missed events
+// and should reload the state it tracks
+const eventsMissed = "events.missed"
+
+// how often an idle event stream sends a comment so proxies keep the connection open
+const eventKeepAlive = 15 * time.Second
+
+// how long sending an event to a websocket client may take. a client that stops reading without closing
+// its connection is dropped after this instead of blocking its handler
+const eventWriteTimeout = 10 * time.Second
+
+// streams events as Server-Sent Events. filtered with ?account_id= and ?type= (comma separated or
+// repeated), resumed from the Last-Event-ID header or ?last_event_id=
+func streamEvents(c *gin.Context) {
+	filter, lastID, err := eventSubscription(c, c.GetHeader("Last-Event-ID"))
+	if err != nil {
+		writeError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	sub, replay, complete := server.Events.Subscribe(filter, lastID)
+	defer server.Events.Unsubscribe(sub)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	if !complete {
+		writeSSE(c, missedEvent(lastID))
+	}
+	for _, e := range replay {
+		writeSSE(c, e)
+	}
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				// dropped for falling behind, the client reconnects with its last event id
+				return
+			}
+			writeSSE(c, e)
+		case <-keepAlive.C:
+			fmt.Fprint(c.Writer, ": keep-alive\n\n")
+		case <-c.Request.Context().Done():
+			return
+		}
+		c.Writer.Flush()
+	}
+}
+
+func writeSSE(c *gin.Context, e s.Event) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "error encoding event", "event_id", e.ID, "type", e.Type, "error", err)
+		return
+	}
+
+	if e.ID != 0 {
+		fmt.Fprintf(c.Writer, "id: %d\n", e.ID)
+	}
+	fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", e.Type, data)
+}
+
+// streams events over a WebSocket as one JSON text message per event, with the same filters as
+// streamEvents. the stream is resumed with ?last_event_id=
+func streamEventsWebSocket(c *gin.Context) {
+	filter, lastID, err := eventSubscription(c, "")
+	if err != nil {
+		writeError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// clients authenticate with an api key, so requests from any origin are accepted
+	ws := websocket.Server{Handler: func(conn *websocket.Conn) {
+		sub, replay, complete := server.Events.Subscribe(filter, lastID)
+		defer server.Events.Unsubscribe(sub)
+
+		if !complete {
+			replay = append([]s.Event{missedEvent(lastID)}, replay...)
+		}
+		for _, e := range replay {
+			if err := sendWebSocketEvent(conn, e); err != nil {
+				return
+			}
+		}
+
+		// the client doesn't send anything, reading only notices when it goes away
+		closed := make(chan struct{})
+		go func() {
+			var msg string
+			for websocket.Message.Receive(conn, &msg) == nil {
+			}
+			close(closed)
+		}()
+
+		for {
+			select {
+			case e, ok := <-sub.C:
+				if !ok {
+					return
+				}
+				if err := sendWebSocketEvent(conn, e); err != nil {
+					return
+				}
+			case <-closed:
+				return
+			}
+		}
+	}}
+
+	ws.ServeHTTP(c.Writer, c.Request)
+}
+
+func sendWebSocketEvent(conn *websocket.Conn, e s.Event) error {
+	if err := conn.SetWriteDeadline(time.Now().Add(eventWriteTimeout)); err != nil {
+		return err
+	}
+
+	return websocket.JSON.Send(conn, e)
+}
+
+func missedEvent(lastID int64) s.Event {
+	return s.Event{Type: eventsMissed, Time: time.Now(), Data: gin.H{"after": lastID}}
+}
+
+// reads the filters and the event to resume after of an event stream. lastEventID is the Last-Event-ID
+// header, ?last_event_id= is used when it is empty
+func eventSubscription(c *gin.Context, lastEventID string) (s.EventFilter, int64, error) {
+	filter := s.EventFilter{AccountIDs: map[int]bool{}, Types: map[string]bool{}}
+
+	for _, v := range queryList(c, "account_id") {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return filter, 0, fmt.Errorf("invalid account_id: %s", v)
+		}
+		filter.AccountIDs[id] = true
+	}
+
+	for _, v := range queryList(c, "type") {
+		if !validEventType(v) {
+			return filter, 0, fmt.Errorf("invalid type: %s, must be one of %s", v, strings.Join(s.EventTypes, ", "))
+		}
+		filter.Types[v] = true
+	}
+
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+	var lastID int64
+	if lastEventID != "" {
+		var err error
+		if lastID, err = strconv.ParseInt(lastEventID, 10, 64); err != nil || lastID < 0 {
+			return filter, 0, fmt.Errorf("invalid last event id: %s", lastEventID)
+		}
+	}
+
+	return filter, lastID, nil
+}
+
+// returns the values of a query parameter that may be repeated and/or comma separated
+func queryList(c *gin.Context, name string) []string {
+	var values []string
+	for _, v := range c.QueryArray(name) {
+		for _, part := range strings.Split(v, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				values = append(values, part)
+			}
+		}
+	}
+
+	return values
+}
+
+func validEventType(t string) bool {
+	for _, et := range s.EventTypes {
+		if et == t {
+			return true
+		}
+	}
+
+	return false
+}
+
+// return the queue and throughput stats of the subscribers of the event bus
+func getEventSubscribers(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, server.Bus.Stats())
+}
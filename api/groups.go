@@ -0,0 +1,317 @@
+package api
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"bot-api/db"
+	s "bot-api/server"
+)
+
+// replaces the tags of an account
+func putAccountTags(c *gin.Context) {
+	acc, ok := accountParam(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Tags []string `json:"tags"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	tags := make([]string, 0, len(req.Tags))
+	for _, t := range req.Tags {
+		tag, err := s.NormalizeLabel(t)
+		if err != nil {
+			writeError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		tags = append(tags, tag)
+	}
+
+	if err := server.DB.SetAccountTags(acc.ID, tags); err != nil {
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	acc, err := server.DB.GetAccount(strconv.Itoa(acc.ID))
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, acc)
+}
+
+// replaces the notes of an account
+func putAccountNotes(c *gin.Context) {
+	acc, ok := accountParam(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Notes string `json:"notes"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := server.DB.UpdateAccountNotes(acc.ID, req.Notes); err != nil {
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// read back for the updated_at set by the database, which the ETag is derived from
+	updated, err := server.DB.GetAccount(strconv.Itoa(acc.ID))
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.Header("ETag", accountETag(updated))
+	c.IndentedJSON(http.StatusOK, updated)
+}
+
+func getGroups(c *gin.Context) {
+	groups, err := server.DB.GetAccountGroups()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, groups)
+}
+
+func createGroup(c *gin.Context) {
+	var req struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		AccountIDs  []int  `json:"account_ids"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	name, err := s.NormalizeLabel(req.Name)
+	if err != nil {
+		writeError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if _, err := server.DB.GetAccountGroup(name); err != sql.ErrNoRows {
+		if err == nil {
+			writeError(c, http.StatusConflict, "group already exists: "+name)
+			return
+		}
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	id, err := server.DB.InsertAccountGroup(name, req.Description)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if len(req.AccountIDs) > 0 {
+		if err := server.DB.AddAccountGroupMembers(id, req.AccountIDs); err != nil {
+			writeError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	group, err := server.DB.GetAccountGroup(name)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.IndentedJSON(http.StatusCreated, group)
+}
+
+func getGroup(c *gin.Context) {
+	group, ok := groupParam(c)
+	if !ok {
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, group)
+}
+
+// deletes a group, its member accounts are kept
+func deleteGroup(c *gin.Context) {
+	group, ok := groupParam(c)
+	if !ok {
+		return
+	}
+
+	if err := server.DB.DeleteAccountGroup(group.ID); err != nil {
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"message": "group deleted"})
+}
+
+func addGroupMembers(c *gin.Context) {
+	group, ok := groupParam(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		AccountIDs []int `json:"account_ids"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	for _, id := range req.AccountIDs {
+		acc, err := server.DB.GetAccount(strconv.Itoa(id))
+		if err == nil && acc.DeletedAt != nil {
+			err = sql.ErrNoRows
+		}
+		if err != nil {
+			if err == sql.ErrNoRows {
+				writeError(c, http.StatusUnprocessableEntity, "Account not found for ID: "+strconv.Itoa(id))
+				return
+			}
+			writeError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	if err := server.DB.AddAccountGroupMembers(group.ID, req.AccountIDs); err != nil {
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	group, err := server.DB.GetAccountGroup(group.Name)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, group)
+}
+
+func removeGroupMember(c *gin.Context) {
+	group, ok := groupParam(c)
+	if !ok {
+		return
+	}
+
+	accountID, err := strconv.Atoi(c.Param("account_id"))
+	if err != nil {
+		writeError(c, http.StatusBadRequest, "Invalid account ID")
+		return
+	}
+
+	if err := server.DB.RemoveAccountGroupMember(group.ID, accountID); err != nil {
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"message": "account removed from group"})
+}
+
+// starts a script on every account in a group. the response lists the outcome per account, accounts
+// that are already running are reported and skipped
+func startGroupBots(c *gin.Context) {
+	group, ok := groupParam(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Script string   `json:"script"`
+		Params []string `json:"params"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if req.Script == "" {
+		writeError(c, http.StatusBadRequest, "script is empty")
+		return
+	}
+
+	results, err := server.StartGroup(group.Name, req.Script, req.Params)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"group": group.Name, "results": results})
+}
+
+// stops the bots of every account in a group
+func stopGroupBots(c *gin.Context) {
+	group, ok := groupParam(c)
+	if !ok {
+		return
+	}
+
+	results, err := server.StopGroup(group.Name)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"group": group.Name, "results": results})
+}
+
+// looks up the account named by the :id parameter, writing the error response if it can't be found.
+// soft deleted accounts are treated as not found
+func accountParam(c *gin.Context) (db.Account, bool) {
+	return lookupAccount(c, false)
+}
+
+// looks up the account named by the :id parameter, optionally including soft deleted accounts
+func lookupAccount(c *gin.Context, includeDeleted bool) (db.Account, bool) {
+	id := c.Param("id")
+
+	acc, err := server.DB.GetAccount(id)
+	if err == nil && acc.DeletedAt != nil && !includeDeleted {
+		err = sql.ErrNoRows
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeError(c, http.StatusNotFound, "Account not found for ID: "+id)
+			return acc, false
+		}
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return acc, false
+	}
+
+	return acc, true
+}
+
+// looks up the group named by the :name parameter, writing the error response if it can't be found
+func groupParam(c *gin.Context) (db.AccountGroup, bool) {
+	name := c.Param("name")
+
+	group, err := server.DB.GetAccountGroup(name)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeError(c, http.StatusNotFound, "group not found: "+name)
+			return group, false
+		}
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return group, false
+	}
+
+	return group, true
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// routes called every few seconds by every bot or scraper, their successful requests are only logged at
+// debug level so they don't drown out the rest
+var quietRoutes = map[string]bool{
+	"POST /heartbeat": true,
+	"GET /metrics":    true,
+}
+
+// requestLogger logs every request once it's answered. the path is logged without its query string, which
+// may carry tokens
+func requestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= http.StatusInternalServerError:
+			level = slog.LevelError
+		case status < http.StatusBadRequest && quietRoutes[c.Request.Method+" "+routePath(c)]:
+			level = slog.LevelDebug
+		}
+
+		route := c.FullPath()
+		if route == "" {
+			route = unmatchedRoute
+		}
+
+		slog.Log(c.Request.Context(), level, "request",
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"route", route,
+			"status", status,
+			"latency_ms", time.Since(start).Milliseconds(),
+			"client_ip", c.ClientIP(),
+		)
+	}
+}
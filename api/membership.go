@@ -0,0 +1,79 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	s "bot-api/server"
+)
+
+// default window of GET /accounts/memberships/expiring
+const defaultExpiringDays = 7
+
+// sets whether an account is a member and when its membership expires
+func putAccountMembership(c *gin.Context) {
+	acc, ok := accountParam(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Member  bool    `json:"member"`
+		Expires *string `json:"expires"` // YYYY-MM-DD, last day of membership
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !req.Member {
+		req.Expires = nil
+	}
+
+	if req.Expires != nil {
+		if err := s.ValidateMembershipExpiry(*req.Expires); err != nil {
+			writeError(c, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+	}
+
+	if err := server.SetMembership(acc.ID, req.Member, req.Expires); err != nil {
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// read back for the updated_at set by the database, which the ETag is derived from
+	updated, err := server.DB.GetAccount(strconv.Itoa(acc.ID))
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.Header("ETag", accountETag(updated))
+	c.IndentedJSON(http.StatusOK, updated)
+}
+
+// return the member accounts whose membership expires within ?days= days (default 7), including those
+// that have already expired
+func getExpiringMemberships(c *gin.Context) {
+	days := defaultExpiringDays
+	if d := c.Query("days"); d != "" {
+		n, err := strconv.Atoi(d)
+		if err != nil || n < 0 {
+			writeError(c, http.StatusBadRequest, "invalid days: "+d)
+			return
+		}
+		days = n
+	}
+
+	accounts, err := server.ExpiringMemberships(days, time.Now())
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"days": days, "accounts": accounts})
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"bot-api/metrics"
+)
+
+// request metrics, registered on the server's registry by initHTTPMetrics
+var (
+	httpRequests        *metrics.CounterVec
+	httpRequestDuration *metrics.HistogramVec
+)
+
+// route label of requests that matched no route, so unknown paths don't each get their own series
+const unmatchedRoute = "unmatched"
+
+func initHTTPMetrics(reg *metrics.Registry) {
+	httpRequests = reg.Counter("botapi_http_requests_total", "HTTP requests by method, route and status.", "method", "route", "status")
+	httpRequestDuration = reg.Histogram("botapi_http_request_duration_seconds", "Time to answer HTTP requests by method and route.", metrics.DefaultBuckets, "method", "route")
+}
+
+// registerMetrics serves the metrics outside of the versioned routes, where scrapers expect them
+func registerMetrics(router *gin.Engine) {
+	router.GET("/metrics", getMetrics)
+}
+
+// httpMetrics counts and times every request by its route pattern, e.g. /v1/accounts/:id
+func httpMetrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = unmatchedRoute
+		}
+		method := c.Request.Method
+
+		httpRequests.Inc(method, route, strconv.Itoa(c.Writer.Status()))
+		httpRequestDuration.Observe(time.Since(start).Seconds(), method, route)
+	}
+}
+
+// returns the metrics in the Prometheus text format. when a metrics token is configured it must be sent
+// as a bearer token
+func getMetrics(c *gin.Context) {
+	if token := server.Config.MetricsToken; token != "" && subtle.ConstantTimeCompare([]byte(bearerToken(c)), []byte(token)) != 1 {
+		writeError(c, http.StatusUnauthorized, "missing or invalid metrics token")
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := server.Metrics.Write(&buf); err != nil {
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.Data(http.StatusOK, metrics.ContentType, buf.Bytes())
+}
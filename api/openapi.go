@@ -0,0 +1,101 @@
+package api
+
+import (
+	"crypto/sha256"
+	_ "embed"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+
+	s "bot-api/server"
+)
+
+// openapi.json documents every route, see openapi_test.go for the checks keeping it in sync with the router
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+//go:embed docs.html
+var docsPage []byte
+
+// the swagger ui release loaded by docs.html, its only third party code
+const swaggerUIAssets = "https://unpkg.com/swagger-ui-dist@5.17.14/"
+
+// content security policy of the docs page. api keys entered in it can only be sent to this server: the
+// page runs its own inline script and the pinned swagger ui release, and may only connect to itself
+var docsPolicy = "default-src 'none'; script-src " + swaggerUIAssets + " '" + inlineScriptHash(docsPage) + "'; " +
+	"style-src " + swaggerUIAssets + " 'unsafe-inline'; img-src 'self' data:; connect-src 'self'; " +
+	"form-action 'none'; base-uri 'none'; frame-ancestors 'none'"
+
+var inlineScript = regexp.MustCompile(`(?s)<script>(.*?)</script>`)
+
+// inlineScriptHash returns the CSP hash source of the inline script of a page
+func inlineScriptHash(page []byte) string {
+	m := inlineScript.FindSubmatch(page)
+	if m == nil {
+		return "none"
+	}
+
+	sum := sha256.Sum256(m[1])
+	return "sha256-" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+var (
+	openAPIOnce     sync.Once
+	openAPIDocument []byte
+	openAPIErr      error
+)
+
+// registerDocs serves the api description outside of the versioned routes
+func registerDocs(router *gin.Engine) {
+	router.GET("/openapi.json", getOpenAPI)
+	router.GET("/docs", getDocs)
+}
+
+// openAPI returns the served document: the embedded spec with the heartbeat JSON Schema as the Heartbeat
+// component, so the heartbeat contract is only defined once
+func openAPI() ([]byte, error) {
+	openAPIOnce.Do(func() {
+		var doc map[string]interface{}
+		if openAPIErr = json.Unmarshal(openAPISpec, &doc); openAPIErr != nil {
+			return
+		}
+
+		var heartbeat map[string]interface{}
+		if openAPIErr = json.Unmarshal(s.HeartbeatSchema, &heartbeat); openAPIErr != nil {
+			return
+		}
+		// the id and dialect are those of the standalone schema, the document's apply inside the spec
+		delete(heartbeat, "$id")
+		delete(heartbeat, "$schema")
+
+		components := doc["components"].(map[string]interface{})
+		components["schemas"].(map[string]interface{})["Heartbeat"] = heartbeat
+
+		openAPIDocument, openAPIErr = json.MarshalIndent(doc, "", "  ")
+	})
+
+	return openAPIDocument, openAPIErr
+}
+
+// return the OpenAPI 3 description of the api
+func getOpenAPI(c *gin.Context) {
+	doc, err := openAPI()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.Data(http.StatusOK, "application/json", doc)
+}
+
+// return the interactive documentation page, rendered from /openapi.json
+func getDocs(c *gin.Context) {
+	c.Header("Content-Security-Policy", docsPolicy)
+	c.Header("Referrer-Policy", "no-referrer")
+	c.Data(http.StatusOK, "text/html; charset=utf-8", docsPage)
+}
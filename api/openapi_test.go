@@ -0,0 +1,226 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	s "bot-api/server"
+)
+
+var operationMethods = []string{"get", "post", "put", "patch", "delete"}
+
+// matches the :name parameters of gin paths
+var ginParam = regexp.MustCompile(`:([a-z_]+)`)
+
+func testRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	registerRoutes(router.Group(apiVersionPrefix))
+	registerDocs(router)
+	registerMetrics(router)
+
+	return router
+}
+
+func servedSpec(t *testing.T) map[string]interface{} {
+	t.Helper()
+
+	raw, err := openAPI()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	return doc
+}
+
+// returns "METHOD /path" for every operation of the spec. paths with their own server are served from
+// that server instead of /v1
+func specOperations(t *testing.T, doc map[string]interface{}) map[string]map[string]interface{} {
+	t.Helper()
+
+	ops := map[string]map[string]interface{}{}
+	for path, v := range doc["paths"].(map[string]interface{}) {
+		item := v.(map[string]interface{})
+
+		prefix := apiVersionPrefix
+		if servers, ok := item["servers"].([]interface{}); ok {
+			prefix = strings.TrimSuffix(servers[0].(map[string]interface{})["url"].(string), "/")
+		}
+
+		for _, method := range operationMethods {
+			if op, ok := item[method].(map[string]interface{}); ok {
+				ops[strings.ToUpper(method)+" "+prefix+path] = op
+			}
+		}
+	}
+
+	return ops
+}
+
+func TestOpenAPICoversRoutes(t *testing.T) {
+	ops := specOperations(t, servedSpec(t))
+
+	routes := map[string]bool{}
+	for _, r := range testRouter().Routes() {
+		routes[r.Method+" "+ginParam.ReplaceAllString(r.Path, "{$1}")] = true
+	}
+
+	var missing, extra []string
+	for route := range routes {
+		if _, ok := ops[route]; !ok {
+			missing = append(missing, route)
+		}
+	}
+	for op := range ops {
+		if !routes[op] {
+			extra = append(extra, op)
+		}
+	}
+	sort.Strings(missing)
+	sort.Strings(extra)
+
+	for _, route := range missing {
+		t.Errorf("route %s is not documented in openapi.json", route)
+	}
+	for _, op := range extra {
+		t.Errorf("openapi.json documents %s, which is not a route", op)
+	}
+}
+
+func TestOpenAPIPathParameters(t *testing.T) {
+	doc := servedSpec(t)
+	params := doc["components"].(map[string]interface{})["parameters"].(map[string]interface{})
+	pathParam := regexp.MustCompile(`\{([a-z_]+)\}`)
+
+	for name, op := range specOperations(t, doc) {
+		declared := map[string]bool{}
+		list, _ := op["parameters"].([]interface{})
+		for _, p := range list {
+			param := p.(map[string]interface{})
+			if ref, ok := param["$ref"].(string); ok {
+				param = params[strings.TrimPrefix(ref, "#/components/parameters/")].(map[string]interface{})
+			}
+			if param["in"] == "path" {
+				declared[param["name"].(string)] = true
+			}
+		}
+
+		for _, m := range pathParam.FindAllStringSubmatch(name, -1) {
+			if !declared[m[1]] {
+				t.Errorf("%s does not declare the path parameter %s", name, m[1])
+			}
+			delete(declared, m[1])
+		}
+		for p := range declared {
+			t.Errorf("%s declares the path parameter %s, which is not in its path", name, p)
+		}
+	}
+}
+
+func TestOpenAPIRefsResolve(t *testing.T) {
+	doc := servedSpec(t)
+
+	var walk func(path string, v interface{})
+	walk = func(path string, v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			if ref, ok := v["$ref"].(string); ok {
+				var target interface{} = doc
+				for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+					m, ok := target.(map[string]interface{})
+					if !ok {
+						target = nil
+						break
+					}
+					target = m[part]
+				}
+				if target == nil {
+					t.Errorf("%s: unresolved $ref %s", path, ref)
+				}
+			}
+			for k, child := range v {
+				walk(path+"/"+k, child)
+			}
+		case []interface{}:
+			for _, child := range v {
+				walk(path+"/[]", child)
+			}
+		}
+	}
+
+	walk("#", doc)
+}
+
+func TestOpenAPIHeartbeatSchema(t *testing.T) {
+	doc := servedSpec(t)
+	got := doc["components"].(map[string]interface{})["schemas"].(map[string]interface{})["Heartbeat"]
+
+	var want map[string]interface{}
+	if err := json.Unmarshal(s.HeartbeatSchema, &want); err != nil {
+		t.Fatal(err)
+	}
+	delete(want, "$id")
+	delete(want, "$schema")
+
+	if !reflect.DeepEqual(got, want) {
+		t.Error("Heartbeat component of the served document differs from the heartbeat JSON Schema")
+	}
+}
+
+func TestServeOpenAPI(t *testing.T) {
+	router := testRouter()
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /openapi.json: status %d", rec.Code)
+	}
+	var doc struct {
+		OpenAPI string `json:"openapi"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil || doc.OpenAPI != "3.1.0" {
+		t.Errorf("GET /openapi.json: openapi = %q, err = %v", doc.OpenAPI, err)
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/docs", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"/openapi.json"`) {
+		t.Errorf("GET /docs: status %d, page does not load /openapi.json", rec.Code)
+	}
+}
+
+// matches the urls the docs page loads
+var pageURL = regexp.MustCompile(`(?:src|href)="(https?://[^"]+)"`)
+
+func TestDocsPolicy(t *testing.T) {
+	rec := httptest.NewRecorder()
+	testRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/docs", nil))
+
+	policy := rec.Header().Get("Content-Security-Policy")
+	if hash := inlineScriptHash(docsPage); hash == "none" || !strings.Contains(policy, "'"+hash+"'") {
+		t.Errorf("policy doesn't allow the inline script of the page: %s", policy)
+	}
+
+	for _, m := range pageURL.FindAllStringSubmatch(rec.Body.String(), -1) {
+		if !strings.HasPrefix(m[1], swaggerUIAssets) {
+			t.Errorf("docs page loads %s, the policy only allows %s", m[1], swaggerUIAssets)
+		}
+	}
+	if strings.Contains(rec.Body.String(), "persistAuthorization") {
+		t.Error("docs page keeps api keys in local storage")
+	}
+}
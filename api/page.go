@@ -0,0 +1,80 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"bot-api/db"
+)
+
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+// reads the limit, cursor and sort query parameters of a list endpoint. sort is a field name, prefixed
+// with "-" for descending order
+func pageRequest(c *gin.Context) (db.PageRequest, error) {
+	page := db.PageRequest{Limit: defaultPageLimit, Cursor: c.Query("cursor")}
+
+	if limit := c.Query("limit"); limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil || l < 1 || l > maxPageLimit {
+			return page, fmt.Errorf("invalid limit: %s, must be between 1 and %d", limit, maxPageLimit)
+		}
+		page.Limit = l
+	}
+
+	sort := c.Query("sort")
+	if strings.HasPrefix(sort, "-") {
+		page.Desc = true
+		sort = sort[1:]
+	}
+	page.Sort = sort
+
+	return page, nil
+}
+
+// reads the page of a list that the legacy unversioned routes returned in full before pagination. their
+// clients that ask for neither a limit nor a cursor still get every item, /v1 clients get the default limit
+func legacyPageRequest(c *gin.Context) (db.PageRequest, error) {
+	page, err := pageRequest(c)
+	if err == nil && c.GetBool(deprecatedContextKey) && c.Query("limit") == "" && c.Query("cursor") == "" {
+		page.Limit = 0
+	}
+
+	return page, err
+}
+
+// writes a page of a list. the body stays a plain array; the cursor of the next page, if any, is sent in
+// the X-Next-Cursor header and as a Link header with rel="next"
+func writePage(c *gin.Context, items interface{}, next string) {
+	if next != "" {
+		u := url.URL{Path: c.Request.URL.Path}
+		q := c.Request.URL.Query()
+		q.Set("cursor", next)
+		u.RawQuery = q.Encode()
+
+		c.Header("X-Next-Cursor", next)
+		// added, deprecated routes already have a Link to their successor
+		c.Writer.Header().Add("Link", fmt.Sprintf(`<%s>; rel="next"`, u.String()))
+	}
+
+	c.IndentedJSON(http.StatusOK, items)
+}
+
+// writes the error of a list query, invalid parameters are the client's fault
+func listError(c *gin.Context, err error) {
+	if errors.Is(err, db.ErrInvalidListQuery) {
+		writeError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeError(c, http.StatusInternalServerError, err.Error())
+}
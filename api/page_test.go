@@ -0,0 +1,51 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLegacyListsAreUnpaginated(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// answers with the limit of the page request and a next page
+	list := func(c *gin.Context) {
+		page, err := legacyPageRequest(c)
+		if err != nil {
+			writeError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		c.Header("X-Limit", strconv.Itoa(page.Limit))
+		writePage(c, []int{}, "next")
+	}
+	router := gin.New()
+	router.GET(apiVersionPrefix+"/accounts", list)
+	router.Group("/", deprecated()).GET("/accounts", list)
+
+	for _, tc := range []struct {
+		path  string
+		limit int
+	}{
+		{"/accounts", 0},
+		{"/accounts?limit=5", 5},
+		{"/accounts?cursor=abc", defaultPageLimit},
+		{"/v1/accounts", defaultPageLimit},
+	} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		if got := rec.Header().Get("X-Limit"); got != strconv.Itoa(tc.limit) {
+			t.Errorf("GET %s: limit %s, want %d", tc.path, got, tc.limit)
+		}
+	}
+
+	// the next page link doesn't replace the successor link of a deprecated route
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/accounts?limit=5", nil))
+	if links := rec.Header().Values("Link"); len(links) != 2 {
+		t.Errorf("deprecated page has Link headers %q, want the successor and the next page", links)
+	}
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"bot-api/db"
+	"bot-api/prices"
+)
+
+// return the items in a bot's inventory from its latest heartbeat, valued with the current prices
+func getBotInventory(c *gin.Context) {
+	state, ok := accountState(c)
+	if !ok {
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, server.Prices.ValueItems(state.Inventory))
+}
+
+// return the items in a bot's bank from its latest heartbeat, valued with the current prices. the
+// bank value reported by the bot itself is included as reported_value
+func getBotBank(c *gin.Context) {
+	state, ok := accountState(c)
+	if !ok {
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, struct {
+		prices.Valuation
+		ReportedValue *int64 `json:"reported_value,omitempty"`
+	}{server.Prices.ValueItems(state.Bank), state.BankValue})
+}
+
+// looks up the latest state of the account in the id param, writing an error response if there is none
+func accountState(c *gin.Context) (db.BotState, bool) {
+	id := c.Param("id")
+
+	acc, err := server.DB.GetAccount(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeError(c, http.StatusNotFound, "Account not found for ID: "+id)
+			return db.BotState{}, false
+		}
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return db.BotState{}, false
+	}
+
+	state, _ := latestState(acc.ID, acc.Email)
+	if state == nil {
+		writeError(c, http.StatusNotFound, "No state reported for account: "+acc.Username)
+		return db.BotState{}, false
+	}
+
+	return *state, true
+}
+
+// return the source, size and freshness of the price book
+func getPriceStatus(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, server.Prices.Status())
+}
+
+// fetch new prices from the configured price source
+func refreshPrices(c *gin.Context) {
+	if err := server.Prices.Refresh(c.Request.Context()); err != nil {
+		status := http.StatusBadGateway
+		if err == prices.ErrNoProvider {
+			status = http.StatusServiceUnavailable
+		}
+		writeError(c, status, err.Error())
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, server.Prices.Status())
+}
+
+// return the current price of an item
+func getItemPrice(c *gin.Context) {
+	itemID, err := strconv.Atoi(c.Param("item_id"))
+	if err != nil {
+		writeError(c, http.StatusBadRequest, "Invalid item ID")
+		return
+	}
+
+	p, ok := server.Prices.Lookup(itemID)
+	if !ok {
+		writeError(c, http.StatusNotFound, "No price for item: "+c.Param("item_id"))
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, struct {
+		prices.Price
+		Stale bool `json:"stale"`
+	}{p, server.Prices.IsStale(p)})
+}
@@ -0,0 +1,303 @@
+package api
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"bot-api/db"
+	s "bot-api/server"
+)
+
+func getWebhooks(c *gin.Context) {
+	webhooks, err := server.DB.GetWebhooks()
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, webhooks)
+}
+
+// creates a webhook, the signing secret is only returned in this response and when it is rotated
+func createWebhook(c *gin.Context) {
+	var req struct {
+		URL         string   `json:"url"`
+		Description string   `json:"description"`
+		EventTypes  []string `json:"event_types"`
+		Format      string   `json:"format"`
+		AccountIDs  []int    `json:"account_ids"`
+		Groups      []string `json:"groups"`
+		RateLimit   int      `json:"rate_limit"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	w := db.Webhook{
+		URL:         strings.TrimSpace(req.URL),
+		Description: req.Description,
+		EventTypes:  nonNil(req.EventTypes),
+		Format:      req.Format,
+		AccountIDs:  nonNilInts(req.AccountIDs),
+		Groups:      nonNil(req.Groups),
+		RateLimit:   req.RateLimit,
+	}
+	if w.Format == "" {
+		w.Format = s.WebhookFormatJSON
+	}
+
+	if errs := s.ValidateWebhook(&w); len(errs) > 0 {
+		writeErrorDetails(c, http.StatusUnprocessableEntity, "invalid webhook", errs)
+		return
+	}
+
+	w, err := server.CreateWebhook(w)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.IndentedJSON(http.StatusCreated, webhookWithSecret(w))
+}
+
+func getWebhook(c *gin.Context) {
+	w, ok := webhookParam(c)
+	if !ok {
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, w)
+}
+
+// updates the given fields of a webhook. "rotate_secret": true replaces the signing secret, which is then
+// returned in the response
+func patchWebhook(c *gin.Context) {
+	w, ok := webhookParam(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		URL          *string   `json:"url"`
+		Description  *string   `json:"description"`
+		EventTypes   *[]string `json:"event_types"`
+		Format       *string   `json:"format"`
+		AccountIDs   *[]int    `json:"account_ids"`
+		Groups       *[]string `json:"groups"`
+		RateLimit    *int      `json:"rate_limit"`
+		Active       *bool     `json:"active"`
+		RotateSecret bool      `json:"rotate_secret"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if req.URL != nil {
+		w.URL = strings.TrimSpace(*req.URL)
+	}
+	if req.Description != nil {
+		w.Description = *req.Description
+	}
+	if req.EventTypes != nil {
+		w.EventTypes = nonNil(*req.EventTypes)
+	}
+	if req.Format != nil {
+		w.Format = *req.Format
+	}
+	if req.AccountIDs != nil {
+		w.AccountIDs = nonNilInts(*req.AccountIDs)
+	}
+	if req.Groups != nil {
+		w.Groups = nonNil(*req.Groups)
+	}
+	if req.RateLimit != nil {
+		w.RateLimit = *req.RateLimit
+	}
+	if req.Active != nil {
+		w.Active = *req.Active
+	}
+
+	if errs := s.ValidateWebhook(&w); len(errs) > 0 {
+		writeErrorDetails(c, http.StatusUnprocessableEntity, "invalid webhook", errs)
+		return
+	}
+
+	if req.RotateSecret {
+		secret, err := s.NewWebhookSecret()
+		if err != nil {
+			writeError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.Secret = secret
+	}
+
+	if err := server.DB.UpdateWebhook(w); err != nil {
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if req.RotateSecret {
+		c.IndentedJSON(http.StatusOK, webhookWithSecret(w))
+		return
+	}
+	c.IndentedJSON(http.StatusOK, w)
+}
+
+// deletes a webhook along with its delivery log
+func deleteWebhook(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		writeError(c, http.StatusBadRequest, "Invalid webhook ID")
+		return
+	}
+
+	if err := server.DB.DeleteWebhook(id); err != nil {
+		if err == sql.ErrNoRows {
+			writeError(c, http.StatusNotFound, "webhook not found")
+			return
+		}
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"message": "webhook deleted"})
+}
+
+// returns the delivery log of a webhook, newest first. filtered with ?status= and ?event_type=
+func getWebhookDeliveries(c *gin.Context) {
+	w, ok := webhookParam(c)
+	if !ok {
+		return
+	}
+
+	listWebhookDeliveries(c, db.WebhookDeliveryFilter{WebhookID: w.ID, Status: c.Query("status"), EventType: c.Query("event_type")})
+}
+
+// returns the deliveries of every webhook that failed all their attempts, newest first
+func getWebhookDeadLetters(c *gin.Context) {
+	filter := db.WebhookDeliveryFilter{Status: db.DeliveryDead, EventType: c.Query("event_type")}
+	if id := c.Query("webhook_id"); id != "" {
+		var err error
+		if filter.WebhookID, err = strconv.Atoi(id); err != nil {
+			writeError(c, http.StatusBadRequest, "invalid webhook_id: "+id)
+			return
+		}
+	}
+
+	listWebhookDeliveries(c, filter)
+}
+
+func listWebhookDeliveries(c *gin.Context, filter db.WebhookDeliveryFilter) {
+	switch filter.Status {
+	case "", db.DeliveryPending, db.DeliveryDelivered, db.DeliveryDead, db.DeliverySuppressed:
+	default:
+		writeError(c, http.StatusBadRequest, "invalid status: "+filter.Status+", must be one of pending, delivered, dead, suppressed")
+		return
+	}
+
+	page, err := pageRequest(c)
+	if err != nil {
+		writeError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if page.Sort == "" {
+		page.Sort, page.Desc = "id", true
+	}
+
+	deliveries, next, err := server.DB.ListWebhookDeliveries(filter, page)
+	if err != nil {
+		listError(c, err)
+		return
+	}
+
+	writePage(c, deliveries, next)
+}
+
+// queues a dead letter again with a fresh set of attempts
+func retryWebhookDelivery(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("delivery_id"), 10, 64)
+	if err != nil {
+		writeError(c, http.StatusBadRequest, "Invalid delivery ID")
+		return
+	}
+
+	wd, err := server.DB.GetWebhookDelivery(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeError(c, http.StatusNotFound, "delivery not found")
+			return
+		}
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if err := server.RetryWebhookDelivery(id); err != nil {
+		if err == sql.ErrNoRows {
+			writeError(c, http.StatusConflict, "only dead deliveries can be retried, delivery is "+wd.Status)
+			return
+		}
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	wd, err = server.DB.GetWebhookDelivery(id)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.IndentedJSON(http.StatusAccepted, wd)
+}
+
+// reads the webhook of the :id path parameter, writing the error response if there is none
+func webhookParam(c *gin.Context) (db.Webhook, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		writeError(c, http.StatusBadRequest, "Invalid webhook ID")
+		return db.Webhook{}, false
+	}
+
+	w, err := server.DB.GetWebhook(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeError(c, http.StatusNotFound, "webhook not found")
+			return w, false
+		}
+		writeError(c, http.StatusInternalServerError, err.Error())
+		return w, false
+	}
+
+	return w, true
+}
+
+// a json null list is stored as an empty one
+func nonNil(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+
+	return list
+}
+
+func nonNilInts(list []int) []int {
+	if list == nil {
+		return []int{}
+	}
+
+	return list
+}
+
+func webhookWithSecret(w db.Webhook) interface{} {
+	return struct {
+		db.Webhook
+		Secret string `json:"secret"`
+	}{w, w.Secret}
+}
@@ -2,9 +2,12 @@ package bot
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"os/exec"
+	"strconv"
 	"strings"
+
+	"bot-api/logging"
 )
 
 type Bot struct {
@@ -14,7 +17,15 @@ type Bot struct {
 	Script   string   `json:"script"`
 	Params   []string `json:"params"`
 	Status   string   `json:"status"`
-	PID      int      `json:"pid"` // process id of the dreambot client, 0 if not running. set by the server when bot is started
+	PID      int      `json:"pid"`             // process id of the dreambot client, 0 if not running. set by the server when bot is started
+	World    string   `json:"world,omitempty"` // world type passed to -world, "members" or "f2p" (the default)
+
+	// token the client must present on heartbeats, passed to the script through -params. never serialized
+	HeartbeatToken string `json:"-"`
+
+	// account password from the credential vault, only set while the client is being launched. when empty
+	// the client logs in with the account saved in DreamBot
+	Password string `json:"-"`
 }
 
 func (b *Bot) Start() {
@@ -24,6 +35,9 @@ func (b *Bot) Start() {
 
 	b.Status = "Started"
 	b.startDreamBotClient()
+
+	// the password is only needed to launch the client
+	b.Password = ""
 }
 
 func (b *Bot) Stop() {
@@ -33,13 +47,13 @@ func (b *Bot) Stop() {
 
 	b.Status = "Stopped"
 
-	log.Println("Stopping DreamBot client for bot=" + b.Email + " (currently running script: " + b.Script + ")")
+	slog.Info("stopping dreambot client", b.LogAttrs()...)
 	cmd := exec.Command("taskkill", "/F", "/T", "/PID", fmt.Sprint(b.PID))
 	if err := cmd.Start(); err != nil {
-		log.Fatal(err)
+		logging.Fatal("error stopping dreambot client", append(b.LogAttrs(), "error", err)...)
 	}
 
-	log.Printf("Client stopped with pid %d", cmd.Process.Pid)
+	slog.Debug("taskkill started", append(b.LogAttrs(), "taskkill_pid", cmd.Process.Pid)...)
 }
 
 func (b *Bot) IsRunning() bool {
@@ -50,7 +64,7 @@ func (b *Bot) IsRunning() bool {
 	cmd := exec.Command("cmd", "/C", "tasklist", "/FI", fmt.Sprintf("PID eq %d", b.PID))
 	out, err := cmd.Output()
 	if err != nil {
-		fmt.Print(err, "\n")
+		slog.Error("error listing the client process", append(b.LogAttrs(), "error", err)...)
 		return false
 	}
 
@@ -63,30 +77,81 @@ func (b *Bot) IsRunning() bool {
 }
 
 func (b *Bot) startDreamBotClient() {
-	log.Println("Starting DreamBot client for Bot: " + b.Email + " with script: " + b.Script + "")
+	slog.Info("starting dreambot client", b.LogAttrs()...)
 
 	client_path := "C:\\Users\\Administrator\\DreamBot\\BotData\\client.jar"
 
-	var clientParams = []string{"-jar", client_path, "-account", b.Email, "-script", b.Script, "-world", "f2p", "-covert", "-fresh"}
+	var clientParams = []string{"-jar", client_path}
+	if b.Password != "" {
+		clientParams = append(clientParams, "-username", b.Email, "-password", b.Password)
+	} else {
+		clientParams = append(clientParams, "-account", b.Email)
+	}
+	world := b.World
+	if world == "" {
+		world = "f2p"
+	}
+	clientParams = append(clientParams, "-script", b.Script, "-world", world, "-covert", "-fresh")
 
 	// chech for bot/script specific params
 	if b.Params != nil && len(b.Params) > 0 {
-		log.Println("Found bot specific params: " + fmt.Sprint(b.Params))
+		slog.Debug("found bot specific params", append(b.LogAttrs(), "params", b.Params)...)
+	}
+
+	params := append([]string{}, b.Params...)
+	if b.HeartbeatToken != "" {
+		params = append(params, "heartbeat_token="+b.HeartbeatToken)
+	}
 
+	if len(params) > 0 {
 		// if params doesnt start with -params, insert it at the beginning
-		if !strings.HasPrefix(b.Params[0], "-params") {
+		if !strings.HasPrefix(params[0], "-params") {
 			clientParams = append(clientParams, "-params")
 		}
 
-		clientParams = append(clientParams, b.Params...)
+		clientParams = append(clientParams, params...)
 	}
 
 	cmd := exec.Command("java", clientParams...)
-	log.Println("Starting DreamBot client with command: java " + fmt.Sprint(clientParams))
+	slog.Debug("starting dreambot client", append(b.LogAttrs(), "command", "java", "args", redactParams(clientParams))...)
 	if err := cmd.Start(); err != nil {
-		log.Fatal(err)
+		logging.Fatal("error starting dreambot client", append(b.LogAttrs(), "error", err)...)
 	}
 
-	log.Printf("Client started with pid %d", cmd.Process.Pid)
 	b.PID = cmd.Process.Pid
+	slog.Info("dreambot client started", b.LogAttrs()...)
+}
+
+// LogAttrs are the log attributes of the bot: its account, script and client process
+func (b *Bot) LogAttrs() []any {
+	var accountID any = b.ID
+	if id, err := strconv.Atoi(b.ID); err == nil {
+		accountID = id
+	}
+
+	attrs := []any{"account_id", accountID, "email", b.Email, "username", b.Username}
+	if b.Script != "" {
+		attrs = append(attrs, "script", b.Script)
+	}
+	if b.PID != 0 {
+		attrs = append(attrs, "pid", b.PID)
+	}
+
+	return attrs
+}
+
+// returns a copy of the client params that is safe to log
+func redactParams(params []string) []string {
+	redacted := make([]string, len(params))
+	for i, p := range params {
+		if strings.HasPrefix(p, "heartbeat_token=") {
+			p = "heartbeat_token=REDACTED"
+		}
+		if i > 0 && params[i-1] == "-password" {
+			p = "REDACTED"
+		}
+		redacted[i] = p
+	}
+
+	return redacted
 }
@@ -0,0 +1,184 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// ErrAccountModified is returned by PatchAccount when the account changed since the given version
+var ErrAccountModified = errors.New("account was modified")
+
+// ErrEmailInUse is returned by PatchAccount when another account has the new email, which happens when
+// it was taken after the change was validated
+var ErrEmailInUse = errors.New("email is already used by another account")
+
+// mysql error number of a statement that would duplicate a unique key
+const errDuplicateKey = 1062
+
+// AccountPatch holds the fields of a partial account update. nil fields are left unchanged
+type AccountPatch struct {
+	Email    *string
+	Username *string
+	Status   *string
+	Notes    *string
+	Member   *bool
+
+	// set MembershipExpires (nil clears it) when SetMembershipExpires is true
+	SetMembershipExpires bool
+	MembershipExpires    *string
+
+	// recorded in the status history when the status changes
+	StatusReason string
+	Actor        string
+}
+
+// PatchAccount applies a partial update to an account if it is still at the version (updated_at) the
+// changes were validated against. it returns ErrAccountModified if it isn't, ErrEmailInUse if the new
+// email belongs to another account and sql.ErrNoRows if the account doesn't exist
+func (d *Database) PatchAccount(current Account, p AccountPatch) error {
+	set := []string{}
+	args := []interface{}{}
+	if p.Email != nil {
+		set = append(set, "email = ?")
+		args = append(args, *p.Email)
+	}
+	if p.Username != nil {
+		set = append(set, "username = ?")
+		args = append(args, *p.Username)
+	}
+	if p.Status != nil {
+		set = append(set, "status = ?")
+		args = append(args, *p.Status)
+	}
+	if p.Notes != nil {
+		set = append(set, "notes = ?")
+		args = append(args, *p.Notes)
+	}
+	if p.Member != nil {
+		set = append(set, "member = ?")
+		args = append(args, *p.Member)
+	}
+	if p.SetMembershipExpires {
+		set = append(set, "membership_expires = ?")
+		args = append(args, p.MembershipExpires)
+	}
+	if len(set) == 0 {
+		return nil
+	}
+
+	tx, err := d.Driver.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// always bump the version, even if the values didn't change
+	set = append(set, "updated_at = CURRENT_TIMESTAMP(6)")
+	args = append(args, current.ID, current.UpdatedAt)
+	res, err := tx.Exec("UPDATE accounts SET "+strings.Join(set, ", ")+" WHERE id = ? AND updated_at = ?", args...)
+	if err != nil {
+		var me *mysql.MySQLError
+		if p.Email != nil && errors.As(err, &me) && me.Number == errDuplicateKey {
+			return ErrEmailInUse
+		}
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		var exists bool
+		if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM accounts WHERE id = ?)", current.ID).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return sql.ErrNoRows
+		}
+		return ErrAccountModified
+	}
+
+	if p.Status != nil && *p.Status != current.Status {
+		if err := insertStatusChange(tx, current.ID, current.Status, *p.Status, p.StatusReason, p.Actor); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DeleteAccount soft deletes an account. its history is kept and it can be restored with RestoreAccount.
+// returns sql.ErrNoRows if the account doesn't exist or is already deleted
+func (d *Database) DeleteAccount(accountID int) error {
+	return d.setDeleted(accountID, "UPDATE accounts SET deleted_at = NOW() WHERE id = ? AND deleted_at IS NULL")
+}
+
+// RestoreAccount undoes a soft delete. returns sql.ErrNoRows if the account doesn't exist or isn't deleted
+func (d *Database) RestoreAccount(accountID int) error {
+	return d.setDeleted(accountID, "UPDATE accounts SET deleted_at = NULL WHERE id = ? AND deleted_at IS NOT NULL")
+}
+
+func (d *Database) setDeleted(accountID int, query string) error {
+	db := d.Driver
+
+	res, err := db.Exec(query, accountID)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// tables holding rows of an account's activities, deleted before the activities themselves
+var activityTables = []string{"activity_xp", "activity_items", "activity_gp", "heartbeat_tokens"}
+
+// tables holding rows of an account, deleted before the account itself. the audit log is kept
+var accountTables = []string{"heartbeats", "heartbeat_tokens", "activity", "levels", "account_credentials", "account_tags", "account_group_members", "account_status_history", "alerts", "alert_silences"}
+
+// PurgeAccount permanently deletes an account and every row that belongs to it in one transaction.
+// returns sql.ErrNoRows if the account doesn't exist
+func (d *Database) PurgeAccount(accountID int) error {
+	tx, err := d.Driver.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range activityTables {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE activity_id IN (SELECT id FROM activity WHERE account_id = ?)", accountID); err != nil {
+			return fmt.Errorf("purging %s: %w", table, err)
+		}
+	}
+
+	for _, table := range accountTables {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE account_id = ?", accountID); err != nil {
+			return fmt.Errorf("purging %s: %w", table, err)
+		}
+	}
+
+	// quarantined heartbeats only have the email they were sent for
+	if _, err := tx.Exec("DELETE q FROM quarantined_heartbeats q JOIN accounts a ON a.email = q.email WHERE a.id = ?", accountID); err != nil {
+		return fmt.Errorf("purging quarantined_heartbeats: %w", err)
+	}
+
+	res, err := tx.Exec("DELETE FROM accounts WHERE id = ?", accountID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,213 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// reasons an activity ended
+const (
+	// stopped through the api
+	ExitStopped = "stopped"
+
+	// the client process was found to no longer be running
+	ExitExited = "exited"
+
+	// the account was deleted while the bot was running
+	ExitAccountDeleted = "account_deleted"
+
+	// the client was started but the launch couldn't be completed, e.g. its heartbeat token wasn't stored
+	ExitLaunchFailed = "launch_failed"
+)
+
+// ActivityFilter narrows down the activities returned by ListActivity. zero values are ignored
+type ActivityFilter struct {
+	AccountID  int
+	Script     string    // first word of the command
+	Since      time.Time // started at or after
+	Until      time.Time // started at or before
+	Status     string    // "running" or "stopped"
+	ExitReason string
+}
+
+// sortable fields of ListActivity
+var activitySorts = map[string]string{
+	"id":         "id",
+	"started_at": "started_at",
+	"account_id": "account_id",
+}
+
+// ListActivity returns a page of the activities matching the filter and the cursor of the next page
+func (d *Database) ListActivity(f ActivityFilter, page PageRequest) ([]Activity, string, error) {
+	db := d.Driver
+	activity := []Activity{}
+
+	pq, err := newPageQuery(page, activitySorts, "id", "id")
+	if err != nil {
+		return activity, "", err
+	}
+
+	where := []string{}
+	args := []interface{}{}
+	if f.AccountID != 0 {
+		where = append(where, "account_id = ?")
+		args = append(args, f.AccountID)
+	}
+	if f.Script != "" {
+		where = append(where, "(command = ? OR command LIKE ?)")
+		args = append(args, f.Script, escapeLike(f.Script)+" %")
+	}
+	if !f.Since.IsZero() {
+		where = append(where, "started_at >= ?")
+		args = append(args, f.Since.Format(TimeFormat))
+	}
+	if !f.Until.IsZero() {
+		where = append(where, "started_at <= ?")
+		args = append(args, f.Until.Format(TimeFormat))
+	}
+	switch f.Status {
+	case "":
+	case "running":
+		where = append(where, "(stopped_at IS NULL OR stopped_at <= started_at)")
+	case "stopped":
+		where = append(where, "stopped_at > started_at")
+	default:
+		return activity, "", fmt.Errorf("%w: unknown activity status %q, expected running or stopped", ErrInvalidListQuery, f.Status)
+	}
+	if f.ExitReason != "" {
+		where = append(where, "exit_reason = ?")
+		args = append(args, f.ExitReason)
+	}
+	if cond, condArgs := pq.where(); cond != "" {
+		where = append(where, cond)
+		args = append(args, condArgs...)
+	}
+
+	q := "SELECT id, account_id, command, started_at, stopped_at, pid, exit_reason FROM activity"
+	if len(where) > 0 {
+		q += " WHERE " + strings.Join(where, " AND ")
+	}
+	order, orderArgs := pq.orderLimit()
+	q += order
+	args = append(args, orderArgs...)
+
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return activity, "", err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a Activity
+		var stoppedAt, exitReason sql.NullString
+		if err := rows.Scan(&a.ID, &a.AccountID, &a.Command, &a.StartedAt, &stoppedAt, &a.PID, &exitReason); err != nil {
+			return activity, "", err
+		}
+		if stoppedAt.Valid {
+			a.StoppedAt = &stoppedAt.String
+		}
+		if exitReason.Valid {
+			a.ExitReason = &exitReason.String
+		}
+		activity = append(activity, a)
+	}
+	if err := rows.Err(); err != nil {
+		return activity, "", err
+	}
+
+	n, next := pq.next(len(activity), func(i int) (string, int64) {
+		a := activity[i]
+		values := map[string]string{"id": fmt.Sprint(a.ID), "started_at": a.StartedAt, "account_id": fmt.Sprint(a.AccountID)}
+		return values[pq.sort], int64(a.ID)
+	})
+
+	return activity[:n], next, nil
+}
+
+// XPFilter narrows down the xp rows returned by ListAccountXP. zero values are ignored
+type XPFilter struct {
+	Skill      string
+	ActivityID int
+	Since      time.Time // activity started at or after
+	Until      time.Time // activity started at or before
+}
+
+// sortable fields of ListAccountXP
+var xpSorts = map[string]string{
+	"id":          "ax.id",
+	"activity_id": "ax.activity_id",
+	"skill":       "ax.skill",
+	"xp_gained":   "ax.xp_gained",
+}
+
+// ListAccountXP returns a page of the xp gained during the activities of an account and the cursor of
+// the next page
+func (d *Database) ListAccountXP(accountID int, f XPFilter, page PageRequest) ([]ActivityXP, string, error) {
+	db := d.Driver
+	xpList := []ActivityXP{}
+
+	pq, err := newPageQuery(page, xpSorts, "id", "ax.id")
+	if err != nil {
+		return xpList, "", err
+	}
+
+	where := []string{"a.account_id = ?"}
+	args := []interface{}{accountID}
+	if f.Skill != "" {
+		where = append(where, "ax.skill = ?")
+		args = append(args, f.Skill)
+	}
+	if f.ActivityID != 0 {
+		where = append(where, "ax.activity_id = ?")
+		args = append(args, f.ActivityID)
+	}
+	if !f.Since.IsZero() {
+		where = append(where, "a.started_at >= ?")
+		args = append(args, f.Since.Format(TimeFormat))
+	}
+	if !f.Until.IsZero() {
+		where = append(where, "a.started_at <= ?")
+		args = append(args, f.Until.Format(TimeFormat))
+	}
+	if cond, condArgs := pq.where(); cond != "" {
+		where = append(where, cond)
+		args = append(args, condArgs...)
+	}
+
+	q := "SELECT ax.id, ax.activity_id, ax.skill, ax.xp_gained FROM activity_xp ax INNER JOIN activity a ON ax.activity_id = a.id WHERE " + strings.Join(where, " AND ")
+	order, orderArgs := pq.orderLimit()
+	q += order
+	args = append(args, orderArgs...)
+
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return xpList, "", err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var xp ActivityXP
+		if err := rows.Scan(&xp.ID, &xp.ActivityID, &xp.Skill, &xp.XPGained); err != nil {
+			return xpList, "", err
+		}
+		xpList = append(xpList, xp)
+	}
+	if err := rows.Err(); err != nil {
+		return xpList, "", err
+	}
+
+	n, next := pq.next(len(xpList), func(i int) (string, int64) {
+		xp := xpList[i]
+		values := map[string]string{"id": fmt.Sprint(xp.ID), "activity_id": fmt.Sprint(xp.ActivityID), "skill": xp.Skill, "xp_gained": fmt.Sprint(xp.XPGained)}
+		return values[pq.sort], int64(xp.ID)
+	})
+
+	return xpList[:n], next, nil
+}
+
+// escapes the wildcards of a LIKE pattern
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
@@ -0,0 +1,417 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// statuses of an alert
+const (
+	AlertFiring   = "firing"   // the condition of its rule holds
+	AlertResolved = "resolved" // the condition no longer holds, or the rule was deleted or disabled
+)
+
+// Represents a row in the alert_rules table - a condition over the fleet checked by the monitor loop
+type AlertRule struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Kind     string `json:"kind"`
+	Severity string `json:"severity"`
+
+	// only activities running this script, empty for every script
+	Script string `json:"script"`
+
+	// meaning depends on the kind: the most crashes, the lowest xp/hour or the fewest bots that are fine
+	Threshold float64 `json:"threshold"`
+
+	// meaning depends on the kind: how long without a heartbeat, the period crashes are counted over, or
+	// how long a bot runs before its xp/hour is checked
+	WindowSeconds int `json:"window_seconds"`
+
+	Enabled   bool   `json:"enabled"`
+	CreatedAt string `json:"created_at"`
+}
+
+// Represents a row in the alerts table - a rule whose condition held for a subject, e.g. an account
+type Alert struct {
+	ID        int64   `json:"id"`
+	RuleID    int     `json:"rule_id"`
+	RuleName  string  `json:"rule_name"` // as it was when the alert fired
+	Severity  string  `json:"severity"`
+	Subject   string  `json:"subject"` // what the condition holds for, e.g. "account:12", "script:miner" or "fleet"
+	AccountID *int    `json:"account_id,omitempty"`
+	Status    string  `json:"status"`
+	Message   string  `json:"message"`
+	Value     float64 `json:"value"` // the measured crashes, xp/hour, bots or seconds without a heartbeat
+	Silenced  bool    `json:"silenced"`
+
+	// whether the firing notification was published, resolving is only published for notified alerts
+	Notified bool `json:"-"`
+
+	FiredAt    string  `json:"fired_at"`
+	UpdatedAt  string  `json:"updated_at"`
+	ResolvedAt *string `json:"resolved_at,omitempty"`
+}
+
+// Represents a row in the alert_silences table - a window in which the matching alerts are not notified
+type AlertSilence struct {
+	ID        int    `json:"id"`
+	RuleID    *int   `json:"rule_id,omitempty"`    // nil for every rule
+	AccountID *int   `json:"account_id,omitempty"` // nil for every account
+	StartsAt  string `json:"starts_at"`
+	EndsAt    string `json:"ends_at"`
+	Reason    string `json:"reason"`
+	CreatedBy string `json:"created_by"`
+	CreatedAt string `json:"created_at"`
+}
+
+// AlertFilter selects the alerts returned by ListAlerts, zero values match everything
+type AlertFilter struct {
+	Status    string
+	Severity  string
+	RuleID    int
+	AccountID int
+}
+
+// RunningActivity is a running activity with the xp gained during it, see GetRunningActivities
+type RunningActivity struct {
+	ActivityID int
+	AccountID  int
+	Username   string
+	Command    string
+	StartedAt  string
+	XPGained   int64 // over every skill
+}
+
+const alertRuleColumns = "id, name, kind, severity, script, threshold, window_seconds, enabled, created_at"
+
+func scanAlertRule(row interface{ Scan(...interface{}) error }, r *AlertRule) error {
+	return row.Scan(&r.ID, &r.Name, &r.Kind, &r.Severity, &r.Script, &r.Threshold, &r.WindowSeconds, &r.Enabled, &r.CreatedAt)
+}
+
+// InsertAlertRule stores a new alert rule and returns its id
+func (d *Database) InsertAlertRule(r AlertRule) (int, error) {
+	res, err := d.Driver.Exec("INSERT INTO alert_rules (name, kind, severity, script, threshold, window_seconds, enabled) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		r.Name, r.Kind, r.Severity, r.Script, r.Threshold, r.WindowSeconds, r.Enabled)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+// GetAlertRules returns every alert rule, oldest first
+func (d *Database) GetAlertRules() ([]AlertRule, error) {
+	rows, err := d.Driver.Query("SELECT " + alertRuleColumns + " FROM alert_rules ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []AlertRule{}
+	for rows.Next() {
+		var r AlertRule
+		if err := scanAlertRule(rows, &r); err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+
+	return rules, rows.Err()
+}
+
+// GetAlertRule returns an alert rule by id, sql.ErrNoRows if it doesn't exist
+func (d *Database) GetAlertRule(id int) (AlertRule, error) {
+	var r AlertRule
+	err := scanAlertRule(d.Driver.QueryRow("SELECT "+alertRuleColumns+" FROM alert_rules WHERE id = ?", id), &r)
+	return r, err
+}
+
+// UpdateAlertRule saves every field of an alert rule but its id and creation time
+func (d *Database) UpdateAlertRule(r AlertRule) error {
+	_, err := d.Driver.Exec("UPDATE alert_rules SET name = ?, kind = ?, severity = ?, script = ?, threshold = ?, window_seconds = ?, enabled = ? WHERE id = ?",
+		r.Name, r.Kind, r.Severity, r.Script, r.Threshold, r.WindowSeconds, r.Enabled, r.ID)
+	return err
+}
+
+// DeleteAlertRule deletes an alert rule. its alerts are kept, the firing ones are resolved by the next
+// evaluation
+func (d *Database) DeleteAlertRule(id int) error {
+	res, err := d.Driver.Exec("DELETE FROM alert_rules WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+const alertColumns = "id, rule_id, rule_name, severity, subject, account_id, status, message, value, silenced, notified, fired_at, updated_at, resolved_at"
+
+func scanAlert(row interface{ Scan(...interface{}) error }, a *Alert) error {
+	var accountID sql.NullInt64
+	var resolvedAt sql.NullString
+	if err := row.Scan(&a.ID, &a.RuleID, &a.RuleName, &a.Severity, &a.Subject, &accountID, &a.Status, &a.Message, &a.Value,
+		&a.Silenced, &a.Notified, &a.FiredAt, &a.UpdatedAt, &resolvedAt); err != nil {
+		return err
+	}
+
+	if accountID.Valid {
+		id := int(accountID.Int64)
+		a.AccountID = &id
+	}
+	a.ResolvedAt = nullString(resolvedAt)
+
+	return nil
+}
+
+// GetFiringAlerts returns every firing alert
+func (d *Database) GetFiringAlerts() ([]Alert, error) {
+	rows, err := d.Driver.Query("SELECT "+alertColumns+" FROM alerts WHERE status = ? ORDER BY id", AlertFiring)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	alerts := []Alert{}
+	for rows.Next() {
+		var a Alert
+		if err := scanAlert(rows, &a); err != nil {
+			return nil, err
+		}
+		alerts = append(alerts, a)
+	}
+
+	return alerts, rows.Err()
+}
+
+// InsertAlert stores a firing alert and returns its id
+func (d *Database) InsertAlert(a Alert, now time.Time) (int64, error) {
+	res, err := d.Driver.Exec(`INSERT INTO alerts (rule_id, rule_name, severity, subject, account_id, status, message, value, silenced, notified, fired_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		a.RuleID, a.RuleName, a.Severity, a.Subject, a.AccountID, AlertFiring, truncate(a.Message, 1024), a.Value, a.Silenced, a.Notified,
+		now.Format(TimeFormat), now.Format(TimeFormat))
+	if err != nil {
+		return 0, err
+	}
+
+	return res.LastInsertId()
+}
+
+// UpdateAlert saves the status, message, value, silenced and notified flags and resolution time of an alert
+func (d *Database) UpdateAlert(a Alert, now time.Time) error {
+	_, err := d.Driver.Exec("UPDATE alerts SET status = ?, message = ?, value = ?, silenced = ?, notified = ?, updated_at = ?, resolved_at = ? WHERE id = ?",
+		a.Status, truncate(a.Message, 1024), a.Value, a.Silenced, a.Notified, now.Format(TimeFormat), a.ResolvedAt, a.ID)
+	return err
+}
+
+// sortable fields of ListAlerts
+var alertSorts = map[string]string{
+	"id":       "id",
+	"fired_at": "fired_at",
+}
+
+// ListAlerts returns a page of the alerts matching the filter and the cursor of the next page
+func (d *Database) ListAlerts(f AlertFilter, page PageRequest) ([]Alert, string, error) {
+	pq, err := newPageQuery(page, alertSorts, "id", "id")
+	if err != nil {
+		return nil, "", err
+	}
+
+	where := []string{}
+	args := []interface{}{}
+	if f.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, f.Status)
+	}
+	if f.Severity != "" {
+		where = append(where, "severity = ?")
+		args = append(args, f.Severity)
+	}
+	if f.RuleID != 0 {
+		where = append(where, "rule_id = ?")
+		args = append(args, f.RuleID)
+	}
+	if f.AccountID != 0 {
+		where = append(where, "account_id = ?")
+		args = append(args, f.AccountID)
+	}
+	if cond, condArgs := pq.where(); cond != "" {
+		where = append(where, cond)
+		args = append(args, condArgs...)
+	}
+
+	q := "SELECT " + alertColumns + " FROM alerts"
+	if len(where) > 0 {
+		q += " WHERE " + strings.Join(where, " AND ")
+	}
+	order, orderArgs := pq.orderLimit()
+	q += order
+	args = append(args, orderArgs...)
+
+	rows, err := d.Driver.Query(q, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	alerts := []Alert{}
+	for rows.Next() {
+		var a Alert
+		if err := scanAlert(rows, &a); err != nil {
+			return nil, "", err
+		}
+		alerts = append(alerts, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	n, next := pq.next(len(alerts), func(i int) (string, int64) {
+		values := map[string]string{"id": fmt.Sprint(alerts[i].ID), "fired_at": alerts[i].FiredAt}
+		return values[pq.sort], alerts[i].ID
+	})
+
+	return alerts[:n], next, nil
+}
+
+const alertSilenceColumns = "id, rule_id, account_id, starts_at, ends_at, reason, created_by, created_at"
+
+func scanAlertSilence(row interface{ Scan(...interface{}) error }, s *AlertSilence) error {
+	var ruleID, accountID sql.NullInt64
+	if err := row.Scan(&s.ID, &ruleID, &accountID, &s.StartsAt, &s.EndsAt, &s.Reason, &s.CreatedBy, &s.CreatedAt); err != nil {
+		return err
+	}
+
+	if ruleID.Valid {
+		id := int(ruleID.Int64)
+		s.RuleID = &id
+	}
+	if accountID.Valid {
+		id := int(accountID.Int64)
+		s.AccountID = &id
+	}
+
+	return nil
+}
+
+// InsertAlertSilence stores a silence and returns its id
+func (d *Database) InsertAlertSilence(s AlertSilence) (int, error) {
+	res, err := d.Driver.Exec("INSERT INTO alert_silences (rule_id, account_id, starts_at, ends_at, reason, created_by) VALUES (?, ?, ?, ?, ?, ?)",
+		s.RuleID, s.AccountID, s.StartsAt, s.EndsAt, s.Reason, s.CreatedBy)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+// GetAlertSilences returns the silences that haven't ended at now, ordered by start
+func (d *Database) GetAlertSilences(now time.Time) ([]AlertSilence, error) {
+	rows, err := d.Driver.Query("SELECT "+alertSilenceColumns+" FROM alert_silences WHERE ends_at > ? ORDER BY starts_at, id", now.Format(TimeFormat))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	silences := []AlertSilence{}
+	for rows.Next() {
+		var s AlertSilence
+		if err := scanAlertSilence(rows, &s); err != nil {
+			return nil, err
+		}
+		silences = append(silences, s)
+	}
+
+	return silences, rows.Err()
+}
+
+// GetAlertSilence returns a silence by id, sql.ErrNoRows if it doesn't exist
+func (d *Database) GetAlertSilence(id int) (AlertSilence, error) {
+	var s AlertSilence
+	err := scanAlertSilence(d.Driver.QueryRow("SELECT "+alertSilenceColumns+" FROM alert_silences WHERE id = ?", id), &s)
+	return s, err
+}
+
+// DeleteAlertSilence deletes a silence, ending it early
+func (d *Database) DeleteAlertSilence(id int) error {
+	res, err := d.Driver.Exec("DELETE FROM alert_silences WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// GetRunningActivities returns the running activities of the given script, or of every script when empty,
+// with the account's username and the xp gained so far
+func (d *Database) GetRunningActivities(script string) ([]RunningActivity, error) {
+	q := `SELECT a.id, a.account_id, ac.username, a.command, a.started_at, COALESCE(SUM(x.xp_gained), 0)
+		FROM activity a
+		INNER JOIN accounts ac ON ac.id = a.account_id
+		LEFT JOIN activity_xp x ON x.activity_id = a.id
+		WHERE (a.stopped_at IS NULL OR a.stopped_at <= a.started_at)`
+	args := []interface{}{}
+	if script != "" {
+		q += " AND (a.command = ? OR a.command LIKE ?)"
+		args = append(args, script, escapeLike(script)+" %")
+	}
+	q += " GROUP BY a.id, a.account_id, ac.username, a.command, a.started_at ORDER BY a.id"
+
+	rows, err := d.Driver.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	activities := []RunningActivity{}
+	for rows.Next() {
+		var a RunningActivity
+		if err := rows.Scan(&a.ActivityID, &a.AccountID, &a.Username, &a.Command, &a.StartedAt, &a.XPGained); err != nil {
+			return nil, err
+		}
+		activities = append(activities, a)
+	}
+
+	return activities, rows.Err()
+}
+
+// CountCrashes returns the number of activities of each account that ended because the client exited
+// on its own since the given time, for the given script or every script when empty
+func (d *Database) CountCrashes(since time.Time, script string) (map[int]int, error) {
+	q := "SELECT account_id, COUNT(*) FROM activity WHERE exit_reason = ? AND stopped_at >= ?"
+	args := []interface{}{ExitExited, since.Format(TimeFormat)}
+	if script != "" {
+		q += " AND (command = ? OR command LIKE ?)"
+		args = append(args, script, escapeLike(script)+" %")
+	}
+	q += " GROUP BY account_id"
+
+	rows, err := d.Driver.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	crashes := map[int]int{}
+	for rows.Next() {
+		var accountID, n int
+		if err := rows.Scan(&accountID, &n); err != nil {
+			return nil, err
+		}
+		crashes[accountID] = n
+	}
+
+	return crashes, rows.Err()
+}
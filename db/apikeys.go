@@ -0,0 +1,115 @@
+package db
+
+import (
+	"database/sql"
+)
+
+// Represents a row in the api_keys table. only the hash of a key is stored, the prefix is kept so
+// operators can tell keys apart
+type APIKey struct {
+	ID         int     `json:"id"`
+	Name       string  `json:"name"`
+	Prefix     string  `json:"prefix"`
+	Role       string  `json:"role"`
+	CreatedAt  string  `json:"created_at"`
+	LastUsedAt *string `json:"last_used_at,omitempty"`
+	RevokedAt  *string `json:"revoked_at,omitempty"`
+}
+
+const apiKeyColumns = "id, name, prefix, role, created_at, last_used_at, revoked_at"
+
+func scanAPIKey(row interface{ Scan(...interface{}) error }) (APIKey, error) {
+	var key APIKey
+	var lastUsedAt, revokedAt sql.NullString
+
+	if err := row.Scan(&key.ID, &key.Name, &key.Prefix, &key.Role, &key.CreatedAt, &lastUsedAt, &revokedAt); err != nil {
+		return key, err
+	}
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.String
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.String
+	}
+
+	return key, nil
+}
+
+// InsertAPIKey stores a new api key and returns its id
+func (d *Database) InsertAPIKey(name string, prefix string, keyHash string, role string) (int, error) {
+	db := d.Driver
+
+	res, err := db.Exec("INSERT INTO api_keys (name, prefix, key_hash, role, created_at) VALUES (?, ?, ?, ?, NOW())", name, prefix, keyHash, role)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+// GetAPIKeys returns every api key, including revoked ones
+func (d *Database) GetAPIKeys() ([]APIKey, error) {
+	db := d.Driver
+
+	rows, err := db.Query("SELECT " + apiKeyColumns + " FROM api_keys ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// GetAPIKeyByHash returns the non-revoked api key with the given hash
+func (d *Database) GetAPIKeyByHash(keyHash string) (APIKey, error) {
+	db := d.Driver
+
+	return scanAPIKey(db.QueryRow("SELECT "+apiKeyColumns+" FROM api_keys WHERE key_hash = ? AND revoked_at IS NULL", keyHash))
+}
+
+// CountActiveAPIKeys returns the number of non-revoked api keys with the given role
+func (d *Database) CountActiveAPIKeys(role string) (int, error) {
+	db := d.Driver
+
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM api_keys WHERE role = ? AND revoked_at IS NULL", role).Scan(&count)
+	return count, err
+}
+
+// TouchAPIKey records that an api key was just used
+func (d *Database) TouchAPIKey(id int) error {
+	db := d.Driver
+
+	_, err := db.Exec("UPDATE api_keys SET last_used_at = NOW() WHERE id = ?", id)
+	return err
+}
+
+// RevokeAPIKey revokes an api key, sql.ErrNoRows is returned if there is no active key with the given id
+func (d *Database) RevokeAPIKey(id int) error {
+	db := d.Driver
+
+	res, err := db.Exec("UPDATE api_keys SET revoked_at = NOW() WHERE id = ? AND revoked_at IS NULL", id)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
@@ -0,0 +1,134 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Represents a row in the audit_log table - a mutating request made by an operator
+type AuditEntry struct {
+	ID         int64  `json:"id"`
+	ActorKeyID *int   `json:"actor_key_id,omitempty"`
+	Actor      string `json:"actor"`
+	Action     string `json:"action"` // method and route, e.g. "DELETE /bots/:id"
+	Target     string `json:"target,omitempty"`
+	BodySHA256 string `json:"body_sha256,omitempty"`
+	Status     int    `json:"status"`
+	Result     string `json:"result"` // "success", "failure" or "denied" (401 and 403)
+	RemoteAddr string `json:"remote_addr"`
+	CreatedAt  string `json:"created_at"`
+}
+
+// AuditFilter narrows down the entries returned by GetAuditLog. zero values are ignored
+type AuditFilter struct {
+	Actor  string
+	Action string
+	Target string
+	Result string
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+}
+
+// InsertAuditEntry appends an entry to the audit log
+func (d *Database) InsertAuditEntry(e AuditEntry) error {
+	db := d.Driver
+
+	_, err := db.Exec("INSERT INTO audit_log (actor_key_id, actor, action, target, body_sha256, status, result, remote_addr, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, NOW())",
+		e.ActorKeyID, e.Actor, e.Action, e.Target, e.BodySHA256, e.Status, e.Result, e.RemoteAddr)
+	return err
+}
+
+// GetAuditLog returns audit log entries matching the filter, newest first
+func (d *Database) GetAuditLog(f AuditFilter) ([]AuditEntry, error) {
+	entries, _, err := d.ListAuditLog(f, PageRequest{Limit: f.Limit, Desc: true})
+	return entries, err
+}
+
+// the audit log can only be paged in the order it was written
+var auditSorts = map[string]string{
+	"id": "id",
+}
+
+// ListAuditLog returns a page of the audit log entries matching the filter and the cursor of the next
+// page. the Limit of the filter is ignored in favour of the page's
+func (d *Database) ListAuditLog(f AuditFilter, page PageRequest) ([]AuditEntry, string, error) {
+	db := d.Driver
+
+	pq, err := newPageQuery(page, auditSorts, "id", "id")
+	if err != nil {
+		return nil, "", err
+	}
+
+	where := []string{}
+	args := []interface{}{}
+	if f.Actor != "" {
+		where = append(where, "actor = ?")
+		args = append(args, f.Actor)
+	}
+	if f.Action != "" {
+		where = append(where, "action = ?")
+		args = append(args, f.Action)
+	}
+	if f.Target != "" {
+		where = append(where, "target = ?")
+		args = append(args, f.Target)
+	}
+	if f.Result != "" {
+		where = append(where, "result = ?")
+		args = append(args, f.Result)
+	}
+	if !f.Since.IsZero() {
+		where = append(where, "created_at >= ?")
+		args = append(args, f.Since.Format(TimeFormat))
+	}
+	if !f.Until.IsZero() {
+		where = append(where, "created_at <= ?")
+		args = append(args, f.Until.Format(TimeFormat))
+	}
+
+	if cond, condArgs := pq.where(); cond != "" {
+		where = append(where, cond)
+		args = append(args, condArgs...)
+	}
+
+	q := "SELECT id, actor_key_id, actor, action, target, body_sha256, status, result, remote_addr, created_at FROM audit_log"
+	if len(where) > 0 {
+		q += " WHERE " + strings.Join(where, " AND ")
+	}
+	order, orderArgs := pq.orderLimit()
+	q += order
+	args = append(args, orderArgs...)
+
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		var keyID sql.NullInt64
+		if err := rows.Scan(&e.ID, &keyID, &e.Actor, &e.Action, &e.Target, &e.BodySHA256, &e.Status, &e.Result, &e.RemoteAddr, &e.CreatedAt); err != nil {
+			return nil, "", err
+		}
+		if keyID.Valid {
+			id := int(keyID.Int64)
+			e.ActorKeyID = &id
+		}
+		entries = append(entries, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	n, next := pq.next(len(entries), func(i int) (string, int64) {
+		return fmt.Sprint(entries[i].ID), entries[i].ID
+	})
+
+	return entries[:n], next, nil
+}
@@ -0,0 +1,29 @@
+package db
+
+// UpsertAccountCredentials stores the encrypted credentials of an account, replacing existing ones
+func (d *Database) UpsertAccountCredentials(accountID int, nonce []byte, ciphertext []byte) error {
+	db := d.Driver
+
+	_, err := db.Exec(`
+		INSERT INTO account_credentials (account_id, nonce, ciphertext, updated_at) VALUES (?, ?, ?, NOW())
+		ON DUPLICATE KEY UPDATE nonce = VALUES(nonce), ciphertext = VALUES(ciphertext), updated_at = VALUES(updated_at)`,
+		accountID, nonce, ciphertext)
+	return err
+}
+
+// GetAccountCredentials returns the encrypted credentials of an account, sql.ErrNoRows if none are stored
+func (d *Database) GetAccountCredentials(accountID int) ([]byte, []byte, error) {
+	db := d.Driver
+
+	var nonce, ciphertext []byte
+	err := db.QueryRow("SELECT nonce, ciphertext FROM account_credentials WHERE account_id = ?", accountID).Scan(&nonce, &ciphertext)
+	return nonce, ciphertext, err
+}
+
+// DeleteAccountCredentials removes the stored credentials of an account
+func (d *Database) DeleteAccountCredentials(accountID int) error {
+	db := d.Driver
+
+	_, err := db.Exec("DELETE FROM account_credentials WHERE account_id = ?", accountID)
+	return err
+}
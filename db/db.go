@@ -3,9 +3,11 @@ package db
 import (
 	"database/sql"
 	"fmt"
-	"log"
+	"log/slog"
+	"strings"
 
 	b "bot-api/bot"
+	"bot-api/logging"
 
 	_ "github.com/go-sql-driver/mysql"
 )
@@ -16,6 +18,52 @@ type Account struct {
 	Username string `json:"username"`
 	Email    string `json:"email"`
 	Status   string `json:"status"`
+	Notes    string `json:"notes,omitempty"`
+	Member   bool   `json:"member"`
+
+	// last day of membership as YYYY-MM-DD, nil if unknown or the account is f2p
+	MembershipExpires *string `json:"membership_expires,omitempty"`
+
+	// time of the last change to the row, with microseconds. used as the version for optimistic concurrency
+	UpdatedAt string `json:"updated_at"`
+
+	// set when the account was soft deleted, see DeleteAccount
+	DeletedAt *string `json:"deleted_at,omitempty"`
+
+	Tags   []string `json:"tags,omitempty"`   // from the account_tags table
+	Groups []string `json:"groups,omitempty"` // names of the groups the account is a member of
+}
+
+// columns selected for an Account from the accounts table aliased as a, see scanAccount
+const accountColumns = "a.id, a.username, a.email, a.status, COALESCE(a.notes, ''), a.member, a.membership_expires, a.updated_at, a.deleted_at"
+
+// scanAccount scans the accountColumns of a row into account, followed by any extra columns
+func scanAccount(row interface{ Scan(...interface{}) error }, account *Account, extra ...interface{}) error {
+	var expires, deleted sql.NullString
+	dest := append([]interface{}{&account.ID, &account.Username, &account.Email, &account.Status, &account.Notes, &account.Member, &expires, &account.UpdatedAt, &deleted}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return err
+	}
+
+	if expires.Valid {
+		account.MembershipExpires = &expires.String
+	}
+	if deleted.Valid {
+		account.DeletedAt = &deleted.String
+	}
+
+	return nil
+}
+
+// AccountFilter narrows down the accounts returned by FindAccounts. zero values are ignored
+type AccountFilter struct {
+	Tag    string
+	Group  string
+	Status string
+	Member *bool
+
+	// return only soft deleted accounts instead of only the ones that aren't
+	Deleted bool
 }
 
 // Represents a row in the levels table
@@ -44,6 +92,34 @@ type Levels struct {
 	Farming      int `json:"farming"`
 }
 
+// Map returns the levels keyed by skill name, using the same names as the json tags
+func (l Levels) Map() map[string]int {
+	return map[string]int{
+		"attack":       l.Attack,
+		"strength":     l.Strength,
+		"defence":      l.Defence,
+		"ranged":       l.Ranged,
+		"magic":        l.Magic,
+		"prayer":       l.Prayer,
+		"runecrafting": l.Runecrafting,
+		"hitpoints":    l.Hitpoints,
+		"agility":      l.Agility,
+		"herblore":     l.Herblore,
+		"thieving":     l.Thieving,
+		"crafting":     l.Crafting,
+		"fletching":    l.Fletching,
+		"slayer":       l.Slayer,
+		"hunter":       l.Hunter,
+		"mining":       l.Mining,
+		"smithing":     l.Smithing,
+		"fishing":      l.Fishing,
+		"cooking":      l.Cooking,
+		"firemaking":   l.Firemaking,
+		"woodcutting":  l.Woodcutting,
+		"farming":      l.Farming,
+	}
+}
+
 // Represents a row in the activity table - used to track what the bot is/was doing
 type Activity struct {
 	ID        int     `json:"id"`
@@ -52,6 +128,10 @@ type Activity struct {
 	StartedAt string  `json:"started_at"`
 	StoppedAt *string `json:"stopped_at,omitempty"`
 	PID       int     `json:"pid"`
+
+	// why the activity ended, see the Exit constants. nil while running and for activities from before
+	// exit reasons were recorded
+	ExitReason *string `json:"exit_reason,omitempty"`
 }
 
 // Represents a row in the activity_xp table - tracks XP gained during an activity session
@@ -67,76 +147,134 @@ type Database struct {
 }
 
 func (d *Database) GetAccounts() ([]Account, error) {
+	return d.FindAccounts(AccountFilter{})
+}
+
+// FindAccounts returns every account matching the filter, including their tags and groups
+func (d *Database) FindAccounts(f AccountFilter) ([]Account, error) {
+	accounts, _, err := d.ListAccounts(f, PageRequest{})
+	return accounts, err
+}
+
+// sortable fields of ListAccounts
+var accountSorts = map[string]string{
+	"id":         "a.id",
+	"username":   "a.username",
+	"email":      "a.email",
+	"status":     "a.status",
+	"updated_at": "a.updated_at",
+}
+
+// ListAccounts returns a page of the accounts matching the filter, including their tags and groups,
+// and the cursor of the next page
+func (d *Database) ListAccounts(f AccountFilter, page PageRequest) ([]Account, string, error) {
 	db := d.Driver
 	accounts := []Account{}
 
-	stmtOut, err := db.Prepare("SELECT * FROM accounts")
+	pq, err := newPageQuery(page, accountSorts, "id", "a.id")
 	if err != nil {
-		panic(err.Error())
+		return accounts, "", err
 	}
-	defer stmtOut.Close()
 
-	rows, err := stmtOut.Query()
+	q := "SELECT " + accountColumns + " FROM accounts a"
+	where := []string{"a.deleted_at IS NULL"}
+	if f.Deleted {
+		where[0] = "a.deleted_at IS NOT NULL"
+	}
+	args := []interface{}{}
+	if f.Tag != "" {
+		where = append(where, "EXISTS (SELECT 1 FROM account_tags t WHERE t.account_id = a.id AND t.tag = ?)")
+		args = append(args, f.Tag)
+	}
+	if f.Group != "" {
+		where = append(where, "EXISTS (SELECT 1 FROM account_group_members m INNER JOIN account_groups g ON m.group_id = g.id WHERE m.account_id = a.id AND g.name = ?)")
+		args = append(args, f.Group)
+	}
+	if f.Status != "" {
+		where = append(where, "a.status = ?")
+		args = append(args, f.Status)
+	}
+	if f.Member != nil {
+		where = append(where, "a.member = ?")
+		args = append(args, *f.Member)
+	}
+	if cond, condArgs := pq.where(); cond != "" {
+		where = append(where, cond)
+		args = append(args, condArgs...)
+	}
+	q += " WHERE " + strings.Join(where, " AND ")
+
+	order, orderArgs := pq.orderLimit()
+	q += order
+	args = append(args, orderArgs...)
+
+	rows, err := db.Query(q, args...)
 	if err != nil {
-		log.Fatal(err)
-		return accounts, err
+		return accounts, "", err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var id int
-		var username string
-		var email string
-		var status string
-
-		if err := rows.Scan(&id, &username, &email, &status); err != nil {
-			log.Fatal(err)
+		var account Account
+		if err := scanAccount(rows, &account); err != nil {
+			return accounts, "", err
 		}
 
-		accounts = append(accounts, Account{
-			ID:       id,
-			Username: username,
-			Email:    email,
-			Status:   status,
-		})
+		accounts = append(accounts, account)
 	}
 
 	if err := rows.Err(); err != nil {
-		log.Fatal(err)
-		return accounts, err
+		return accounts, "", err
 	}
 
-	return accounts, nil
+	n, next := pq.next(len(accounts), func(i int) (string, int64) {
+		a := accounts[i]
+		values := map[string]string{"id": fmt.Sprint(a.ID), "username": a.Username, "email": a.Email, "status": a.Status, "updated_at": a.UpdatedAt}
+		return values[pq.sort], int64(a.ID)
+	})
+	accounts = accounts[:n]
+
+	if err := d.loadAccountLabels(accounts); err != nil {
+		return accounts, "", err
+	}
+
+	return accounts, next, nil
 }
 
 func (d *Database) GetAccount(id string) (Account, error) {
 	db := d.Driver
 	var account Account
 
-	stmtOut, err := db.Prepare("SELECT * FROM accounts WHERE id = ?")
+	stmtOut, err := db.Prepare("SELECT " + accountColumns + " FROM accounts a WHERE a.id = ?")
 	if err != nil {
 		panic(err.Error())
 	}
 	defer stmtOut.Close()
 
 	row := stmtOut.QueryRow(id)
-	err = row.Scan(&account.ID, &account.Username, &account.Email, &account.Status)
+	err = scanAccount(row, &account)
 	if err != nil {
-		log.Println(err)
+		slog.Debug("error getting account", "account_id", id, "error", err)
 		return account, err
 	}
 
-	return account, nil
+	accounts := []Account{account}
+	if err := d.loadAccountLabels(accounts); err != nil {
+		return account, err
+	}
+
+	return accounts[0], nil
 }
 
 func (d *Database) GetActiveBots() ([]b.Bot, error) {
 	db := d.Driver
 
 	// select the account ids from activity table join with the accounts table where stopped_at is null or an earlier time than started_at
-	q := "SELECT a.id, ac.account_id, a.email, a.username, a.status, ac.pid FROM activity AS ac INNER JOIN accounts AS a ON ac.account_id = a.id WHERE ac.stopped_at IS NULL OR ac.stopped_at <= ac.started_at"
+	// the script is the first word of the activity's command
+	q := "SELECT a.id, ac.account_id, a.email, a.username, a.status, ac.pid, COALESCE(SUBSTRING_INDEX(ac.command, ' ', 1), '') FROM activity AS ac INNER JOIN accounts AS a ON ac.account_id = a.id WHERE ac.stopped_at IS NULL OR ac.stopped_at <= ac.started_at"
 	rows, err := db.Query(q)
 	if err != nil {
-		fmt.Println(err)
+		slog.Error("database error", "query", "db.GetActiveBots", "error", err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -149,9 +287,10 @@ func (d *Database) GetActiveBots() ([]b.Bot, error) {
 		var username string
 		var status string
 		var pid int
+		var script string
 
-		if err := rows.Scan(&id, &accountId, &email, &username, &status, &pid); err != nil {
-			log.Fatal(err)
+		if err := rows.Scan(&id, &accountId, &email, &username, &status, &pid, &script); err != nil {
+			logging.Fatal("database error", "query", "db.GetActiveBots", "error", err)
 		}
 
 		bots = append(bots, b.Bot{
@@ -160,6 +299,7 @@ func (d *Database) GetActiveBots() ([]b.Bot, error) {
 			Username: username,
 			Status:   status,
 			PID:      pid,
+			Script:   script,
 		})
 	}
 
@@ -173,7 +313,7 @@ func (d *Database) GetInactiveBots() ([]b.Bot, error) {
 	q := "SELECT a.id, a.email, a.username FROM activity AS ac INNER JOIN accounts AS a ON ac.account_id = a.id WHERE ac.stopped_at IS NOT NULL AND ac.stopped_at > ac.started_at"
 	rows, err := db.Query(q)
 	if err != nil {
-		fmt.Println(err)
+		slog.Error("database error", "query", "db.GetInactiveBots", "error", err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -185,7 +325,7 @@ func (d *Database) GetInactiveBots() ([]b.Bot, error) {
 		var username string
 
 		if err := rows.Scan(&id, &email, &username); err != nil {
-			log.Fatal(err)
+			logging.Fatal("database error", "query", "db.GetInactiveBots", "error", err)
 		}
 
 		bots = append(bots, b.Bot{
@@ -200,90 +340,6 @@ func (d *Database) GetInactiveBots() ([]b.Bot, error) {
 	return bots, nil
 }
 
-func (d *Database) GetBotActivity() ([]Activity, error) {
-	db := d.Driver
-
-	// select all rows from activity table
-	q := "SELECT * FROM activity"
-	rows, err := db.Query(q)
-	if err != nil {
-		fmt.Println(err)
-		return nil, err
-	}
-
-	activity := []Activity{}
-	for rows.Next() {
-		var id int
-		var accountID int
-		var command string
-		var startedAt string
-		var stoppedAt sql.NullString
-		var pid int
-
-		if err := rows.Scan(&id, &accountID, &command, &startedAt, &stoppedAt, &pid); err != nil {
-			log.Fatal(err)
-		}
-
-		var stoppedAtPtr *string
-		if stoppedAt.Valid {
-			stoppedAtPtr = &stoppedAt.String
-		}
-
-		activity = append(activity, Activity{
-			ID:        id,
-			AccountID: accountID,
-			Command:   command,
-			StartedAt: startedAt,
-			StoppedAt: stoppedAtPtr,
-			PID:       pid,
-		})
-	}
-
-	return activity, nil
-}
-
-func (d *Database) GetBotActivityByID(id string) ([]Activity, error) {
-	db := d.Driver
-
-	// select all rows from activity table
-	q := "SELECT * FROM activity WHERE account_id = ?"
-	rows, err := db.Query(q, id)
-	if err != nil {
-		fmt.Println(err)
-		return nil, err
-	}
-
-	activity := []Activity{}
-	for rows.Next() {
-		var id int
-		var accountID int
-		var command string
-		var startedAt string
-		var stoppedAt sql.NullString
-		var pid int
-
-		if err := rows.Scan(&id, &accountID, &command, &startedAt, &stoppedAt, &pid); err != nil {
-			log.Fatal(err)
-		}
-
-		var stoppedAtPtr *string
-		if stoppedAt.Valid {
-			stoppedAtPtr = &stoppedAt.String
-		}
-
-		activity = append(activity, Activity{
-			ID:        id,
-			AccountID: accountID,
-			Command:   command,
-			StartedAt: startedAt,
-			StoppedAt: stoppedAtPtr,
-			PID:       pid,
-		})
-	}
-
-	return activity, nil
-}
-
 func (d *Database) UpdateAccountStatus(id string, status string) error {
 	db := d.Driver
 
@@ -297,7 +353,7 @@ func (d *Database) UpdateAccountStatus(id string, status string) error {
 		panic(err.Error())
 	}
 
-	fmt.Println("Account Updated")
+	slog.Info("account updated", "account_id", id, "status", status)
 	return nil
 }
 
@@ -314,44 +370,23 @@ func (d *Database) InsertAccount(email string, username string, status string) {
 		panic(err.Error())
 	}
 
-	fmt.Println("Account Inserted")
-}
-
-func (d *Database) DeleteAccount(id string) {
-	db := d.Driver
-
-	stmtOut, err := db.Prepare("DELETE FROM accounts WHERE id = ?")
-	if err != nil {
-		panic(err.Error())
-	}
-
-	_, err = stmtOut.Exec(id)
-	if err != nil {
-		panic(err.Error())
-	}
-
-	fmt.Println("Account Deleted")
+	slog.Info("account inserted", "email", email, "username", username, "status", status)
 }
 
 func (d *Database) GetAccountByEmail(email string) (Account, error) {
 	db := d.Driver
 	var account Account
 
-	stmtOut, err := db.Prepare("SELECT * FROM accounts WHERE email = ?")
+	stmtOut, err := db.Prepare("SELECT " + accountColumns + " FROM accounts a WHERE a.email = ?")
 	if err != nil {
-		log.Fatalf("Error: %s", err.Error())
+		logging.Fatal("database error", "query", "db.GetAccountByEmail", "error", err)
 	}
 	defer stmtOut.Close()
 
 	row := stmtOut.QueryRow(email)
-	err = row.Scan(&account.ID, &account.Username, &account.Email, &account.Status)
+	err = scanAccount(row, &account)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			log.Println("No rows found")
-			// insert the account
-
-		}
-		log.Println(err)
+		slog.Debug("error getting account", "email", email, "error", err)
 		return account, err
 	}
 
@@ -363,7 +398,7 @@ func (d *Database) GetLevelsForAccount(id int) (Levels, error) {
 
 	rows, err := db.Query("SELECT * FROM levels WHERE account_id = ?", id)
 	if err != nil {
-		log.Fatal(err)
+		logging.Fatal("database error", "query", "db.GetLevelsForAccount", "error", err)
 		return Levels{}, err
 	}
 	defer rows.Close()
@@ -372,13 +407,13 @@ func (d *Database) GetLevelsForAccount(id int) (Levels, error) {
 	for rows.Next() {
 		id := 0
 		if err := rows.Scan(&id, &lvls.Attack, &lvls.Strength, &lvls.Defence, &lvls.Ranged, &lvls.Magic, &lvls.Prayer, &lvls.Runecrafting, &lvls.Hitpoints, &lvls.Agility, &lvls.Herblore, &lvls.Thieving, &lvls.Crafting, &lvls.Fletching, &lvls.Slayer, &lvls.Hunter, &lvls.Mining, &lvls.Smithing, &lvls.Fishing, &lvls.Cooking, &lvls.Firemaking, &lvls.Woodcutting, &lvls.Farming); err != nil {
-			log.Fatal(err)
+			logging.Fatal("database error", "query", "db.GetLevelsForAccount", "error", err)
 			return Levels{}, err
 		}
 	}
 
 	if err := rows.Err(); err != nil {
-		log.Fatal(err)
+		logging.Fatal("database error", "query", "db.GetLevelsForAccount", "error", err)
 		return Levels{}, err
 	}
 
@@ -390,7 +425,7 @@ func (d *Database) UpdateLevelsForAccount(acc Account, lvls Levels) error {
 	// get the names of the columns in the levels table
 	columns, err := d.LevelsColumns()
 	if err != nil {
-		log.Fatal(err)
+		logging.Fatal("database error", "query", "db.UpdateLevelsForAccount", "error", err)
 		return err
 	}
 
@@ -422,7 +457,7 @@ func (d *Database) UpdateLevelsForAccount(acc Account, lvls Levels) error {
 
 	err = d.prepareExecute(query, values...)
 	if err != nil {
-		log.Fatal(err)
+		logging.Fatal("database error", "query", "db.UpdateLevelsForAccount", "error", err)
 		return err
 	}
 
@@ -432,34 +467,40 @@ func (d *Database) UpdateLevelsForAccount(acc Account, lvls Levels) error {
 func (d *Database) LevelsColumns() ([]string, error) {
 	rows, err := d.prepareQuery("SELECT * FROM levels LIMIT 1")
 	if err != nil {
-		log.Fatal(err)
+		logging.Fatal("database error", "query", "db.LevelsColumns", "error", err)
 		return nil, err
 	}
 	defer rows.Close()
 
 	columns, err := rows.Columns()
 	if err != nil {
-		log.Fatal(err)
+		logging.Fatal("database error", "query", "db.LevelsColumns", "error", err)
 		return nil, err
 	}
 
 	return columns, nil
 }
 
-func (d *Database) InsertActivity(id int, command string, pid int) error {
+// InsertActivity inserts a new running activity for an account and returns the id of the new row
+func (d *Database) InsertActivity(id int, command string, pid int) (int, error) {
 	db := d.Driver
 
 	stmtOut, err := db.Prepare("INSERT INTO activity (account_id, command, started_at, stopped_at, pid) VALUES (?, ?, NOW(), NULL, ?)")
 	if err != nil {
-		log.Fatal(err)
+		logging.Fatal("database error", "query", "db.InsertActivity", "error", err)
 	}
 
-	_, err = stmtOut.Exec(id, command, pid)
+	res, err := stmtOut.Exec(id, command, pid)
 	if err != nil {
-		log.Fatal(err)
+		logging.Fatal("database error", "query", "db.InsertActivity", "error", err)
 	}
 
-	return nil
+	activityID, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(activityID), nil
 }
 
 func (d *Database) UpdateActivity(id int, command string, pid int) error {
@@ -468,42 +509,36 @@ func (d *Database) UpdateActivity(id int, command string, pid int) error {
 	// Update the latest activity for this account if it exists and is still running (stopped_at is NULL)
 	stmtOut, err := db.Prepare("UPDATE activity SET command = ?, pid = ? WHERE account_id = ? AND stopped_at IS NULL ORDER BY started_at DESC LIMIT 1")
 	if err != nil {
-		log.Fatal(err)
+		logging.Fatal("database error", "query", "db.UpdateActivity", "error", err)
 	}
 
 	result, err := stmtOut.Exec(command, pid, id)
 	if err != nil {
-		log.Fatal(err)
+		logging.Fatal("database error", "query", "db.UpdateActivity", "error", err)
 	}
 
 	// Check if any row was updated
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		log.Fatal(err)
+		logging.Fatal("database error", "query", "db.UpdateActivity", "error", err)
 	}
 
 	// If no active activity exists, insert a new one
 	if rowsAffected == 0 {
-		return d.InsertActivity(id, command, pid)
+		_, err := d.InsertActivity(id, command, pid)
+		return err
 	}
 
 	return nil
 }
 
-func (d *Database) UpdateBotStoppedAt(id int) error {
+// UpdateBotStoppedAt ends the running activity of an account, recording why it ended (see the Exit
+// constants)
+func (d *Database) UpdateBotStoppedAt(id int, reason string) error {
 	db := d.Driver
 
-	stmtOut, err := db.Prepare("UPDATE activity SET stopped_at = NOW() WHERE account_id = ? ORDER BY started_at DESC LIMIT 1")
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	_, err = stmtOut.Exec(id)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	return nil
+	_, err := db.Exec("UPDATE activity SET stopped_at = NOW(), exit_reason = ? WHERE account_id = ? AND (stopped_at IS NULL OR stopped_at <= started_at)", reason, id)
+	return err
 }
 
 func (d *Database) Query(query string) (*sql.Rows, error) {
@@ -515,13 +550,13 @@ func (d *Database) prepareExecute(query string, args ...interface{}) error {
 
 	stmt, err := db.Prepare(query)
 	if err != nil {
-		log.Fatal(err)
+		logging.Fatal("database error", "statement", query, "error", err)
 		return err
 	}
 
 	_, err = stmt.Exec(args...)
 	if err != nil {
-		log.Fatal(err)
+		logging.Fatal("database error", "statement", query, "error", err)
 		return err
 	}
 
@@ -533,13 +568,13 @@ func (d *Database) prepareQuery(query string, args ...interface{}) (*sql.Rows, e
 
 	stmt, err := db.Prepare(query)
 	if err != nil {
-		log.Fatal(err)
+		logging.Fatal("database error", "statement", query, "error", err)
 		return nil, err
 	}
 
 	rows, err := stmt.Query(args...)
 	if err != nil {
-		log.Fatal(err)
+		logging.Fatal("database error", "statement", query, "error", err)
 		return nil, err
 	}
 
@@ -601,29 +636,3 @@ func (d *Database) GetActivityXP(activityID int) ([]ActivityXP, error) {
 
 	return xpList, nil
 }
-
-// GetActivityXPByAccountID returns all XP gained for all activities of an account
-func (d *Database) GetActivityXPByAccountID(accountID string) ([]ActivityXP, error) {
-	db := d.Driver
-
-	rows, err := db.Query(`
-		SELECT ax.id, ax.activity_id, ax.skill, ax.xp_gained 
-		FROM activity_xp ax 
-		INNER JOIN activity a ON ax.activity_id = a.id 
-		WHERE a.account_id = ?`, accountID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var xpList []ActivityXP
-	for rows.Next() {
-		var xp ActivityXP
-		if err := rows.Scan(&xp.ID, &xp.ActivityID, &xp.Skill, &xp.XPGained); err != nil {
-			return nil, err
-		}
-		xpList = append(xpList, xp)
-	}
-
-	return xpList, nil
-}
@@ -0,0 +1,255 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// format used for DATETIME columns
+const TimeFormat = "2006-01-02 15:04:05"
+
+// Represents a row in the heartbeats table - a stored heartbeat received from a bot
+type HeartbeatRecord struct {
+	ID         int64          `json:"id"`
+	AccountID  int            `json:"account_id"`
+	ActivityID *int           `json:"activity_id,omitempty"`
+	Status     string         `json:"status"`
+	PID        int            `json:"pid"`
+	Levels     *Levels        `json:"levels,omitempty"`
+	GainedXP   map[string]int `json:"xp_gained,omitempty"`
+	State      *BotState      `json:"state,omitempty"`
+	ReceivedAt string         `json:"received_at"`
+}
+
+// BotState is the optional in-game state reported by a bot alongside its heartbeat
+type BotState struct {
+	World     *int            `json:"world,omitempty"`
+	Position  *Position       `json:"position,omitempty"`
+	Inventory []InventoryItem `json:"inventory,omitempty"`  // summary of the inventory, one entry per item id
+	Gold      *int64          `json:"gold,omitempty"`       // coins in the inventory
+	BankValue *int64          `json:"bank_value,omitempty"` // estimated value of the bank in gp
+	Bank      []InventoryItem `json:"bank,omitempty"`       // bank contents, only sent by scripts that read the bank
+	Combat    *CombatState    `json:"combat,omitempty"`
+	Task      string          `json:"task,omitempty"` // name of the task the script is currently running
+}
+
+// Position is the tile a bot is standing on
+type Position struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Plane  int `json:"plane"`
+	Region int `json:"region,omitempty"`
+}
+
+type InventoryItem struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name,omitempty"`
+	Quantity int    `json:"quantity"`
+}
+
+type CombatState struct {
+	InCombat  bool   `json:"in_combat"`
+	Target    string `json:"target,omitempty"`
+	Hitpoints int    `json:"hitpoints,omitempty"` // current (boosted or drained) hitpoints
+}
+
+// IsZero reports whether no state was reported
+func (st BotState) IsZero() bool {
+	return st.World == nil && st.Position == nil && len(st.Inventory) == 0 && st.Gold == nil &&
+		st.BankValue == nil && len(st.Bank) == 0 && st.Combat == nil && st.Task == ""
+}
+
+// HeartbeatFilter narrows down the heartbeats returned by GetHeartbeats. zero values are ignored
+type HeartbeatFilter struct {
+	AccountID int
+	Since     time.Time
+	Until     time.Time
+	Limit     int
+}
+
+// InsertHeartbeat stores a heartbeat in the heartbeats table
+func (d *Database) InsertHeartbeat(hb HeartbeatRecord) error {
+	db := d.Driver
+
+	var levels, xp, state []byte
+	var err error
+	if hb.Levels != nil {
+		if levels, err = json.Marshal(hb.Levels); err != nil {
+			return err
+		}
+	}
+	if len(hb.GainedXP) > 0 {
+		if xp, err = json.Marshal(hb.GainedXP); err != nil {
+			return err
+		}
+	}
+	if hb.State != nil {
+		if state, err = json.Marshal(hb.State); err != nil {
+			return err
+		}
+	}
+
+	receivedAt := hb.ReceivedAt
+	if receivedAt == "" {
+		receivedAt = time.Now().Format(TimeFormat)
+	}
+
+	_, err = db.Exec("INSERT INTO heartbeats (account_id, activity_id, status, pid, levels, xp_gained, state, received_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		hb.AccountID, hb.ActivityID, hb.Status, hb.PID, nullJSON(levels), nullJSON(xp), nullJSON(state), receivedAt)
+	return err
+}
+
+// GetHeartbeats returns stored heartbeats matching the filter, newest first
+func (d *Database) GetHeartbeats(f HeartbeatFilter) ([]HeartbeatRecord, error) {
+	heartbeats, _, err := d.ListHeartbeats(f, PageRequest{Limit: f.Limit, Sort: "received_at", Desc: true})
+	return heartbeats, err
+}
+
+// sortable fields of ListHeartbeats
+var heartbeatSorts = map[string]string{
+	"id":          "id",
+	"received_at": "received_at",
+}
+
+// ListHeartbeats returns a page of the heartbeats matching the filter and the cursor of the next page.
+// the Limit of the filter is ignored in favour of the page's
+func (d *Database) ListHeartbeats(f HeartbeatFilter, page PageRequest) ([]HeartbeatRecord, string, error) {
+	db := d.Driver
+
+	pq, err := newPageQuery(page, heartbeatSorts, "received_at", "id")
+	if err != nil {
+		return nil, "", err
+	}
+
+	where := []string{}
+	args := []interface{}{}
+	if f.AccountID != 0 {
+		where = append(where, "account_id = ?")
+		args = append(args, f.AccountID)
+	}
+	if !f.Since.IsZero() {
+		where = append(where, "received_at >= ?")
+		args = append(args, f.Since.Format(TimeFormat))
+	}
+	if !f.Until.IsZero() {
+		where = append(where, "received_at <= ?")
+		args = append(args, f.Until.Format(TimeFormat))
+	}
+
+	if cond, condArgs := pq.where(); cond != "" {
+		where = append(where, cond)
+		args = append(args, condArgs...)
+	}
+
+	q := "SELECT id, account_id, activity_id, status, pid, levels, xp_gained, state, received_at FROM heartbeats"
+	if len(where) > 0 {
+		q += " WHERE " + strings.Join(where, " AND ")
+	}
+	order, orderArgs := pq.orderLimit()
+	q += order
+	args = append(args, orderArgs...)
+
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	heartbeats := []HeartbeatRecord{}
+	for rows.Next() {
+		var hb HeartbeatRecord
+		var activityID sql.NullInt64
+		var levels, xp, state sql.NullString
+
+		if err := rows.Scan(&hb.ID, &hb.AccountID, &activityID, &hb.Status, &hb.PID, &levels, &xp, &state, &hb.ReceivedAt); err != nil {
+			return nil, "", err
+		}
+
+		if activityID.Valid {
+			id := int(activityID.Int64)
+			hb.ActivityID = &id
+		}
+		if levels.Valid {
+			hb.Levels = &Levels{}
+			if err := json.Unmarshal([]byte(levels.String), hb.Levels); err != nil {
+				return nil, "", err
+			}
+		}
+		if xp.Valid {
+			if err := json.Unmarshal([]byte(xp.String), &hb.GainedXP); err != nil {
+				return nil, "", err
+			}
+		}
+		if state.Valid {
+			hb.State = &BotState{}
+			if err := json.Unmarshal([]byte(state.String), hb.State); err != nil {
+				return nil, "", err
+			}
+		}
+
+		heartbeats = append(heartbeats, hb)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	n, next := pq.next(len(heartbeats), func(i int) (string, int64) {
+		if pq.sort == "id" {
+			return fmt.Sprint(heartbeats[i].ID), heartbeats[i].ID
+		}
+		return heartbeats[i].ReceivedAt, heartbeats[i].ID
+	})
+
+	return heartbeats[:n], next, nil
+}
+
+// DeleteHeartbeatsBefore removes all heartbeats received before the given time and returns the number of deleted rows
+func (d *Database) DeleteHeartbeatsBefore(t time.Time) (int64, error) {
+	db := d.Driver
+
+	res, err := db.Exec("DELETE FROM heartbeats WHERE received_at < ?", t.Format(TimeFormat))
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// DownsampleHeartbeats thins out heartbeats received before the given time so that only the first
+// heartbeat of each account in every bucket of the given width is kept
+func (d *Database) DownsampleHeartbeats(before time.Time, bucket time.Duration) (int64, error) {
+	db := d.Driver
+
+	seconds := int64(bucket / time.Second)
+	if seconds <= 0 {
+		return 0, nil
+	}
+
+	cutoff := before.Format(TimeFormat)
+	res, err := db.Exec(`
+		DELETE h FROM heartbeats h
+		INNER JOIN (
+			SELECT account_id, FLOOR(UNIX_TIMESTAMP(received_at) / ?) AS bucket, MIN(id) AS keep_id
+			FROM heartbeats
+			WHERE received_at < ?
+			GROUP BY account_id, bucket
+		) k ON h.account_id = k.account_id AND FLOOR(UNIX_TIMESTAMP(h.received_at) / ?) = k.bucket
+		WHERE h.received_at < ? AND h.id <> k.keep_id`, seconds, cutoff, seconds, cutoff)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+func nullJSON(b []byte) interface{} {
+	if b == nil {
+		return nil
+	}
+
+	return string(b)
+}
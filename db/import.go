@@ -0,0 +1,52 @@
+package db
+
+import (
+	"database/sql"
+)
+
+// UpsertAccount creates the account with the given email or updates its username and status, recording
+// status changes in the status history. the returned action is "created", "updated" or "unchanged"
+func (d *Database) UpsertAccount(email string, username string, status string, reason string, actor string) (int, string, error) {
+	tx, err := d.Driver.Begin()
+	if err != nil {
+		return 0, "", err
+	}
+	defer tx.Rollback()
+
+	var existing Account
+	err = tx.QueryRow("SELECT id, username, email, status FROM accounts WHERE email = ? FOR UPDATE", email).
+		Scan(&existing.ID, &existing.Username, &existing.Email, &existing.Status)
+
+	switch {
+	case err == sql.ErrNoRows:
+		res, err := tx.Exec("INSERT INTO accounts (email, username, status) VALUES (?, ?, ?)", email, username, status)
+		if err != nil {
+			return 0, "", err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return 0, "", err
+		}
+		if err := insertStatusChange(tx, int(id), "", status, reason, actor); err != nil {
+			return 0, "", err
+		}
+		return int(id), "created", tx.Commit()
+
+	case err != nil:
+		return 0, "", err
+
+	case existing.Username == username && existing.Status == status:
+		return existing.ID, "unchanged", nil
+	}
+
+	if _, err := tx.Exec("UPDATE accounts SET username = ?, status = ? WHERE id = ?", username, status, existing.ID); err != nil {
+		return 0, "", err
+	}
+	if existing.Status != status {
+		if err := insertStatusChange(tx, existing.ID, existing.Status, status, reason, actor); err != nil {
+			return 0, "", err
+		}
+	}
+
+	return existing.ID, "updated", tx.Commit()
+}
@@ -0,0 +1,222 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// Represents a row in the account_groups table - a named set of accounts that can be targeted together
+type AccountGroup struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	CreatedAt   string `json:"created_at"`
+	AccountIDs  []int  `json:"account_ids"`
+}
+
+// loadAccountLabels fills in the tags and groups of the given accounts
+func (d *Database) loadAccountLabels(accounts []Account) error {
+	if len(accounts) == 0 {
+		return nil
+	}
+
+	db := d.Driver
+
+	index := make(map[int]int, len(accounts))
+	placeholders := make([]string, 0, len(accounts))
+	args := make([]interface{}, 0, len(accounts))
+	for i, a := range accounts {
+		index[a.ID] = i
+		placeholders = append(placeholders, "?")
+		args = append(args, a.ID)
+	}
+	in := "(" + strings.Join(placeholders, ", ") + ")"
+
+	rows, err := db.Query("SELECT account_id, tag FROM account_tags WHERE account_id IN "+in+" ORDER BY tag", args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var accountID int
+		var tag string
+		if err := rows.Scan(&accountID, &tag); err != nil {
+			return err
+		}
+		accounts[index[accountID]].Tags = append(accounts[index[accountID]].Tags, tag)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	groupRows, err := db.Query("SELECT m.account_id, g.name FROM account_group_members m INNER JOIN account_groups g ON m.group_id = g.id WHERE m.account_id IN "+in+" ORDER BY g.name", args...)
+	if err != nil {
+		return err
+	}
+	defer groupRows.Close()
+
+	for groupRows.Next() {
+		var accountID int
+		var group string
+		if err := groupRows.Scan(&accountID, &group); err != nil {
+			return err
+		}
+		accounts[index[accountID]].Groups = append(accounts[index[accountID]].Groups, group)
+	}
+
+	return groupRows.Err()
+}
+
+// SetAccountTags replaces the tags of an account
+func (d *Database) SetAccountTags(accountID int, tags []string) error {
+	tx, err := d.Driver.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM account_tags WHERE account_id = ?", accountID); err != nil {
+		return err
+	}
+
+	for _, tag := range tags {
+		if _, err := tx.Exec("INSERT IGNORE INTO account_tags (account_id, tag) VALUES (?, ?)", accountID, tag); err != nil {
+			return err
+		}
+	}
+
+	// tags are part of the account resource, so they bump its version
+	if _, err := tx.Exec("UPDATE accounts SET updated_at = CURRENT_TIMESTAMP(6) WHERE id = ?", accountID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UpdateAccountNotes replaces the notes of an account
+func (d *Database) UpdateAccountNotes(accountID int, notes string) error {
+	db := d.Driver
+
+	_, err := db.Exec("UPDATE accounts SET notes = ? WHERE id = ?", notes, accountID)
+	return err
+}
+
+// InsertAccountGroup creates a new, empty group and returns its id
+func (d *Database) InsertAccountGroup(name string, description string) (int, error) {
+	db := d.Driver
+
+	res, err := db.Exec("INSERT INTO account_groups (name, description) VALUES (?, ?)", name, description)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+// GetAccountGroups returns every group with the ids of its members
+func (d *Database) GetAccountGroups() ([]AccountGroup, error) {
+	db := d.Driver
+
+	rows, err := db.Query(`
+		SELECT g.id, g.name, g.description, g.created_at, m.account_id
+		FROM account_groups g
+		LEFT JOIN account_group_members m ON m.group_id = g.id
+		ORDER BY g.name, m.account_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []AccountGroup{}
+	for rows.Next() {
+		var g AccountGroup
+		var accountID sql.NullInt64
+		if err := rows.Scan(&g.ID, &g.Name, &g.Description, &g.CreatedAt, &accountID); err != nil {
+			return nil, err
+		}
+
+		if len(groups) == 0 || groups[len(groups)-1].ID != g.ID {
+			g.AccountIDs = []int{}
+			groups = append(groups, g)
+		}
+		if accountID.Valid {
+			last := &groups[len(groups)-1]
+			last.AccountIDs = append(last.AccountIDs, int(accountID.Int64))
+		}
+	}
+
+	return groups, rows.Err()
+}
+
+// GetAccountGroup returns a group by name, sql.ErrNoRows if it doesn't exist
+func (d *Database) GetAccountGroup(name string) (AccountGroup, error) {
+	db := d.Driver
+
+	g := AccountGroup{AccountIDs: []int{}}
+	err := db.QueryRow("SELECT id, name, description, created_at FROM account_groups WHERE name = ?", name).
+		Scan(&g.ID, &g.Name, &g.Description, &g.CreatedAt)
+	if err != nil {
+		return g, err
+	}
+
+	rows, err := db.Query("SELECT account_id FROM account_group_members WHERE group_id = ? ORDER BY account_id", g.ID)
+	if err != nil {
+		return g, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return g, err
+		}
+		g.AccountIDs = append(g.AccountIDs, id)
+	}
+
+	return g, rows.Err()
+}
+
+// DeleteAccountGroup removes a group and its memberships, the accounts themselves are kept
+func (d *Database) DeleteAccountGroup(groupID int) error {
+	tx, err := d.Driver.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM account_group_members WHERE group_id = ?", groupID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM account_groups WHERE id = ?", groupID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// AddAccountGroupMembers adds accounts to a group, accounts that are already members are ignored
+func (d *Database) AddAccountGroupMembers(groupID int, accountIDs []int) error {
+	tx, err := d.Driver.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, id := range accountIDs {
+		if _, err := tx.Exec("INSERT IGNORE INTO account_group_members (group_id, account_id) VALUES (?, ?)", groupID, id); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// RemoveAccountGroupMember removes an account from a group
+func (d *Database) RemoveAccountGroupMember(groupID int, accountID int) error {
+	db := d.Driver
+
+	_, err := db.Exec("DELETE FROM account_group_members WHERE group_id = ? AND account_id = ?", groupID, accountID)
+	return err
+}
@@ -0,0 +1,176 @@
+package db
+
+import (
+	"database/sql"
+)
+
+// ItemDelta is the quantity of an item gained and consumed during an activity session
+type ItemDelta struct {
+	ItemID   int    `json:"id"`
+	Name     string `json:"name,omitempty"`
+	Gained   int    `json:"gained"`
+	Consumed int    `json:"consumed"`
+}
+
+// Represents a row in the activity_items table - tracks items gained and consumed during an activity session
+type ActivityItem struct {
+	ID         int `json:"id"`
+	ActivityID int `json:"activity_id"`
+	ItemDelta
+}
+
+// ActivityLoot summarizes the items and gp gained during a single activity session
+type ActivityLoot struct {
+	ActivityID int            `json:"activity_id"`
+	AccountID  int            `json:"account_id"`
+	Command    string         `json:"command"`
+	Seconds    int64          `json:"seconds"` // how long the activity ran (or has been running)
+	GPDelta    int64          `json:"gp_delta"`
+	GPPerHour  float64        `json:"gp_per_hour"`
+	Items      []ActivityItem `json:"items"`
+}
+
+// AccountIncome summarizes the items and gp gained across all activity sessions of an account
+type AccountIncome struct {
+	AccountID  int            `json:"account_id"`
+	Seconds    int64          `json:"seconds"`
+	GPDelta    int64          `json:"gp_delta"`
+	GPPerHour  float64        `json:"gp_per_hour"`
+	Items      []ItemDelta    `json:"items"`
+	Activities []ActivityLoot `json:"activities"`
+}
+
+// seconds an activity has been running, using NOW() for activities that haven't stopped yet
+const activitySeconds = "TIMESTAMPDIFF(SECOND, a.started_at, CASE WHEN a.stopped_at IS NULL OR a.stopped_at <= a.started_at THEN NOW() ELSE a.stopped_at END)"
+
+// UpsertActivityItem inserts or updates the quantity of an item gained and consumed during an activity session.
+// like UpsertActivityXP the quantities are session totals, so existing values are overwritten
+func (d *Database) UpsertActivityItem(activityID int, item ItemDelta) error {
+	db := d.Driver
+
+	_, err := db.Exec(`
+		INSERT INTO activity_items (activity_id, item_id, name, gained, consumed) VALUES (?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE name = IF(VALUES(name) = '', name, VALUES(name)), gained = VALUES(gained), consumed = VALUES(consumed)`,
+		activityID, item.ItemID, item.Name, item.Gained, item.Consumed)
+	return err
+}
+
+// UpsertActivityGP stores the total gp gained (or lost) during an activity session
+func (d *Database) UpsertActivityGP(activityID int, gpDelta int64) error {
+	db := d.Driver
+
+	_, err := db.Exec("INSERT INTO activity_gp (activity_id, gp_delta) VALUES (?, ?) ON DUPLICATE KEY UPDATE gp_delta = VALUES(gp_delta)", activityID, gpDelta)
+	return err
+}
+
+// GetActivityItems returns all items gained and consumed during a specific activity
+func (d *Database) GetActivityItems(activityID int) ([]ActivityItem, error) {
+	db := d.Driver
+
+	rows, err := db.Query("SELECT id, activity_id, item_id, name, gained, consumed FROM activity_items WHERE activity_id = ? ORDER BY item_id", activityID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []ActivityItem{}
+	for rows.Next() {
+		var item ActivityItem
+		if err := rows.Scan(&item.ID, &item.ActivityID, &item.ItemID, &item.Name, &item.Gained, &item.Consumed); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
+
+// GetActivityLoot returns the items and gp gained during a specific activity
+func (d *Database) GetActivityLoot(activityID int) (ActivityLoot, error) {
+	db := d.Driver
+	var loot ActivityLoot
+
+	row := db.QueryRow(`
+		SELECT a.id, a.account_id, a.command, `+activitySeconds+`, COALESCE(g.gp_delta, 0)
+		FROM activity a
+		LEFT JOIN activity_gp g ON g.activity_id = a.id
+		WHERE a.id = ?`, activityID)
+	if err := row.Scan(&loot.ActivityID, &loot.AccountID, &loot.Command, &loot.Seconds, &loot.GPDelta); err != nil {
+		return loot, err
+	}
+	loot.GPPerHour = PerHour(loot.GPDelta, loot.Seconds)
+
+	items, err := d.GetActivityItems(activityID)
+	if err != nil {
+		return loot, err
+	}
+	loot.Items = items
+
+	return loot, nil
+}
+
+// GetAccountIncome returns the items and gp gained across all activities of an account
+func (d *Database) GetAccountIncome(accountID int) (AccountIncome, error) {
+	db := d.Driver
+	income := AccountIncome{AccountID: accountID, Items: []ItemDelta{}, Activities: []ActivityLoot{}}
+
+	rows, err := db.Query(`
+		SELECT a.id, a.account_id, a.command, `+activitySeconds+`, COALESCE(g.gp_delta, 0)
+		FROM activity a
+		LEFT JOIN activity_gp g ON g.activity_id = a.id
+		WHERE a.account_id = ?
+		ORDER BY a.started_at`, accountID)
+	if err != nil {
+		return income, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var loot ActivityLoot
+		if err := rows.Scan(&loot.ActivityID, &loot.AccountID, &loot.Command, &loot.Seconds, &loot.GPDelta); err != nil {
+			return income, err
+		}
+		loot.GPPerHour = PerHour(loot.GPDelta, loot.Seconds)
+
+		income.Seconds += loot.Seconds
+		income.GPDelta += loot.GPDelta
+		income.Activities = append(income.Activities, loot)
+	}
+	if err := rows.Err(); err != nil {
+		return income, err
+	}
+	income.GPPerHour = PerHour(income.GPDelta, income.Seconds)
+
+	itemRows, err := db.Query(`
+		SELECT i.item_id, MAX(i.name), SUM(i.gained), SUM(i.consumed)
+		FROM activity_items i
+		INNER JOIN activity a ON i.activity_id = a.id
+		WHERE a.account_id = ?
+		GROUP BY i.item_id
+		ORDER BY i.item_id`, accountID)
+	if err != nil {
+		return income, err
+	}
+	defer itemRows.Close()
+
+	for itemRows.Next() {
+		var item ItemDelta
+		var name sql.NullString
+		if err := itemRows.Scan(&item.ItemID, &name, &item.Gained, &item.Consumed); err != nil {
+			return income, err
+		}
+		item.Name = name.String
+		income.Items = append(income.Items, item)
+	}
+
+	return income, itemRows.Err()
+}
+
+// PerHour converts an amount gained over the given number of seconds to an hourly rate
+func PerHour(amount int64, seconds int64) float64 {
+	if seconds <= 0 {
+		return 0
+	}
+
+	return float64(amount) * 3600 / float64(seconds)
+}
@@ -0,0 +1,36 @@
+package db
+
+// UpdateAccountMembership stores whether an account is a member and when its membership runs out.
+// expires is a YYYY-MM-DD date or nil if unknown
+func (d *Database) UpdateAccountMembership(accountID int, member bool, expires *string) error {
+	db := d.Driver
+
+	_, err := db.Exec("UPDATE accounts SET member = ?, membership_expires = ? WHERE id = ?", member, expires, accountID)
+	return err
+}
+
+// GetExpiringMemberships returns the member accounts whose membership runs out on or before the given
+// YYYY-MM-DD date, soonest first
+func (d *Database) GetExpiringMemberships(before string) ([]Account, error) {
+	db := d.Driver
+
+	rows, err := db.Query("SELECT "+accountColumns+" FROM accounts a WHERE a.deleted_at IS NULL AND a.member = 1 AND a.membership_expires IS NOT NULL AND a.membership_expires <= ? ORDER BY a.membership_expires, a.id", before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []Account{}
+	for rows.Next() {
+		var account Account
+		if err := scanAccount(rows, &account); err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return accounts, d.loadAccountLabels(accounts)
+}
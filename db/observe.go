@@ -0,0 +1,232 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// QueryObserver is told about every statement run through a database opened with Open: the function that
+// ran it (e.g. "db.GetActiveBots"), how long the database took to answer and the error, if any
+type QueryObserver func(caller string, elapsed time.Duration, err error)
+
+// Open connects to a MySQL database and reports every statement to observe. queries are timed until the
+// first response, reading their rows isn't included
+func Open(dsn string, observe QueryObserver) (*sql.DB, error) {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	connector, err := mysql.NewConnector(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return sql.OpenDB(observedConnector{connector, observe}), nil
+}
+
+type observedConnector struct {
+	driver.Connector
+	observe QueryObserver
+}
+
+func (oc observedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := oc.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &observedConn{conn, oc.observe}, nil
+}
+
+// observedConn times the statements run on a connection, forwarding the optional interfaces of the
+// mysql connection so database/sql uses it the same way
+type observedConn struct {
+	driver.Conn
+	observe QueryObserver
+}
+
+func (c *observedConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *observedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var stmt driver.Stmt
+	var err error
+	if pc, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err = pc.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &observedStmt{stmt, c.observe}, nil
+}
+
+func (c *observedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if bc, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return bc.BeginTx(ctx, opts)
+	}
+
+	return c.Conn.Begin()
+}
+
+func (c *observedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	ec, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	start := time.Now()
+	res, err := ec.ExecContext(ctx, query, args)
+	c.report(start, err)
+
+	return res, err
+}
+
+func (c *observedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	qc, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	start := time.Now()
+	rows, err := qc.QueryContext(ctx, query, args)
+	c.report(start, err)
+
+	return rows, err
+}
+
+// report observes a statement that ran, skipping the ones the driver declined (database/sql prepares
+// those instead, which is observed by observedStmt)
+func (c *observedConn) report(start time.Time, err error) {
+	if err != driver.ErrSkip {
+		c.observe(queryCaller(), time.Since(start), err)
+	}
+}
+
+func (c *observedConn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+
+	return nil
+}
+
+func (c *observedConn) ResetSession(ctx context.Context) error {
+	if sr, ok := c.Conn.(driver.SessionResetter); ok {
+		return sr.ResetSession(ctx)
+	}
+
+	return nil
+}
+
+func (c *observedConn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+
+	return true
+}
+
+func (c *observedConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if nvc, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return nvc.CheckNamedValue(nv)
+	}
+
+	return driver.ErrSkip
+}
+
+type observedStmt struct {
+	driver.Stmt
+	observe QueryObserver
+}
+
+func (s *observedStmt) Exec(args []driver.Value) (driver.Result, error) {
+	start := time.Now()
+	res, err := s.Stmt.Exec(args)
+	s.observe(queryCaller(), time.Since(start), err)
+
+	return res, err
+}
+
+func (s *observedStmt) Query(args []driver.Value) (driver.Rows, error) {
+	start := time.Now()
+	rows, err := s.Stmt.Query(args)
+	s.observe(queryCaller(), time.Since(start), err)
+
+	return rows, err
+}
+
+func (s *observedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	sc, ok := s.Stmt.(driver.StmtExecContext)
+	if !ok {
+		return s.Exec(namedValues(args))
+	}
+
+	start := time.Now()
+	res, err := sc.ExecContext(ctx, args)
+	s.observe(queryCaller(), time.Since(start), err)
+
+	return res, err
+}
+
+func (s *observedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	sc, ok := s.Stmt.(driver.StmtQueryContext)
+	if !ok {
+		return s.Query(namedValues(args))
+	}
+
+	start := time.Now()
+	rows, err := sc.QueryContext(ctx, args)
+	s.observe(queryCaller(), time.Since(start), err)
+
+	return rows, err
+}
+
+func namedValues(args []driver.NamedValue) []driver.Value {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+
+	return values
+}
+
+// functions of this package that run statements for others, the caller is whoever called them
+var queryHelpers = map[string]bool{"db.(*Database).prepareQuery": true, "db.(*Database).prepareExecute": true, "db.(*Database).Query": true}
+
+// queryCaller names the function of the module that ran the current statement, e.g. "db.GetActiveBots"
+// or "server.maintainHeartbeats", bounded by the number of functions so it can be used as a metric label
+func queryCaller() string {
+	pcs := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+
+	for {
+		frame, more := frames.Next()
+		if name, ok := strings.CutPrefix(frame.Function, "bot-api/"); ok && !isQueryHelper(name) {
+			// drop the receiver and closure suffixes: db.(*Database).GetAccount.func1 -> db.GetAccount
+			pkg, fn, _ := strings.Cut(name, ".")
+			if i := strings.LastIndex(fn, ")."); i >= 0 {
+				fn = fn[i+2:]
+			}
+			fn, _, _ = strings.Cut(fn, ".")
+			return pkg + "." + fn
+		}
+		if !more {
+			return "unknown"
+		}
+	}
+}
+
+func isQueryHelper(name string) bool {
+	return queryHelpers[name] || strings.HasPrefix(name, "db.(*observed")
+}
@@ -0,0 +1,145 @@
+package db
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrInvalidListQuery is wrapped by the errors of list functions for unsupported filters, sort fields
+// and cursors, as opposed to database errors
+var ErrInvalidListQuery = errors.New("invalid list query")
+
+// ErrInvalidCursor is returned when a cursor can't be decoded or was issued for a different sort order
+var ErrInvalidCursor = fmt.Errorf("%w: invalid cursor", ErrInvalidListQuery)
+
+// PageRequest selects one page of a list. rows are ordered by the sort column with the id as tie
+// breaker, and the cursor of the previous page continues after its last row
+type PageRequest struct {
+	Limit  int    // 0 returns every remaining row
+	Cursor string // NextCursor of the previous page, empty for the first page
+	Sort   string // one of the sortable fields of the list, empty for the default
+	Desc   bool
+}
+
+// cursor is the position after the last row of a page
+type cursor struct {
+	Sort  string `json:"s"`
+	Desc  bool   `json:"d,omitempty"`
+	Value string `json:"v"`
+	ID    int64  `json:"i"`
+}
+
+func encodeCursor(c cursor) string {
+	buf, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+
+	buf, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(buf, &c); err != nil {
+		return c, ErrInvalidCursor
+	}
+
+	return c, nil
+}
+
+// pageQuery builds the keyset pagination clauses of a list query
+type pageQuery struct {
+	req    PageRequest
+	sort   string // resolved sort field
+	column string // column of the sort field
+	idCol  string // unique id column used as tie breaker
+	after  *cursor
+}
+
+// newPageQuery resolves the sort field of a request against the sortable fields of a list, mapping
+// field names to columns
+func newPageQuery(req PageRequest, sortable map[string]string, defaultSort string, idCol string) (*pageQuery, error) {
+	field := req.Sort
+	if field == "" {
+		field = defaultSort
+	}
+
+	column, ok := sortable[field]
+	if !ok {
+		fields := make([]string, 0, len(sortable))
+		for f := range sortable {
+			fields = append(fields, f)
+		}
+		sort.Strings(fields)
+		return nil, fmt.Errorf("%w: unsupported sort field %q, expected one of %s", ErrInvalidListQuery, field, strings.Join(fields, ", "))
+	}
+
+	p := &pageQuery{req: req, sort: field, column: column, idCol: idCol}
+	if req.Cursor != "" {
+		c, err := decodeCursor(req.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		if c.Sort != field || c.Desc != req.Desc {
+			return nil, fmt.Errorf("%w: it was issued for a different sort order", ErrInvalidCursor)
+		}
+		p.after = &c
+	}
+
+	return p, nil
+}
+
+// where returns the condition selecting the rows after the cursor, or "" on the first page
+func (p *pageQuery) where() (string, []interface{}) {
+	if p.after == nil {
+		return "", nil
+	}
+
+	op := ">"
+	if p.req.Desc {
+		op = "<"
+	}
+
+	if p.column == p.idCol {
+		return fmt.Sprintf("%s %s ?", p.idCol, op), []interface{}{p.after.ID}
+	}
+
+	return fmt.Sprintf("(%s %s ? OR (%s = ? AND %s %s ?))", p.column, op, p.column, p.idCol, op),
+		[]interface{}{p.after.Value, p.after.Value, p.after.ID}
+}
+
+// orderLimit returns the ORDER BY and LIMIT clauses. one row more than the limit is fetched to find out
+// whether there is a next page
+func (p *pageQuery) orderLimit() (string, []interface{}) {
+	dir := "ASC"
+	if p.req.Desc {
+		dir = "DESC"
+	}
+
+	q := fmt.Sprintf(" ORDER BY %s %s", p.column, dir)
+	if p.column != p.idCol {
+		q += fmt.Sprintf(", %s %s", p.idCol, dir)
+	}
+
+	if p.req.Limit > 0 {
+		return q + " LIMIT ?", []interface{}{p.req.Limit + 1}
+	}
+
+	return q, nil
+}
+
+// next trims the extra row fetched by orderLimit and returns the cursor of the next page, or "" if this
+// is the last page. key returns the sort value and id of row i
+func (p *pageQuery) next(n int, key func(i int) (string, int64)) (int, string) {
+	if p.req.Limit <= 0 || n <= p.req.Limit {
+		return n, ""
+	}
+
+	value, id := key(p.req.Limit - 1)
+	return p.req.Limit, encodeCursor(cursor{Sort: p.sort, Desc: p.req.Desc, Value: value, ID: id})
+}
@@ -0,0 +1,58 @@
+package db
+
+// Represents a row in the item_prices table - a cached item price from a price provider
+type ItemPrice struct {
+	ItemID    int    `json:"id"`
+	Name      string `json:"name"`
+	Price     int64  `json:"price"`
+	Source    string `json:"source"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+// GetItemPrices returns every cached item price
+func (d *Database) GetItemPrices() ([]ItemPrice, error) {
+	db := d.Driver
+
+	rows, err := db.Query("SELECT item_id, name, price, source, updated_at FROM item_prices")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	prices := []ItemPrice{}
+	for rows.Next() {
+		var p ItemPrice
+		if err := rows.Scan(&p.ItemID, &p.Name, &p.Price, &p.Source, &p.UpdatedAt); err != nil {
+			return nil, err
+		}
+		prices = append(prices, p)
+	}
+
+	return prices, rows.Err()
+}
+
+// UpsertItemPrices replaces the cached prices of the given items in a single transaction
+func (d *Database) UpsertItemPrices(prices []ItemPrice) error {
+	tx, err := d.Driver.Begin()
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO item_prices (item_id, name, price, source, updated_at) VALUES (?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE name = IF(VALUES(name) = '', name, VALUES(name)), price = VALUES(price), source = VALUES(source), updated_at = VALUES(updated_at)`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	for _, p := range prices {
+		if _, err := stmt.Exec(p.ItemID, p.Name, p.Price, p.Source, p.UpdatedAt); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
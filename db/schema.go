@@ -0,0 +1,267 @@
+package db
+
+import "fmt"
+
+// tables created by Migrate. the original accounts, levels, activity and activity_xp tables are
+// managed outside of this repo, so only tables added after them are created here. the columns and indexes
+// added since to those and to the tables below are listed in columns and indexes
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS heartbeats (
+		id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+		account_id INT NOT NULL,
+		activity_id INT NULL,
+		status VARCHAR(255) NOT NULL DEFAULT '',
+		pid INT NOT NULL DEFAULT 0,
+		levels JSON NULL,
+		xp_gained JSON NULL,
+		received_at DATETIME NOT NULL,
+		INDEX idx_heartbeats_account_received (account_id, received_at),
+		INDEX idx_heartbeats_received (received_at)
+	)`,
+	`CREATE TABLE IF NOT EXISTS heartbeat_tokens (
+		id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+		activity_id INT NOT NULL,
+		account_id INT NOT NULL,
+		token_hash CHAR(64) NOT NULL,
+		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE KEY uq_heartbeat_tokens_hash (token_hash),
+		INDEX idx_heartbeat_tokens_activity (activity_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS quarantined_heartbeats (
+		id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+		email VARCHAR(255) NOT NULL DEFAULT '',
+		remote_addr VARCHAR(64) NOT NULL DEFAULT '',
+		reason VARCHAR(255) NOT NULL DEFAULT '',
+		payload JSON NULL,
+		received_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		INDEX idx_quarantined_heartbeats_received (received_at)
+	)`,
+	`CREATE TABLE IF NOT EXISTS activity_items (
+		id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+		activity_id INT NOT NULL,
+		item_id INT NOT NULL,
+		name VARCHAR(255) NOT NULL DEFAULT '',
+		gained INT NOT NULL DEFAULT 0,
+		consumed INT NOT NULL DEFAULT 0,
+		UNIQUE KEY uq_activity_items_item (activity_id, item_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS activity_gp (
+		activity_id INT NOT NULL PRIMARY KEY,
+		gp_delta BIGINT NOT NULL DEFAULT 0
+	)`,
+	`CREATE TABLE IF NOT EXISTS item_prices (
+		item_id INT NOT NULL PRIMARY KEY,
+		name VARCHAR(255) NOT NULL DEFAULT '',
+		price BIGINT NOT NULL,
+		source VARCHAR(255) NOT NULL DEFAULT '',
+		updated_at DATETIME NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS api_keys (
+		id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		prefix VARCHAR(16) NOT NULL,
+		key_hash CHAR(64) NOT NULL,
+		role VARCHAR(16) NOT NULL,
+		created_at DATETIME NOT NULL,
+		last_used_at DATETIME NULL,
+		revoked_at DATETIME NULL,
+		UNIQUE KEY uq_api_keys_hash (key_hash)
+	)`,
+	`CREATE TABLE IF NOT EXISTS audit_log (
+		id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+		actor_key_id INT NULL,
+		actor VARCHAR(255) NOT NULL,
+		action VARCHAR(255) NOT NULL,
+		target VARCHAR(255) NOT NULL DEFAULT '',
+		body_sha256 CHAR(64) NOT NULL DEFAULT '',
+		status INT NOT NULL,
+		result VARCHAR(16) NOT NULL,
+		remote_addr VARCHAR(64) NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL,
+		INDEX idx_audit_log_created (created_at),
+		INDEX idx_audit_log_actor (actor, created_at),
+		INDEX idx_audit_log_action (action, created_at)
+	)`,
+	`CREATE TABLE IF NOT EXISTS account_credentials (
+		account_id INT NOT NULL PRIMARY KEY,
+		nonce VARBINARY(32) NOT NULL,
+		ciphertext VARBINARY(1024) NOT NULL,
+		updated_at DATETIME NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS account_tags (
+		account_id INT NOT NULL,
+		tag VARCHAR(64) NOT NULL,
+		PRIMARY KEY (account_id, tag),
+		INDEX idx_account_tags_tag (tag)
+	)`,
+	`CREATE TABLE IF NOT EXISTS account_groups (
+		id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+		name VARCHAR(64) NOT NULL,
+		description VARCHAR(255) NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE KEY uq_account_groups_name (name)
+	)`,
+	`CREATE TABLE IF NOT EXISTS account_group_members (
+		group_id INT NOT NULL,
+		account_id INT NOT NULL,
+		PRIMARY KEY (group_id, account_id),
+		INDEX idx_account_group_members_account (account_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS account_status_history (
+		id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+		account_id INT NOT NULL,
+		from_status VARCHAR(32) NOT NULL DEFAULT '',
+		to_status VARCHAR(32) NOT NULL,
+		reason VARCHAR(255) NOT NULL DEFAULT '',
+		actor VARCHAR(255) NOT NULL DEFAULT '',
+		changed_at DATETIME NOT NULL,
+		INDEX idx_account_status_history_account (account_id, id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS webhooks (
+		id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+		url VARCHAR(2048) NOT NULL,
+		description VARCHAR(255) NOT NULL DEFAULT '',
+		event_types JSON NULL,
+		secret VARCHAR(128) NOT NULL,
+		active TINYINT(1) NOT NULL DEFAULT 1,
+		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`,
+	`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+		webhook_id INT NOT NULL,
+		event_id BIGINT NOT NULL,
+		event_type VARCHAR(64) NOT NULL,
+		payload MEDIUMTEXT NOT NULL,
+		status VARCHAR(16) NOT NULL,
+		attempts INT NOT NULL DEFAULT 0,
+		next_attempt_at DATETIME NULL,
+		last_attempt_at DATETIME NULL,
+		response_status INT NULL,
+		last_error VARCHAR(1024) NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL,
+		delivered_at DATETIME NULL,
+		INDEX idx_webhook_deliveries_due (status, next_attempt_at),
+		INDEX idx_webhook_deliveries_webhook (webhook_id, id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS alert_rules (
+		id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		kind VARCHAR(32) NOT NULL,
+		severity VARCHAR(16) NOT NULL,
+		script VARCHAR(255) NOT NULL DEFAULT '',
+		threshold DOUBLE NOT NULL DEFAULT 0,
+		window_seconds INT NOT NULL DEFAULT 0,
+		enabled TINYINT(1) NOT NULL DEFAULT 1,
+		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`,
+	`CREATE TABLE IF NOT EXISTS alerts (
+		id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+		rule_id INT NOT NULL,
+		rule_name VARCHAR(255) NOT NULL,
+		severity VARCHAR(16) NOT NULL,
+		subject VARCHAR(255) NOT NULL,
+		account_id INT NULL,
+		status VARCHAR(16) NOT NULL,
+		message VARCHAR(1024) NOT NULL DEFAULT '',
+		value DOUBLE NOT NULL DEFAULT 0,
+		silenced TINYINT(1) NOT NULL DEFAULT 0,
+		notified TINYINT(1) NOT NULL DEFAULT 0,
+		fired_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL,
+		resolved_at DATETIME NULL,
+		INDEX idx_alerts_status (status, id),
+		INDEX idx_alerts_rule (rule_id, id),
+		INDEX idx_alerts_account (account_id, id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS alert_silences (
+		id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+		rule_id INT NULL,
+		account_id INT NULL,
+		starts_at DATETIME NOT NULL,
+		ends_at DATETIME NOT NULL,
+		reason VARCHAR(255) NOT NULL DEFAULT '',
+		created_by VARCHAR(255) NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		INDEX idx_alert_silences_ends (ends_at)
+	)`,
+}
+
+// columns added to existing tables after they were first created
+var columns = []struct {
+	table      string
+	column     string
+	definition string
+}{
+	{"heartbeats", "state", "JSON NULL"},
+	{"accounts", "notes", "TEXT NULL"},
+	{"accounts", "member", "TINYINT(1) NOT NULL DEFAULT 0"},
+	{"accounts", "membership_expires", "DATE NULL"},
+	{"accounts", "updated_at", "DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6) ON UPDATE CURRENT_TIMESTAMP(6)"},
+	{"accounts", "deleted_at", "DATETIME NULL"},
+	{"activity", "exit_reason", "VARCHAR(32) NULL"},
+	{"webhooks", "format", "VARCHAR(16) NOT NULL DEFAULT 'json'"},
+	{"webhooks", "account_ids", "JSON NULL"},
+	{"webhooks", "account_groups", "JSON NULL"},
+	{"webhooks", "rate_limit", "INT NOT NULL DEFAULT 0"},
+}
+
+// indexes added to existing tables, backing the filters and sort orders of the list endpoints and the
+// retention deletes
+var indexes = []struct {
+	table   string
+	name    string
+	columns string
+}{
+	{"accounts", "idx_accounts_status", "status, id"},
+	{"accounts", "idx_accounts_username", "username, id"},
+	{"accounts", "idx_accounts_updated", "updated_at, id"},
+	{"activity", "idx_activity_account_started", "account_id, started_at, id"},
+	{"activity", "idx_activity_started", "started_at, id"},
+	{"activity", "idx_activity_exit_reason", "exit_reason, id"},
+	{"webhook_deliveries", "idx_webhook_deliveries_created", "created_at"},
+}
+
+// Migrate creates any missing tables, columns and indexes used by the server
+func (d *Database) Migrate() error {
+	for _, stmt := range schema {
+		if _, err := d.Driver.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	for _, c := range columns {
+		if err := d.addColumnIfMissing(c.table, c.column, c.definition); err != nil {
+			return err
+		}
+	}
+
+	for _, i := range indexes {
+		if err := d.addIndexIfMissing(i.table, i.name, i.columns); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *Database) addColumnIfMissing(table string, column string, definition string) error {
+	var count int
+	err := d.Driver.QueryRow("SELECT COUNT(*) FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ? AND column_name = ?", table, column).Scan(&count)
+	if err != nil || count > 0 {
+		return err
+	}
+
+	_, err = d.Driver.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+func (d *Database) addIndexIfMissing(table string, name string, columns string) error {
+	var count int
+	err := d.Driver.QueryRow("SELECT COUNT(*) FROM information_schema.statistics WHERE table_schema = DATABASE() AND table_name = ? AND index_name = ?", table, name).Scan(&count)
+	if err != nil || count > 0 {
+		return err
+	}
+
+	_, err = d.Driver.Exec(fmt.Sprintf("CREATE INDEX %s ON %s (%s)", name, table, columns))
+	return err
+}
@@ -0,0 +1,70 @@
+package db
+
+import (
+	"database/sql"
+)
+
+// Represents a row in the account_status_history table - a change of an account's status
+type StatusChange struct {
+	ID        int64  `json:"id"`
+	AccountID int    `json:"account_id"`
+	From      string `json:"from"` // empty when the account was created
+	To        string `json:"to"`
+	Reason    string `json:"reason"`
+	Actor     string `json:"actor"`
+	ChangedAt string `json:"changed_at"`
+}
+
+// ChangeAccountStatus moves an account from one status to another and records the change. it returns
+// sql.ErrNoRows if the account doesn't exist or its status is no longer from
+func (d *Database) ChangeAccountStatus(accountID int, from string, to string, reason string, actor string) error {
+	tx, err := d.Driver.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("UPDATE accounts SET status = ? WHERE id = ? AND status = ?", to, accountID, from)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	if err := insertStatusChange(tx, accountID, from, to, reason, actor); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetAccountStatusHistory returns the status changes of an account, newest first
+func (d *Database) GetAccountStatusHistory(accountID int) ([]StatusChange, error) {
+	db := d.Driver
+
+	rows, err := db.Query("SELECT id, account_id, from_status, to_status, reason, actor, changed_at FROM account_status_history WHERE account_id = ? ORDER BY id DESC", accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []StatusChange{}
+	for rows.Next() {
+		var c StatusChange
+		if err := rows.Scan(&c.ID, &c.AccountID, &c.From, &c.To, &c.Reason, &c.Actor, &c.ChangedAt); err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+
+	return changes, rows.Err()
+}
+
+func insertStatusChange(tx *sql.Tx, accountID int, from string, to string, reason string, actor string) error {
+	_, err := tx.Exec("INSERT INTO account_status_history (account_id, from_status, to_status, reason, actor, changed_at) VALUES (?, ?, ?, ?, ?, NOW())",
+		accountID, from, to, reason, actor)
+	return err
+}
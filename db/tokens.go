@@ -0,0 +1,93 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Represents a row in the quarantined_heartbeats table - a heartbeat that failed authentication
+type QuarantinedHeartbeat struct {
+	ID         int64   `json:"id"`
+	Email      string  `json:"email"`
+	RemoteAddr string  `json:"remote_addr"`
+	Reason     string  `json:"reason"`
+	Payload    *string `json:"payload,omitempty"`
+	ReceivedAt string  `json:"received_at"`
+}
+
+// InsertHeartbeatToken stores the hash of a heartbeat token issued to the client of an activity
+func (d *Database) InsertHeartbeatToken(activityID int, accountID int, tokenHash string) error {
+	db := d.Driver
+
+	_, err := db.Exec("INSERT INTO heartbeat_tokens (activity_id, account_id, token_hash) VALUES (?, ?, ?)", activityID, accountID, tokenHash)
+	return err
+}
+
+// GetAccountForHeartbeatToken returns the account and activity id a heartbeat token hash was issued for.
+// tokens are only valid while their activity is running, sql.ErrNoRows is returned otherwise
+func (d *Database) GetAccountForHeartbeatToken(tokenHash string) (Account, int, error) {
+	db := d.Driver
+	var account Account
+	var activityID int
+
+	row := db.QueryRow(`
+		SELECT `+accountColumns+`, ac.id
+		FROM heartbeat_tokens t
+		INNER JOIN activity ac ON t.activity_id = ac.id
+		INNER JOIN accounts a ON t.account_id = a.id
+		WHERE t.token_hash = ? AND a.deleted_at IS NULL AND (ac.stopped_at IS NULL OR ac.stopped_at <= ac.started_at)`, tokenHash)
+	err := scanAccount(row, &account, &activityID)
+	if err != nil {
+		return account, 0, err
+	}
+
+	return account, activityID, nil
+}
+
+// InsertQuarantinedHeartbeat stores a heartbeat that could not be authenticated for later review
+func (d *Database) InsertQuarantinedHeartbeat(email string, remoteAddr string, reason string, payload []byte) error {
+	db := d.Driver
+
+	_, err := db.Exec("INSERT INTO quarantined_heartbeats (email, remote_addr, reason, payload, received_at) VALUES (?, ?, ?, ?, NOW())",
+		email, remoteAddr, reason, nullJSON(payload))
+	return err
+}
+
+// DeleteQuarantinedHeartbeatsBefore deletes the quarantined heartbeats received before t and returns how
+// many were deleted
+func (d *Database) DeleteQuarantinedHeartbeatsBefore(t time.Time) (int64, error) {
+	db := d.Driver
+
+	res, err := db.Exec("DELETE FROM quarantined_heartbeats WHERE received_at < ?", t.Format(TimeFormat))
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// GetQuarantinedHeartbeats returns the most recent quarantined heartbeats, newest first
+func (d *Database) GetQuarantinedHeartbeats(limit int) ([]QuarantinedHeartbeat, error) {
+	db := d.Driver
+
+	rows, err := db.Query("SELECT id, email, remote_addr, reason, payload, received_at FROM quarantined_heartbeats ORDER BY id DESC LIMIT ?", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	heartbeats := []QuarantinedHeartbeat{}
+	for rows.Next() {
+		var hb QuarantinedHeartbeat
+		var payload sql.NullString
+		if err := rows.Scan(&hb.ID, &hb.Email, &hb.RemoteAddr, &hb.Reason, &payload, &hb.ReceivedAt); err != nil {
+			return nil, err
+		}
+		if payload.Valid {
+			hb.Payload = &payload.String
+		}
+		heartbeats = append(heartbeats, hb)
+	}
+
+	return heartbeats, rows.Err()
+}
@@ -0,0 +1,391 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// statuses of a webhook delivery
+const (
+	DeliveryPending    = "pending"    // waiting for its next attempt
+	DeliveryDelivered  = "delivered"  // the endpoint answered with a 2xx status
+	DeliveryDead       = "dead"       // every attempt failed, kept as a dead letter until it is retried
+	DeliverySuppressed = "suppressed" // not sent because the webhook's rate limit was reached
+)
+
+// Represents a row in the webhooks table - an url that is sent the fleet events it subscribed to
+type Webhook struct {
+	ID          int      `json:"id"`
+	URL         string   `json:"url"`
+	Description string   `json:"description"`
+	EventTypes  []string `json:"event_types"` // empty for every type
+	Active      bool     `json:"active"`
+	CreatedAt   string   `json:"created_at"`
+
+	// how payloads are rendered: "json" for the event itself, "discord" or "slack" for a chat message
+	Format string `json:"format"`
+
+	// routing rules, only events of these accounts or of members of these groups are sent. both empty for
+	// every account
+	AccountIDs []int    `json:"account_ids"`
+	Groups     []string `json:"groups"`
+
+	// maximum deliveries per minute, 0 for no limit
+	RateLimit int `json:"rate_limit"`
+
+	// key of the HMAC signature of the payloads, only returned when the webhook is created
+	Secret string `json:"-"`
+}
+
+// Represents a row in the webhook_deliveries table - one event to be sent to one webhook, with the
+// outcome of its last attempt
+type WebhookDelivery struct {
+	ID             int64           `json:"id"`
+	WebhookID      int             `json:"webhook_id"`
+	EventID        int64           `json:"event_id"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"` // the exact body that is signed and sent
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  *string         `json:"next_attempt_at,omitempty"`
+	LastAttemptAt  *string         `json:"last_attempt_at,omitempty"`
+	ResponseStatus *int            `json:"response_status,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+	CreatedAt      string          `json:"created_at"`
+	DeliveredAt    *string         `json:"delivered_at,omitempty"`
+}
+
+// WebhookDeliveryFilter selects the deliveries returned by ListWebhookDeliveries, zero values match everything
+type WebhookDeliveryFilter struct {
+	WebhookID int
+	Status    string
+	EventType string
+}
+
+const webhookColumns = "id, url, description, event_types, secret, active, created_at, format, account_ids, account_groups, rate_limit"
+
+func scanWebhook(row interface{ Scan(...interface{}) error }, w *Webhook) error {
+	var eventTypes, accountIDs, groups sql.NullString
+	if err := row.Scan(&w.ID, &w.URL, &w.Description, &eventTypes, &w.Secret, &w.Active, &w.CreatedAt,
+		&w.Format, &accountIDs, &groups, &w.RateLimit); err != nil {
+		return err
+	}
+
+	w.EventTypes, w.AccountIDs, w.Groups = []string{}, []int{}, []string{}
+	for _, col := range []struct {
+		value sql.NullString
+		dest  interface{}
+	}{{eventTypes, &w.EventTypes}, {accountIDs, &w.AccountIDs}, {groups, &w.Groups}} {
+		if col.value.Valid {
+			if err := json.Unmarshal([]byte(col.value.String), col.dest); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// the json columns of a webhook
+func webhookLists(w Webhook) (eventTypes string, accountIDs string, groups string, err error) {
+	var buf []byte
+	if buf, err = json.Marshal(w.EventTypes); err != nil {
+		return
+	}
+	eventTypes = string(buf)
+	if buf, err = json.Marshal(w.AccountIDs); err != nil {
+		return
+	}
+	accountIDs = string(buf)
+	if buf, err = json.Marshal(w.Groups); err != nil {
+		return
+	}
+	groups = string(buf)
+
+	return
+}
+
+// InsertWebhook stores a new webhook and returns its id
+func (d *Database) InsertWebhook(w Webhook) (int, error) {
+	eventTypes, accountIDs, groups, err := webhookLists(w)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := d.Driver.Exec(`INSERT INTO webhooks (url, description, event_types, secret, active, format, account_ids, account_groups, rate_limit)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		w.URL, w.Description, eventTypes, w.Secret, w.Active, w.Format, accountIDs, groups, w.RateLimit)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+// GetWebhooks returns every webhook, oldest first
+func (d *Database) GetWebhooks() ([]Webhook, error) {
+	rows, err := d.Driver.Query("SELECT " + webhookColumns + " FROM webhooks ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []Webhook{}
+	for rows.Next() {
+		var w Webhook
+		if err := scanWebhook(rows, &w); err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, w)
+	}
+
+	return webhooks, rows.Err()
+}
+
+// GetWebhook returns a webhook by id, sql.ErrNoRows if it doesn't exist
+func (d *Database) GetWebhook(id int) (Webhook, error) {
+	var w Webhook
+	err := scanWebhook(d.Driver.QueryRow("SELECT "+webhookColumns+" FROM webhooks WHERE id = ?", id), &w)
+	return w, err
+}
+
+// UpdateWebhook saves every field of a webhook but its id and creation time
+func (d *Database) UpdateWebhook(w Webhook) error {
+	eventTypes, accountIDs, groups, err := webhookLists(w)
+	if err != nil {
+		return err
+	}
+
+	_, err = d.Driver.Exec(`UPDATE webhooks SET url = ?, description = ?, event_types = ?, secret = ?, active = ?, format = ?,
+		account_ids = ?, account_groups = ?, rate_limit = ? WHERE id = ?`,
+		w.URL, w.Description, eventTypes, w.Secret, w.Active, w.Format, accountIDs, groups, w.RateLimit, w.ID)
+	return err
+}
+
+// DeleteWebhook deletes a webhook and its deliveries
+func (d *Database) DeleteWebhook(id int) error {
+	tx, err := d.Driver.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM webhook_deliveries WHERE webhook_id = ?", id); err != nil {
+		return err
+	}
+
+	res, err := tx.Exec("DELETE FROM webhooks WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return tx.Commit()
+}
+
+const webhookDeliveryColumns = "id, webhook_id, event_id, event_type, payload, status, attempts, next_attempt_at, last_attempt_at, response_status, last_error, created_at, delivered_at"
+
+func scanWebhookDelivery(row interface{ Scan(...interface{}) error }, wd *WebhookDelivery) error {
+	var payload string
+	var responseStatus sql.NullInt64
+	var nextAttempt, lastAttempt, deliveredAt sql.NullString
+	if err := row.Scan(&wd.ID, &wd.WebhookID, &wd.EventID, &wd.EventType, &payload, &wd.Status, &wd.Attempts,
+		&nextAttempt, &lastAttempt, &responseStatus, &wd.LastError, &wd.CreatedAt, &deliveredAt); err != nil {
+		return err
+	}
+
+	wd.Payload = json.RawMessage(payload)
+	wd.NextAttemptAt = nullString(nextAttempt)
+	wd.LastAttemptAt = nullString(lastAttempt)
+	wd.DeliveredAt = nullString(deliveredAt)
+	if responseStatus.Valid {
+		status := int(responseStatus.Int64)
+		wd.ResponseStatus = &status
+	}
+
+	return nil
+}
+
+func nullString(s sql.NullString) *string {
+	if !s.Valid {
+		return nil
+	}
+
+	return &s.String
+}
+
+// InsertWebhookDelivery stores a delivery and returns its id. pending deliveries are due right away,
+// suppressed ones are only logged
+func (d *Database) InsertWebhookDelivery(webhookID int, eventID int64, eventType string, payload []byte, status string, now time.Time) (int64, error) {
+	var nextAttempt interface{}
+	if status == DeliveryPending {
+		nextAttempt = now.Format(TimeFormat)
+	}
+
+	res, err := d.Driver.Exec(`INSERT INTO webhook_deliveries (webhook_id, event_id, event_type, payload, status, next_attempt_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`, webhookID, eventID, eventType, string(payload), status, nextAttempt, now.Format(TimeFormat))
+	if err != nil {
+		return 0, err
+	}
+
+	return res.LastInsertId()
+}
+
+// GetDueWebhookDeliveries returns the pending deliveries of active webhooks whose next attempt is due,
+// oldest first
+func (d *Database) GetDueWebhookDeliveries(now time.Time, limit int) ([]WebhookDelivery, error) {
+	rows, err := d.Driver.Query(`
+		SELECT d.`+strings.ReplaceAll(webhookDeliveryColumns, ", ", ", d.")+`
+		FROM webhook_deliveries d
+		INNER JOIN webhooks w ON d.webhook_id = w.id AND w.active = 1
+		WHERE d.status = ? AND d.next_attempt_at <= ?
+		ORDER BY d.id
+		LIMIT ?`, DeliveryPending, now.Format(TimeFormat), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		var wd WebhookDelivery
+		if err := scanWebhookDelivery(rows, &wd); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, wd)
+	}
+
+	return deliveries, rows.Err()
+}
+
+// DeleteWebhookDeliveriesBefore deletes the delivered and suppressed deliveries created before t, pending
+// deliveries and dead letters are kept. returns the number of deliveries deleted
+func (d *Database) DeleteWebhookDeliveriesBefore(t time.Time) (int64, error) {
+	db := d.Driver
+
+	res, err := db.Exec("DELETE FROM webhook_deliveries WHERE status IN (?, ?) AND created_at < ?", DeliveryDelivered, DeliverySuppressed, t.Format(TimeFormat))
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// UpdateWebhookDelivery records the outcome of an attempt: the status, attempts, next attempt, response
+// status, error and delivery time of wd
+func (d *Database) UpdateWebhookDelivery(wd WebhookDelivery) error {
+	var responseStatus interface{}
+	if wd.ResponseStatus != nil {
+		responseStatus = *wd.ResponseStatus
+	}
+
+	_, err := d.Driver.Exec(`UPDATE webhook_deliveries SET status = ?, attempts = ?, next_attempt_at = ?, last_attempt_at = ?,
+		response_status = ?, last_error = ?, delivered_at = ? WHERE id = ?`,
+		wd.Status, wd.Attempts, wd.NextAttemptAt, wd.LastAttemptAt, responseStatus, truncate(wd.LastError, 1024), wd.DeliveredAt, wd.ID)
+	return err
+}
+
+// RetryWebhookDelivery puts a dead delivery back in the queue with a fresh set of attempts.
+// sql.ErrNoRows is returned if there is no dead delivery with the id
+func (d *Database) RetryWebhookDelivery(id int64, now time.Time) error {
+	res, err := d.Driver.Exec("UPDATE webhook_deliveries SET status = ?, attempts = 0, next_attempt_at = ? WHERE id = ? AND status = ?",
+		DeliveryPending, now.Format(TimeFormat), id, DeliveryDead)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// GetWebhookDelivery returns a delivery by id, sql.ErrNoRows if it doesn't exist
+func (d *Database) GetWebhookDelivery(id int64) (WebhookDelivery, error) {
+	var wd WebhookDelivery
+	err := scanWebhookDelivery(d.Driver.QueryRow("SELECT "+webhookDeliveryColumns+" FROM webhook_deliveries WHERE id = ?", id), &wd)
+	return wd, err
+}
+
+// sortable fields of ListWebhookDeliveries
+var webhookDeliverySorts = map[string]string{
+	"id":         "id",
+	"created_at": "created_at",
+}
+
+// ListWebhookDeliveries returns a page of the deliveries matching the filter and the cursor of the next page
+func (d *Database) ListWebhookDeliveries(f WebhookDeliveryFilter, page PageRequest) ([]WebhookDelivery, string, error) {
+	pq, err := newPageQuery(page, webhookDeliverySorts, "id", "id")
+	if err != nil {
+		return nil, "", err
+	}
+
+	where := []string{}
+	args := []interface{}{}
+	if f.WebhookID != 0 {
+		where = append(where, "webhook_id = ?")
+		args = append(args, f.WebhookID)
+	}
+	if f.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, f.Status)
+	}
+	if f.EventType != "" {
+		where = append(where, "event_type = ?")
+		args = append(args, f.EventType)
+	}
+
+	if cond, condArgs := pq.where(); cond != "" {
+		where = append(where, cond)
+		args = append(args, condArgs...)
+	}
+
+	q := "SELECT " + webhookDeliveryColumns + " FROM webhook_deliveries"
+	if len(where) > 0 {
+		q += " WHERE " + strings.Join(where, " AND ")
+	}
+	order, orderArgs := pq.orderLimit()
+	q += order
+	args = append(args, orderArgs...)
+
+	rows, err := d.Driver.Query(q, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		var wd WebhookDelivery
+		if err := scanWebhookDelivery(rows, &wd); err != nil {
+			return nil, "", err
+		}
+		deliveries = append(deliveries, wd)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	n, next := pq.next(len(deliveries), func(i int) (string, int64) {
+		values := map[string]string{"id": fmt.Sprint(deliveries[i].ID), "created_at": deliveries[i].CreatedAt}
+		return values[pq.sort], deliveries[i].ID
+	})
+
+	return deliveries[:n], next, nil
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+
+	return s[:n]
+}
@@ -0,0 +1,125 @@
+// Package logging sets up the structured logger shared by the server, db, bot and api packages: slog's
+// default logger, writing text or JSON records at a minimum level.
+//
+// records logged with a context carry the request id stored in it by WithRequestID, and attributes whose
+// key names a secret (passwords, tokens, ...) are redacted wherever they are logged.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// formats of the log records
+const (
+	FormatText = "text" // key=value pairs, for people
+	FormatJSON = "json" // one object per line, for log collectors
+)
+
+// Redacted replaces the value of sensitive attributes
+const Redacted = "[REDACTED]"
+
+// parts of attribute keys whose values are never logged, matched case insensitively
+var sensitiveKeys = []string{"password", "passwd", "token", "secret", "authorization", "api_key", "apikey", "credential", "cookie"}
+
+// Setup makes a logger with the given format and minimum level ("debug", "info", "warn" or "error") the
+// default logger, writing to stdout
+func Setup(format string, level string) error {
+	logger, err := New(os.Stdout, format, level)
+	if err != nil {
+		return err
+	}
+
+	slog.SetDefault(logger)
+	return nil
+}
+
+// New returns a logger writing records of at least the given level to w
+func New(w io.Writer, format string, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q, must be one of debug, info, warn, error", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl, ReplaceAttr: redact}
+
+	var h slog.Handler
+	switch format {
+	case FormatText:
+		h = slog.NewTextHandler(w, opts)
+	case FormatJSON:
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("invalid log format %q, must be %s or %s", format, FormatText, FormatJSON)
+	}
+
+	return slog.New(contextHandler{h}), nil
+}
+
+// redact replaces the values of sensitive attributes, including those nested in groups
+func redact(groups []string, a slog.Attr) slog.Attr {
+	if a.Value.Kind() == slog.KindGroup {
+		return a
+	}
+	if Sensitive(a.Key) {
+		return slog.String(a.Key, Redacted)
+	}
+
+	return a
+}
+
+// Sensitive reports whether values of the named attribute, field or parameter are secrets
+func Sensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range sensitiveKeys {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns a context whose log records carry the request id
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request id of a context, or an empty string
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// contextHandler adds the request id of the context to every record
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// Fatal logs an error and exits, for the errors the process can't go on after
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
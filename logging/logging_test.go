@@ -0,0 +1,50 @@
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestRedactsAndAddsRequestID(t *testing.T) {
+	var sb strings.Builder
+	logger, err := New(&sb, FormatJSON, "info")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := WithRequestID(context.Background(), "3f2a9c0d51e7b864")
+	logger.InfoContext(ctx, "bot started", "account_id", 12, "password", "hunter2",
+		slog.Group("bot", "heartbeat_token", "abc", "script", "miner"))
+	logger.Debug("below the level")
+
+	var record map[string]interface{}
+	if err := json.Unmarshal([]byte(sb.String()), &record); err != nil {
+		t.Fatalf("expected one json record, got %q: %v", sb.String(), err)
+	}
+
+	if record["request_id"] != "3f2a9c0d51e7b864" {
+		t.Errorf("request_id = %v", record["request_id"])
+	}
+	if record["password"] != Redacted {
+		t.Errorf("password = %v", record["password"])
+	}
+	bot := record["bot"].(map[string]interface{})
+	if bot["heartbeat_token"] != Redacted || bot["script"] != "miner" {
+		t.Errorf("bot = %v", bot)
+	}
+	if record["account_id"] != float64(12) {
+		t.Errorf("account_id = %v", record["account_id"])
+	}
+}
+
+func TestNewRejectsUnknownSettings(t *testing.T) {
+	if _, err := New(&strings.Builder{}, "xml", "info"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+	if _, err := New(&strings.Builder{}, FormatText, "loud"); err == nil {
+		t.Error("expected an error for an unknown level")
+	}
+}
@@ -1,11 +1,22 @@
 package main
 
 import (
+	"log/slog"
+
 	"bot-api/api"
+	"bot-api/logging"
 	s "bot-api/server"
 )
 
 func main() {
-	server := s.Server{}
+	config, err := s.LoadConfig()
+	if err := logging.Setup(config.LogFormat, config.LogLevel); err != nil {
+		slog.Warn("invalid logging config, using the default logger", "error", err)
+	}
+	if err != nil {
+		logging.Fatal("invalid config", "error", err)
+	}
+
+	server := s.Server{Config: config}
 	api.Start(&server)
 }
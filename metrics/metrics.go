@@ -0,0 +1,335 @@
+// Package metrics keeps counters, gauges and histograms in memory and writes them in the Prometheus text
+// exposition format, so the api can be scraped without pulling in a client library.
+//
+// metrics are registered once on a Registry, usually at startup, and updated by label values. the label
+// names are fixed when a metric is registered, passing a different number of values is a programming error
+// and panics.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the content type of the text exposition format written by Registry.Write
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are histogram buckets in seconds suited to request and query durations
+var DefaultBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// metric types of the exposition format
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+)
+
+// Registry holds the registered metrics
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]family
+}
+
+// family is a registered metric with all of its series
+type family interface {
+	write(w *bufio.Writer)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]family)}
+}
+
+func (r *Registry) register(name string, f family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.families[name]; ok {
+		panic("metrics: " + name + " is registered twice")
+	}
+	r.families[name] = f
+}
+
+// Write writes every metric in the text exposition format, sorted by name
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	families := r.families
+	r.mu.Unlock()
+	sort.Strings(names)
+
+	bw := bufio.NewWriter(w)
+	for _, name := range names {
+		families[name].write(bw)
+	}
+
+	return bw.Flush()
+}
+
+// header describes a metric, shared by every kind of family
+type header struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+}
+
+func (h header) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", h.name, escapeHelp(h.help), h.name, h.typ)
+}
+
+// key identifies a series by its label values
+func (h header) key(values []string) string {
+	if len(values) != len(h.labels) {
+		panic(fmt.Sprintf("metrics: %s has labels %v, got %d values", h.name, h.labels, len(values)))
+	}
+
+	return strings.Join(values, "\xff")
+}
+
+// series is the label values and value of one series of a counter or gauge
+type series struct {
+	values []string
+	value  float64
+}
+
+// CounterVec is a counter per combination of label values
+type CounterVec struct {
+	header
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// Counter registers a counter. the name should end in _total
+func (r *Registry) Counter(name string, help string, labels ...string) *CounterVec {
+	c := &CounterVec{header: header{name, help, typeCounter, labels}, series: make(map[string]*series)}
+	r.register(name, c)
+
+	return c
+}
+
+// Add adds v, which must not be negative, to the counter of the label values
+func (c *CounterVec) Add(v float64, values ...string) {
+	if v < 0 {
+		panic("metrics: counter " + c.name + " can't decrease")
+	}
+	add(&c.mu, c.series, c.key(values), values, v, false)
+}
+
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.writeHeader(w)
+	writeSeries(w, &c.mu, c.name, c.labels, c.series)
+}
+
+// GaugeVec is a value per combination of label values that can go up and down
+type GaugeVec struct {
+	header
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+func (r *Registry) Gauge(name string, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{header: header{name, help, typeGauge, labels}, series: make(map[string]*series)}
+	r.register(name, g)
+
+	return g
+}
+
+func (g *GaugeVec) Set(v float64, values ...string) {
+	add(&g.mu, g.series, g.key(values), values, v, true)
+}
+
+func (g *GaugeVec) Add(v float64, values ...string) {
+	add(&g.mu, g.series, g.key(values), values, v, false)
+}
+
+func (g *GaugeVec) write(w *bufio.Writer) {
+	g.writeHeader(w)
+	writeSeries(w, &g.mu, g.name, g.labels, g.series)
+}
+
+func add(mu *sync.Mutex, m map[string]*series, key string, values []string, v float64, set bool) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	s, ok := m[key]
+	if !ok {
+		s = &series{values: append([]string(nil), values...)}
+		m[key] = s
+	}
+	if set {
+		s.value = v
+	} else {
+		s.value += v
+	}
+}
+
+func writeSeries(w *bufio.Writer, mu *sync.Mutex, name string, labels []string, m map[string]*series) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, key := range sortedKeys(m) {
+		s := m[key]
+		fmt.Fprintf(w, "%s%s %s\n", name, labelSet(labels, s.values), formatFloat(s.value))
+	}
+}
+
+// HistogramVec counts observations in buckets per combination of label values
+type HistogramVec struct {
+	header
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogram
+}
+
+type histogram struct {
+	values []string
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// Histogram registers a histogram with the given upper bounds, in increasing order. the +Inf bucket is
+// added when writing
+func (r *Registry) Histogram(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	if !sort.Float64sAreSorted(buckets) {
+		panic("metrics: buckets of " + name + " are not sorted")
+	}
+	h := &HistogramVec{header: header{name, help, typeHistogram, labels}, buckets: buckets, series: make(map[string]*histogram)}
+	r.register(name, h)
+
+	return h
+}
+
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	key := h.key(values)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogram{values: append([]string(nil), values...), counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += v
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.writeHeader(w)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	bucketLabels := append(append([]string(nil), h.labels...), "le")
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+
+		le := func(bound string) string {
+			return labelSet(bucketLabels, append(append([]string(nil), s.values...), bound))
+		}
+
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, le(formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, le("+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labelSet(h.labels, s.values), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labelSet(h.labels, s.values), s.count)
+	}
+}
+
+// Emit reports the value of a series of a collected metric
+type Emit func(v float64, values ...string)
+
+// collected is a metric whose series are read at scrape time, e.g. from the state of another component
+type collected struct {
+	header
+	collect func(emit Emit)
+}
+
+// CollectCounter registers a counter read by collect on every scrape, for totals kept elsewhere
+func (r *Registry) CollectCounter(name string, help string, collect func(emit Emit), labels ...string) {
+	r.register(name, &collected{header{name, help, typeCounter, labels}, collect})
+}
+
+// CollectGauge registers a gauge read by collect on every scrape
+func (r *Registry) CollectGauge(name string, help string, collect func(emit Emit), labels ...string) {
+	r.register(name, &collected{header{name, help, typeGauge, labels}, collect})
+}
+
+func (c *collected) write(w *bufio.Writer) {
+	c.writeHeader(w)
+	c.collect(func(v float64, values ...string) {
+		c.key(values)
+		fmt.Fprintf(w, "%s%s %s\n", c.name, labelSet(c.labels, values), formatFloat(v))
+	})
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// labelSet formats label names and values as {name="value",...}, or nothing without labels
+func labelSet(names []string, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(name)
+		sb.WriteString(`="`)
+		sb.WriteString(labelEscaper.Replace(values[i]))
+		sb.WriteByte('"')
+	}
+	sb.WriteByte('}')
+
+	return sb.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	r := NewRegistry()
+
+	requests := r.Counter("requests_total", "Requests served.", "route", "status")
+	requests.Inc("/accounts/:id", "200")
+	requests.Add(2, "/accounts/:id", "200")
+	requests.Inc(`/say "hi"`, "404")
+
+	bots := r.Gauge("bots", "Bots by state.", "state")
+	bots.Set(3, "running")
+	bots.Set(1, "running")
+
+	latency := r.Histogram("latency_seconds", "Latency.\nIn seconds.", []float64{0.1, 1})
+	latency.Observe(0.05)
+	latency.Observe(0.1)
+	latency.Observe(5)
+
+	r.CollectGauge("queued", "Queued events.", func(emit Emit) {
+		emit(7, "storage")
+	}, "subscriber")
+
+	var sb strings.Builder
+	if err := r.Write(&sb); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# HELP bots Bots by state.
+# TYPE bots gauge
+bots{state="running"} 1
+# HELP latency_seconds Latency.\nIn seconds.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 2
+latency_seconds_bucket{le="1"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 5.15
+latency_seconds_count 3
+# HELP queued Queued events.
+# TYPE queued gauge
+queued{subscriber="storage"} 7
+# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total{route="/accounts/:id",status="200"} 3
+requests_total{route="/say \"hi\"",status="404"} 1
+`
+	if got := sb.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestLabelCountMismatchPanics(t *testing.T) {
+	c := NewRegistry().Counter("launches_total", "Launches.", "script")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for a missing label value")
+		}
+	}()
+	c.Inc()
+}
@@ -0,0 +1,159 @@
+package prices
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrNoProvider = errors.New("no price provider configured")
+
+// Book keeps the current item prices in memory. prices are loaded from the store on startup and
+// replaced by fresh ones from the provider on Refresh
+type Book struct {
+	provider Provider
+	store    Store
+
+	// prices older than this are reported as stale
+	maxAge time.Duration
+
+	mu          sync.RWMutex
+	prices      map[int]Price
+	refreshedAt time.Time // last successful fetch from the provider
+	attemptedAt time.Time // last fetch from the provider, successful or not
+	updatedAt   time.Time // newest price in the book
+	lastErr     error
+}
+
+// Status describes the state of a price book
+type Status struct {
+	Source      string    `json:"source"`
+	Items       int       `json:"items"`
+	RefreshedAt time.Time `json:"refreshed_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Stale       bool      `json:"stale"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// NewBook creates a price book. provider and store may be nil, in which case prices can't be
+// refreshed or aren't persisted respectively
+func NewBook(provider Provider, store Store, maxAge time.Duration) *Book {
+	return &Book{
+		provider: provider,
+		store:    store,
+		maxAge:   maxAge,
+		prices:   make(map[int]Price),
+	}
+}
+
+// Load fills the book with the prices cached in the store
+func (b *Book) Load() error {
+	if b.store == nil {
+		return nil
+	}
+
+	prices, err := b.store.LoadPrices()
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, p := range prices {
+		b.prices[p.ItemID] = p
+		if p.UpdatedAt.After(b.updatedAt) {
+			b.updatedAt = p.UpdatedAt
+		}
+	}
+
+	return nil
+}
+
+// Refresh fetches all prices from the provider and saves them to the store. items missing from the
+// provider keep their previous (eventually stale) price
+func (b *Book) Refresh(ctx context.Context) error {
+	if b.provider == nil {
+		return ErrNoProvider
+	}
+
+	prices, err := b.provider.Fetch(ctx)
+	if err == nil && b.store != nil {
+		err = b.store.SavePrices(prices, b.provider.Source())
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.attemptedAt = time.Now()
+	b.lastErr = err
+	if err != nil {
+		return err
+	}
+
+	for _, p := range prices {
+		if p.Name == "" {
+			p.Name = b.prices[p.ItemID].Name
+		}
+		b.prices[p.ItemID] = p
+		if p.UpdatedAt.After(b.updatedAt) {
+			b.updatedAt = p.UpdatedAt
+		}
+	}
+	b.refreshedAt = time.Now()
+
+	return nil
+}
+
+// NeedsRefresh reports whether the book was last refreshed, or a refresh was last attempted, longer than
+// the given interval ago
+func (b *Book) NeedsRefresh(interval time.Duration) bool {
+	if b.provider == nil {
+		return false
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	last := b.refreshedAt
+	if b.attemptedAt.After(last) {
+		last = b.attemptedAt
+	}
+
+	return time.Since(last) >= interval
+}
+
+// Lookup returns the price of an item
+func (b *Book) Lookup(itemID int) (Price, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	p, ok := b.prices[itemID]
+	return p, ok
+}
+
+// IsStale reports whether a price is older than the max age of the book
+func (b *Book) IsStale(p Price) bool {
+	return b.maxAge > 0 && time.Since(p.UpdatedAt) > b.maxAge
+}
+
+func (b *Book) Status() Status {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	st := Status{
+		Items:       len(b.prices),
+		RefreshedAt: b.refreshedAt,
+		UpdatedAt:   b.updatedAt,
+		Stale:       b.maxAge > 0 && time.Since(b.updatedAt) > b.maxAge,
+	}
+	if b.provider != nil {
+		st.Source = b.provider.Source()
+	}
+	if b.lastErr != nil {
+		st.LastError = b.lastErr.Error()
+	}
+
+	return st
+}
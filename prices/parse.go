@@ -0,0 +1,154 @@
+package prices
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Parse reads a price dump in the given format ("json" or "csv").
+//
+// JSON dumps may be an array of {"id", "name", "price"} objects, an object mapping item ids to prices,
+// or an object mapping item ids to {"price"} or {"high", "low"} objects, optionally wrapped in a "data"
+// object like the responses of the OSRS wiki prices api. CSV dumps need a header row with "id" and
+// "price" columns and an optional "name" column.
+func Parse(r io.Reader, format string) ([]Price, error) {
+	switch strings.ToLower(format) {
+	case "json":
+		return parseJSON(r)
+	case "csv":
+		return parseCSV(r)
+	default:
+		return nil, fmt.Errorf("unsupported price format: %s", format)
+	}
+}
+
+type jsonPrice struct {
+	ID    *int   `json:"id"`
+	Name  string `json:"name"`
+	Price *int64 `json:"price"`
+	High  *int64 `json:"high"`
+	Low   *int64 `json:"low"`
+}
+
+// value returns the price of an entry, averaging high and low when no price is given
+func (p jsonPrice) value() (int64, bool) {
+	switch {
+	case p.Price != nil:
+		return *p.Price, true
+	case p.High != nil && p.Low != nil:
+		return (*p.High + *p.Low) / 2, true
+	case p.High != nil:
+		return *p.High, true
+	case p.Low != nil:
+		return *p.Low, true
+	}
+
+	return 0, false
+}
+
+func parseJSON(r io.Reader) ([]Price, error) {
+	var raw json.RawMessage
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	var list []jsonPrice
+	if err := json.Unmarshal(raw, &list); err == nil {
+		prices := make([]Price, 0, len(list))
+		for i, p := range list {
+			v, ok := p.value()
+			if p.ID == nil || !ok {
+				return nil, fmt.Errorf("entry %d: id and price are required", i)
+			}
+			prices = append(prices, Price{ItemID: *p.ID, Name: p.Name, Price: v})
+		}
+		return prices, nil
+	}
+
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return nil, fmt.Errorf("price dump must be a JSON array or object")
+	}
+	if data, ok := obj["data"]; ok {
+		obj = nil
+		if err := json.Unmarshal(data, &obj); err != nil {
+			return nil, fmt.Errorf("data: %w", err)
+		}
+	}
+
+	prices := make([]Price, 0, len(obj))
+	for key, value := range obj {
+		id, err := strconv.Atoi(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid item id: %s", key)
+		}
+
+		var price int64
+		if err := json.Unmarshal(value, &price); err == nil {
+			prices = append(prices, Price{ItemID: id, Price: price})
+			continue
+		}
+
+		var p jsonPrice
+		if err := json.Unmarshal(value, &p); err != nil {
+			return nil, fmt.Errorf("item %d: %w", id, err)
+		}
+		v, ok := p.value()
+		if !ok {
+			// the wiki api omits both values for items that haven't traded recently
+			continue
+		}
+		prices = append(prices, Price{ItemID: id, Name: p.Name, Price: v})
+	}
+
+	return prices, nil
+}
+
+func parseCSV(r io.Reader) ([]Price, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("price dump is empty")
+	}
+
+	idCol, nameCol, priceCol := -1, -1, -1
+	for i, h := range records[0] {
+		switch strings.ToLower(strings.TrimSpace(h)) {
+		case "id", "item_id":
+			idCol = i
+		case "name":
+			nameCol = i
+		case "price":
+			priceCol = i
+		}
+	}
+	if idCol < 0 || priceCol < 0 {
+		return nil, fmt.Errorf("csv header must contain id and price columns")
+	}
+
+	prices := make([]Price, 0, len(records)-1)
+	for line, rec := range records[1:] {
+		id, err := strconv.Atoi(strings.TrimSpace(rec[idCol]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid id: %s", line+2, rec[idCol])
+		}
+		price, err := strconv.ParseInt(strings.TrimSpace(rec[priceCol]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid price: %s", line+2, rec[priceCol])
+		}
+
+		p := Price{ItemID: id, Price: price}
+		if nameCol >= 0 {
+			p.Name = strings.TrimSpace(rec[nameCol])
+		}
+		prices = append(prices, p)
+	}
+
+	return prices, nil
+}
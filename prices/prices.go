@@ -0,0 +1,32 @@
+// Package prices looks up item prices so item counts reported by bots can be turned into gp.
+//
+// prices come from a Provider (a local price dump or a price API), are cached in a Store and kept
+// in memory by a Book, which also implements the valuation helpers used by the api.
+package prices
+
+import (
+	"context"
+	"time"
+)
+
+// Price is the value of a single item in gp
+type Price struct {
+	ItemID    int       `json:"id"`
+	Name      string    `json:"name,omitempty"`
+	Price     int64     `json:"price"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Provider fetches a full set of item prices
+type Provider interface {
+	// Source describes where the prices come from, it is stored alongside cached prices
+	Source() string
+
+	Fetch(ctx context.Context) ([]Price, error)
+}
+
+// Store persists fetched prices so they survive restarts and outages of the provider
+type Store interface {
+	LoadPrices() ([]Price, error)
+	SavePrices(prices []Price, source string) error
+}
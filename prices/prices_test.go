@@ -0,0 +1,244 @@
+package prices
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"bot-api/db"
+)
+
+func writeDump(t *testing.T, name string, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func sortedPrices(prices []Price) []Price {
+	sort.Slice(prices, func(i, j int) bool { return prices[i].ItemID < prices[j].ItemID })
+	return prices
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		input  string
+		want   []Price
+	}{
+		{
+			name:   "json array",
+			format: "json",
+			input:  `[{"id": 1511, "name": "Logs", "price": 40}, {"id": 995, "price": 1}]`,
+			want:   []Price{{ItemID: 995, Price: 1}, {ItemID: 1511, Name: "Logs", Price: 40}},
+		},
+		{
+			name:   "json id map",
+			format: "json",
+			input:  `{"1511": 40, "995": 1}`,
+			want:   []Price{{ItemID: 995, Price: 1}, {ItemID: 1511, Price: 40}},
+		},
+		{
+			name:   "wiki latest",
+			format: "json",
+			input:  `{"data": {"1511": {"high": 42, "highTime": 1, "low": 38, "lowTime": 1}, "2": {"high": null, "low": null}}}`,
+			want:   []Price{{ItemID: 1511, Price: 40}},
+		},
+		{
+			name:   "csv",
+			format: "csv",
+			input:  "id,name,price\n1511,Logs,40\n995,Coins,1\n",
+			want:   []Price{{ItemID: 995, Name: "Coins", Price: 1}, {ItemID: 1511, Name: "Logs", Price: 40}},
+		},
+		{
+			name:   "csv without name",
+			format: "CSV",
+			input:  "price,item_id\n40,1511\n",
+			want:   []Price{{ItemID: 1511, Price: 40}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(strings.NewReader(tt.input), tt.format)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+
+			got = sortedPrices(got)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Parse() = %+v, want %+v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("Parse()[%d] = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		input  string
+	}{
+		{"unknown format", "xml", "<prices/>"},
+		{"json array missing price", "json", `[{"id": 1511}]`},
+		{"json bad id", "json", `{"logs": 40}`},
+		{"csv missing column", "csv", "id,name\n1511,Logs\n"},
+		{"csv bad price", "csv", "id,price\n1511,forty\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(strings.NewReader(tt.input), tt.format); err == nil {
+				t.Errorf("Parse() expected an error")
+			}
+		})
+	}
+}
+
+func TestFileProvider(t *testing.T) {
+	path := writeDump(t, "prices.csv", "id,name,price\n1511,Logs,40\n")
+	modTime := time.Now().Add(-2 * time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	p := &FileProvider{Path: path}
+	if p.Source() != "file:prices.csv" {
+		t.Errorf("Source() = %s", p.Source())
+	}
+
+	prices, err := p.Fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prices) != 1 || prices[0].Price != 40 || !prices[0].UpdatedAt.Equal(modTime) {
+		t.Errorf("Fetch() = %+v", prices)
+	}
+
+	if _, err := (&FileProvider{Path: path + ".missing"}).Fetch(context.Background()); err == nil {
+		t.Errorf("Fetch() of a missing file expected an error")
+	}
+}
+
+// memoryStore is a Store that keeps saved prices in memory
+type memoryStore struct {
+	prices []Price
+	source string
+}
+
+func (s *memoryStore) LoadPrices() ([]Price, error) {
+	return s.prices, nil
+}
+
+func (s *memoryStore) SavePrices(prices []Price, source string) error {
+	s.prices = prices
+	s.source = source
+	return nil
+}
+
+func TestBookRefreshAndStaleness(t *testing.T) {
+	path := writeDump(t, "prices.json", `[{"id": 1511, "name": "Logs", "price": 40}, {"id": 314, "name": "Feather", "price": 3}]`)
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	store := &memoryStore{}
+	book := NewBook(&FileProvider{Path: path}, store, 24*time.Hour)
+
+	if !book.NeedsRefresh(time.Hour) {
+		t.Errorf("NeedsRefresh() = false before the first refresh")
+	}
+	if err := book.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if book.NeedsRefresh(time.Hour) {
+		t.Errorf("NeedsRefresh() = true right after a refresh")
+	}
+
+	if len(store.prices) != 2 || store.source != "file:prices.json" {
+		t.Errorf("store = %+v", store)
+	}
+
+	p, ok := book.Lookup(1511)
+	if !ok || p.Price != 40 {
+		t.Fatalf("Lookup(1511) = %+v, %v", p, ok)
+	}
+	if !book.IsStale(p) {
+		t.Errorf("IsStale() = false for a price from a 48h old dump")
+	}
+	if st := book.Status(); !st.Stale || st.Items != 2 {
+		t.Errorf("Status() = %+v", st)
+	}
+
+	// a fresh dump replaces the stale prices
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := book.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if p, _ := book.Lookup(1511); book.IsStale(p) {
+		t.Errorf("IsStale() = true after refreshing from a fresh dump")
+	}
+
+	// a new book picks the prices up from the store
+	reloaded := NewBook(nil, store, 24*time.Hour)
+	if err := reloaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if p, ok := reloaded.Lookup(314); !ok || p.Name != "Feather" {
+		t.Errorf("Lookup(314) after Load() = %+v, %v", p, ok)
+	}
+	if err := reloaded.Refresh(context.Background()); err != ErrNoProvider {
+		t.Errorf("Refresh() without provider = %v, want ErrNoProvider", err)
+	}
+}
+
+func TestValuation(t *testing.T) {
+	path := writeDump(t, "prices.csv", "id,name,price\n1511,Logs,40\n314,Feather,3\n")
+	book := NewBook(&FileProvider{Path: path}, nil, 24*time.Hour)
+	if err := book.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	v := book.ValueItems([]db.InventoryItem{{ID: 1511, Quantity: 27}, {ID: 9999, Name: "Mystery", Quantity: 1}})
+	if v.Total != 1080 {
+		t.Errorf("Total = %d, want 1080", v.Total)
+	}
+	if len(v.Missing) != 1 || v.Missing[0] != 9999 {
+		t.Errorf("Missing = %v, want [9999]", v.Missing)
+	}
+	if v.Items[0].Name != "Logs" || v.Items[0].UnitPrice == nil || *v.Items[0].UnitPrice != 40 {
+		t.Errorf("Items[0] = %+v", v.Items[0])
+	}
+	if v.Items[1].UnitPrice != nil {
+		t.Errorf("Items[1].UnitPrice = %d, want nil", *v.Items[1].UnitPrice)
+	}
+
+	loot := book.ValueLoot([]db.ItemDelta{
+		{ItemID: 1511, Gained: 100},
+		{ItemID: 314, Gained: 0, Consumed: 500},
+	}, 1800)
+	if loot.Gained.Total != 4000 || loot.Consumed.Total != 1500 {
+		t.Errorf("Gained = %d, Consumed = %d", loot.Gained.Total, loot.Consumed.Total)
+	}
+	if loot.Net != 2500 || loot.NetPerHour != 5000 {
+		t.Errorf("Net = %d, NetPerHour = %f", loot.Net, loot.NetPerHour)
+	}
+}
@@ -0,0 +1,106 @@
+package prices
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FileProvider reads prices from a local JSON or CSV price dump. the modification time of the file is
+// used as the time the prices were last updated
+type FileProvider struct {
+	Path string
+
+	// "json" or "csv", detected from the file extension when empty
+	Format string
+}
+
+func (p *FileProvider) Source() string {
+	return "file:" + filepath.Base(p.Path)
+}
+
+func (p *FileProvider) Fetch(ctx context.Context) ([]Price, error) {
+	f, err := os.Open(p.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	format := p.Format
+	if format == "" {
+		format = strings.TrimPrefix(filepath.Ext(p.Path), ".")
+	}
+
+	prices, err := Parse(f, format)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", p.Path, err)
+	}
+
+	for i := range prices {
+		prices[i].UpdatedAt = info.ModTime()
+	}
+
+	return prices, nil
+}
+
+// HTTPProvider fetches prices in any of the JSON formats accepted by Parse from a price api
+type HTTPProvider struct {
+	URL string
+
+	// sent with every request, some price apis block requests without a descriptive user agent
+	UserAgent string
+
+	// defaults to a client with a 30 second timeout
+	Client *http.Client
+}
+
+func (p *HTTPProvider) Source() string {
+	return p.URL
+}
+
+func (p *HTTPProvider) Fetch(ctx context.Context) ([]Price, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if p.UserAgent != "" {
+		req.Header.Set("User-Agent", p.UserAgent)
+	}
+
+	client := p.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", p.URL, resp.Status)
+	}
+
+	prices, err := Parse(resp.Body, "json")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", p.URL, err)
+	}
+
+	now := time.Now()
+	for i := range prices {
+		prices[i].UpdatedAt = now
+	}
+
+	return prices, nil
+}
@@ -0,0 +1,44 @@
+package prices
+
+import (
+	"bot-api/db"
+	"time"
+)
+
+// DBStore caches prices in the item_prices table
+type DBStore struct {
+	DB *db.Database
+}
+
+func (s *DBStore) LoadPrices() ([]Price, error) {
+	rows, err := s.DB.GetItemPrices()
+	if err != nil {
+		return nil, err
+	}
+
+	prices := make([]Price, 0, len(rows))
+	for _, r := range rows {
+		updatedAt, err := time.ParseInLocation(db.TimeFormat, r.UpdatedAt, time.Local)
+		if err != nil {
+			return nil, err
+		}
+		prices = append(prices, Price{ItemID: r.ItemID, Name: r.Name, Price: r.Price, UpdatedAt: updatedAt})
+	}
+
+	return prices, nil
+}
+
+func (s *DBStore) SavePrices(prices []Price, source string) error {
+	rows := make([]db.ItemPrice, 0, len(prices))
+	for _, p := range prices {
+		rows = append(rows, db.ItemPrice{
+			ItemID:    p.ItemID,
+			Name:      p.Name,
+			Price:     p.Price,
+			Source:    source,
+			UpdatedAt: p.UpdatedAt.Local().Format(db.TimeFormat),
+		})
+	}
+
+	return s.DB.UpsertItemPrices(rows)
+}
@@ -0,0 +1,85 @@
+package prices
+
+import (
+	"bot-api/db"
+)
+
+// ValuedItem is an item stack together with its value
+type ValuedItem struct {
+	ItemID    int    `json:"id"`
+	Name      string `json:"name,omitempty"`
+	Quantity  int    `json:"quantity"`
+	UnitPrice *int64 `json:"unit_price"` // nil when the item has no known price
+	Value     int64  `json:"value"`
+	Stale     bool   `json:"stale,omitempty"`
+}
+
+// Valuation is the total value of a set of items. items without a known price count as 0 and are
+// listed in Missing
+type Valuation struct {
+	Items   []ValuedItem `json:"items"`
+	Total   int64        `json:"total"`
+	Missing []int        `json:"missing,omitempty"`
+	Stale   bool         `json:"stale"` // true if any price used is stale
+}
+
+// LootValuation is the value of the items gained and consumed during one or more activity sessions
+type LootValuation struct {
+	Gained   Valuation `json:"gained"`
+	Consumed Valuation `json:"consumed"`
+
+	// value of the items gained minus the items consumed. gp_delta is reported separately by the bots
+	Net        int64   `json:"net"`
+	NetPerHour float64 `json:"net_per_hour"`
+}
+
+// ValueItems values a list of item stacks, such as an inventory or bank
+func (b *Book) ValueItems(items []db.InventoryItem) Valuation {
+	v := Valuation{Items: make([]ValuedItem, 0, len(items))}
+
+	for _, item := range items {
+		valued := ValuedItem{ItemID: item.ID, Name: item.Name, Quantity: item.Quantity}
+
+		p, ok := b.Lookup(item.ID)
+		if ok {
+			price := p.Price
+			valued.UnitPrice = &price
+			valued.Value = price * int64(item.Quantity)
+			valued.Stale = b.IsStale(p)
+			if valued.Name == "" {
+				valued.Name = p.Name
+			}
+		} else {
+			v.Missing = append(v.Missing, item.ID)
+		}
+
+		v.Total += valued.Value
+		v.Stale = v.Stale || valued.Stale
+		v.Items = append(v.Items, valued)
+	}
+
+	return v
+}
+
+// ValueLoot values the items gained and consumed over the given number of seconds
+func (b *Book) ValueLoot(items []db.ItemDelta, seconds int64) LootValuation {
+	gained := []db.InventoryItem{}
+	consumed := []db.InventoryItem{}
+	for _, item := range items {
+		if item.Gained > 0 {
+			gained = append(gained, db.InventoryItem{ID: item.ItemID, Name: item.Name, Quantity: item.Gained})
+		}
+		if item.Consumed > 0 {
+			consumed = append(consumed, db.InventoryItem{ID: item.ItemID, Name: item.Name, Quantity: item.Consumed})
+		}
+	}
+
+	lv := LootValuation{
+		Gained:   b.ValueItems(gained),
+		Consumed: b.ValueItems(consumed),
+	}
+	lv.Net = lv.Gained.Total - lv.Consumed.Total
+	lv.NetPerHour = db.PerHour(lv.Net, seconds)
+
+	return lv
+}
@@ -0,0 +1,461 @@
+package server
+
+import (
+	db "bot-api/db"
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/mail"
+	"regexp"
+	"strings"
+)
+
+// status given to new accounts imported without one, existing accounts keep theirs
+const defaultAccountStatus = StatusNew
+
+// osrs display names are 1-12 letters, digits, spaces, hyphens and underscores
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9 _-]{1,12}$`)
+
+// AccountRecord is an account in the bulk import and export formats
+type AccountRecord struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Status   string `json:"status,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// ImportRowResult is the outcome of importing a single record
+type ImportRowResult struct {
+	Row      int          `json:"row"` // 1 based position of the record in the import
+	Email    string       `json:"email"`
+	Username string       `json:"username"`
+	ID       int          `json:"id,omitempty"`
+	Action   string       `json:"action"` // created, updated, unchanged or invalid
+	Errors   []FieldError `json:"errors,omitempty"`
+	Warnings []string     `json:"warnings,omitempty"`
+}
+
+// ImportResult summarizes a bulk import
+type ImportResult struct {
+	DryRun    bool              `json:"dry_run"`
+	Total     int               `json:"total"`
+	Created   int               `json:"created"`
+	Updated   int               `json:"updated"`
+	Unchanged int               `json:"unchanged"`
+	Invalid   int               `json:"invalid"`
+	Rows      []ImportRowResult `json:"rows"`
+}
+
+// ValidateAccount checks the editable fields of an account
+func ValidateAccount(username string, email string, status string) []FieldError {
+	errs := []FieldError{}
+
+	if err := validateEmail(email); err != nil {
+		errs = append(errs, *err)
+	}
+
+	if err := validateUsername(username); err != nil {
+		errs = append(errs, *err)
+	}
+
+	if status == "" {
+		errs = append(errs, FieldError{"status", "is required"})
+	} else if !ValidStatus(status) {
+		errs = append(errs, FieldError{"status", "must be one of " + strings.Join(statusNames(), ", ")})
+	}
+
+	return errs
+}
+
+func validateEmail(email string) *FieldError {
+	if email == "" {
+		return &FieldError{"email", "is required"}
+	}
+	if addr, err := mail.ParseAddress(email); err != nil || addr.Address != email {
+		return &FieldError{"email", "is not a valid email address"}
+	}
+
+	return nil
+}
+
+func validateUsername(username string) *FieldError {
+	if username == "" {
+		return &FieldError{"username", "is required"}
+	}
+	if !usernamePattern.MatchString(username) {
+		return &FieldError{"username", "must be 1-12 letters, digits, spaces, hyphens or underscores"}
+	}
+
+	return nil
+}
+
+// AccountUpdate is a partial update of an account. nil fields are left unchanged
+type AccountUpdate struct {
+	Email    *string
+	Username *string
+	Status   *string
+	Notes    *string
+	Member   *bool
+
+	// set MembershipExpires (nil clears it) when SetMembershipExpires is true
+	SetMembershipExpires bool
+	MembershipExpires    *string
+
+	// why the status was changed, recorded in the status history
+	Reason string
+}
+
+// UpdateAccount validates and applies a partial update to acc, which must be the account as last read.
+// invalid fields are returned without changing anything. a disallowed status change returns a
+// *TransitionError and a concurrent modification db.ErrAccountModified
+func (s *Server) UpdateAccount(acc db.Account, u AccountUpdate, actor string) (db.Account, []FieldError, error) {
+	errs := []FieldError{}
+
+	if u.Email != nil {
+		*u.Email = strings.TrimSpace(*u.Email)
+		if err := validateEmail(*u.Email); err != nil {
+			errs = append(errs, *err)
+		} else if !strings.EqualFold(*u.Email, acc.Email) {
+			other, err := s.DB.GetAccountByEmail(*u.Email)
+			if err == nil && other.ID != acc.ID {
+				errs = append(errs, FieldError{"email", fmt.Sprintf("is already used by account %d", other.ID)})
+			} else if err != nil && err != sql.ErrNoRows {
+				return acc, nil, err
+			}
+		}
+	}
+
+	if u.Username != nil {
+		*u.Username = strings.TrimSpace(*u.Username)
+		if err := validateUsername(*u.Username); err != nil {
+			errs = append(errs, *err)
+		}
+	}
+
+	if u.Status != nil && !ValidStatus(*u.Status) {
+		errs = append(errs, FieldError{"status", "must be one of " + strings.Join(statusNames(), ", ")})
+	}
+
+	if u.SetMembershipExpires && u.MembershipExpires != nil {
+		if err := ValidateMembershipExpiry(*u.MembershipExpires); err != nil {
+			errs = append(errs, FieldError{"membership_expires", err.Error()})
+		}
+	}
+	if u.Member != nil && !*u.Member {
+		// f2p accounts have no expiry date
+		u.SetMembershipExpires = true
+		u.MembershipExpires = nil
+	}
+
+	if len(errs) > 0 {
+		return acc, errs, nil
+	}
+
+	if u.Status != nil && !CanTransition(acc.Status, *u.Status) {
+		return acc, nil, &TransitionError{From: acc.Status, To: *u.Status}
+	}
+
+	err := s.DB.PatchAccount(acc, db.AccountPatch{
+		Email:                u.Email,
+		Username:             u.Username,
+		Status:               u.Status,
+		Notes:                u.Notes,
+		Member:               u.Member,
+		SetMembershipExpires: u.SetMembershipExpires,
+		MembershipExpires:    u.MembershipExpires,
+		StatusReason:         u.Reason,
+		Actor:                actor,
+	})
+	if err == db.ErrEmailInUse {
+		// taken by a concurrent change since the check above
+		return acc, []FieldError{{"email", "is already used by another account"}}, nil
+	}
+	if err != nil {
+		return acc, nil, err
+	}
+	if u.Status != nil && *u.Status != acc.Status {
+		s.publishAccountStatus(acc, *u.Status, u.Reason, actor)
+	}
+
+	updated, err := s.DB.GetAccount(fmt.Sprint(acc.ID))
+	return updated, nil, err
+}
+
+// ParseAccounts reads accounts in the given format: "json" (an array of records), "csv" (a header row
+// with username, email and optional status and password columns) or "colon" (the accounts.txt format,
+// "username:email:password" or "email:password" per line)
+func ParseAccounts(r io.Reader, format string) ([]AccountRecord, error) {
+	switch strings.ToLower(format) {
+	case "json":
+		var records []AccountRecord
+		if err := json.NewDecoder(r).Decode(&records); err != nil {
+			return nil, err
+		}
+		return records, nil
+
+	case "csv":
+		return parseAccountsCSV(r)
+
+	case "colon", "txt":
+		return parseAccountsColon(r)
+	}
+
+	return nil, fmt.Errorf("unsupported format: %s", format)
+}
+
+func parseAccountsCSV(r io.Reader) ([]AccountRecord, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return []AccountRecord{}, nil
+	}
+
+	cols := map[string]int{}
+	for i, h := range rows[0] {
+		cols[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	if _, ok := cols["email"]; !ok {
+		return nil, fmt.Errorf("csv header must contain an email column")
+	}
+
+	field := func(row []string, name string) string {
+		i, ok := cols[name]
+		if !ok || i >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(row[i])
+	}
+
+	records := make([]AccountRecord, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		records = append(records, AccountRecord{
+			Username: field(row, "username"),
+			Email:    field(row, "email"),
+			Status:   field(row, "status"),
+			Password: field(row, "password"),
+		})
+	}
+
+	return records, nil
+}
+
+func parseAccountsColon(r io.Reader) ([]AccountRecord, error) {
+	records := []AccountRecord{}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, ":", 3)
+		switch len(parts) {
+		case 3:
+			records = append(records, AccountRecord{Username: parts[0], Email: parts[1], Password: parts[2]})
+		case 2:
+			// email:password, the username defaults to the local part of the email
+			username, _, _ := strings.Cut(parts[0], "@")
+			records = append(records, AccountRecord{Username: username, Email: parts[0], Password: parts[1]})
+		default:
+			// keep the row so it is reported as invalid with its position
+			records = append(records, AccountRecord{Email: parts[0]})
+		}
+	}
+
+	return records, scanner.Err()
+}
+
+// FormatAccounts writes accounts in one of the formats accepted by ParseAccounts
+func FormatAccounts(w io.Writer, format string, records []AccountRecord) error {
+	switch strings.ToLower(format) {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "    ")
+		return enc.Encode(records)
+
+	case "csv":
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"username", "email", "status", "password"})
+		for _, r := range records {
+			writer.Write([]string{r.Username, r.Email, r.Status, r.Password})
+		}
+		writer.Flush()
+		return writer.Error()
+
+	case "colon", "txt":
+		// always three fields, username:email would be read back as email:password
+		for _, r := range records {
+			if _, err := fmt.Fprintln(w, r.Username+":"+r.Email+":"+r.Password); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unsupported format: %s", format)
+}
+
+// importRecord trims and validates a record against the account with its email, nil if the email is new.
+// without a status an existing account keeps its own and a new one gets defaultAccountStatus
+func importRecord(rec AccountRecord, current *db.Account) (AccountRecord, []FieldError) {
+	rec.Username = strings.TrimSpace(rec.Username)
+	rec.Email = strings.TrimSpace(rec.Email)
+	if rec.Status == "" {
+		rec.Status = defaultAccountStatus
+		if current != nil {
+			rec.Status = current.Status
+		}
+	}
+
+	errs := ValidateAccount(rec.Username, rec.Email, rec.Status)
+	if current != nil && !CanTransition(current.Status, rec.Status) {
+		errs = append(errs, FieldError{"status", (&TransitionError{current.Status, rec.Status}).Error()})
+	}
+
+	return rec, errs
+}
+
+// ImportAccounts validates the records and upserts the valid ones by email. status changes of existing
+// accounts must be allowed transitions and are recorded with actor. passwords are stored in the
+// credential vault. with dryRun nothing is written and the rows report what would happen
+func (s *Server) ImportAccounts(records []AccountRecord, dryRun bool, actor string) (ImportResult, error) {
+	result := ImportResult{DryRun: dryRun, Total: len(records), Rows: []ImportRowResult{}}
+
+	existing, err := s.DB.GetAccounts()
+	if err != nil {
+		return result, err
+	}
+	byEmail := map[string]int{}
+	for i, acc := range existing {
+		byEmail[strings.ToLower(acc.Email)] = i
+	}
+
+	// emails of soft deleted accounts can't be reused until the account is restored or purged
+	deleted, err := s.DB.FindAccounts(db.AccountFilter{Deleted: true})
+	if err != nil {
+		return result, err
+	}
+	deletedByEmail := map[string]int{}
+	for _, acc := range deleted {
+		deletedByEmail[strings.ToLower(acc.Email)] = acc.ID
+	}
+
+	seen := map[string]int{}
+	for i, rec := range records {
+		key := strings.ToLower(strings.TrimSpace(rec.Email))
+		var current *db.Account
+		if idx, ok := byEmail[key]; ok {
+			current = &existing[idx]
+		}
+
+		rec, errs := importRecord(rec, current)
+		row := ImportRowResult{Row: i + 1, Email: rec.Email, Username: rec.Username, Errors: errs}
+
+		if first, dup := seen[key]; dup && rec.Email != "" {
+			row.Errors = append(row.Errors, FieldError{"email", fmt.Sprintf("duplicate of row %d", first)})
+		}
+		seen[key] = row.Row
+
+		if id, ok := deletedByEmail[key]; ok {
+			row.Errors = append(row.Errors, FieldError{"email", fmt.Sprintf("belongs to deleted account %d, restore it first", id)})
+		}
+
+		if len(row.Errors) > 0 {
+			row.Action = "invalid"
+			result.Invalid++
+			result.Rows = append(result.Rows, row)
+			continue
+		}
+
+		if rec.Password != "" && s.Vault == nil {
+			row.Warnings = append(row.Warnings, "password ignored: "+ErrVaultDisabled.Error())
+		}
+
+		if dryRun {
+			row.Action = "created"
+			if idx, ok := byEmail[key]; ok {
+				acc := existing[idx]
+				row.ID = acc.ID
+				row.Action = "updated"
+				if acc.Username == rec.Username && acc.Status == rec.Status {
+					row.Action = "unchanged"
+				}
+			}
+		} else {
+			row.ID, row.Action, err = s.DB.UpsertAccount(rec.Email, rec.Username, rec.Status, "import", actor)
+			if err != nil {
+				return result, fmt.Errorf("row %d: %w", row.Row, err)
+			}
+			if idx, ok := byEmail[key]; ok && row.Action == "updated" && existing[idx].Status != rec.Status {
+				s.publishAccountStatus(existing[idx], rec.Status, "import", actor)
+			}
+
+			if rec.Password != "" && s.Vault != nil {
+				if err := s.SetCredentials(row.ID, Credentials{Password: rec.Password}); err != nil {
+					return result, fmt.Errorf("row %d: storing credentials: %w", row.Row, err)
+				}
+			}
+		}
+
+		switch row.Action {
+		case "created":
+			result.Created++
+		case "updated":
+			result.Updated++
+		case "unchanged":
+			result.Unchanged++
+		}
+		result.Rows = append(result.Rows, row)
+	}
+
+	return result, nil
+}
+
+// ExportAccounts returns every account as a record, with passwords from the vault if requested
+func (s *Server) ExportAccounts(includeCredentials bool) ([]AccountRecord, error) {
+	accounts, err := s.DB.GetAccounts()
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]AccountRecord, 0, len(accounts))
+	for _, acc := range accounts {
+		rec := AccountRecord{Username: acc.Username, Email: acc.Email, Status: acc.Status}
+
+		if includeCredentials {
+			creds, ok, err := s.GetCredentials(acc.ID)
+			if err != nil {
+				return nil, fmt.Errorf("reading credentials of account %d: %w", acc.ID, err)
+			}
+			if ok {
+				rec.Password = creds.Password
+			}
+		}
+
+		records = append(records, rec)
+	}
+
+	return records, nil
+}
+
+// DeleteAccount soft deletes an account, stopping its bot first if one is running. with purge the
+// account and all of its history are removed permanently instead
+func (s *Server) DeleteAccount(acc db.Account, purge bool) error {
+	// stopping the bot ends its activity, so its heartbeat token stops working right away
+	s.stopBot(fmt.Sprint(acc.ID), db.ExitAccountDeleted)
+
+	if purge {
+		return s.DB.PurgeAccount(acc.ID)
+	}
+
+	return s.DB.DeleteAccount(acc.ID)
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	db "bot-api/db"
+	"strings"
+	"testing"
+)
+
+func TestImportKeepsStatusOfExistingAccounts(t *testing.T) {
+	records, err := ParseAccounts(strings.NewReader("username,email\nZezima,bot@example.com\n"), "csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, status := range []string{StatusActive, StatusBanned} {
+		current := &db.Account{ID: 12, Username: "Zezima", Email: "bot@example.com", Status: status}
+		rec, errs := importRecord(records[0], current)
+		if len(errs) > 0 {
+			t.Errorf("re-importing a %s account: %v", status, errs)
+		}
+		if rec.Status != status {
+			t.Errorf("re-importing a %s account changed its status to %s", status, rec.Status)
+		}
+	}
+
+	rec, errs := importRecord(AccountRecord{Username: "Zezima", Email: " new@example.com "}, nil)
+	if len(errs) > 0 || rec.Status != defaultAccountStatus || rec.Email != "new@example.com" {
+		t.Errorf("new account imported as %+v: %v", rec, errs)
+	}
+
+	current := &db.Account{ID: 12, Username: "Zezima", Email: "bot@example.com", Status: StatusActive}
+	if _, errs := importRecord(AccountRecord{Username: "Zezima", Email: "bot@example.com", Status: StatusNew}, current); len(errs) == 0 {
+		t.Error("expected an explicit active to new change to be rejected")
+	}
+}
+
+func TestExportedAccountsImportUnchanged(t *testing.T) {
+	withCredentials := []AccountRecord{{Username: "Zezima", Email: "bot@example.com", Password: "hunter2:with:colons"}}
+	withoutCredentials := []AccountRecord{{Username: "Zezima", Email: "bot@example.com"}}
+
+	for _, format := range []string{"colon", "csv", "json"} {
+		for _, exported := range [][]AccountRecord{withCredentials, withoutCredentials} {
+			var sb strings.Builder
+			if err := FormatAccounts(&sb, format, exported); err != nil {
+				t.Fatal(err)
+			}
+
+			imported, err := ParseAccounts(strings.NewReader(sb.String()), format)
+			if err != nil {
+				t.Fatalf("%s: %v", format, err)
+			}
+			if len(imported) != 1 || imported[0] != exported[0] {
+				t.Errorf("%s: exported %+v, imported %+v from %q", format, exported, imported, sb.String())
+			}
+		}
+	}
+}
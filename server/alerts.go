@@ -0,0 +1,377 @@
+package server
+
+import (
+	db "bot-api/db"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// kinds of alert rules
+const (
+	// a running bot sent no heartbeat for window_seconds. fires per account
+	AlertNoHeartbeat = "no_heartbeat"
+
+	// an account crashed more than threshold times in the last window_seconds. fires per account
+	AlertCrashes = "crashes"
+
+	// a bot running the rule's script for at least window_seconds gains less than threshold xp/hour over
+	// its session. fires per account
+	AlertXPRate = "xp_rate"
+
+	// fewer than threshold bots are running (the rule's script, or any). fires once for the fleet
+	AlertMinBots = "min_bots"
+)
+
+// AlertKinds lists every kind of alert rule
+var AlertKinds = []string{AlertNoHeartbeat, AlertCrashes, AlertXPRate, AlertMinBots}
+
+// severities of alert rules
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// AlertSeverities lists every severity, least severe first
+var AlertSeverities = []string{SeverityInfo, SeverityWarning, SeverityCritical}
+
+// AlertEvent is the data of the alert.firing and alert.resolved events
+type AlertEvent struct {
+	AlertID    int64   `json:"alert_id"`
+	RuleID     int     `json:"rule_id"`
+	Rule       string  `json:"rule"`
+	Severity   string  `json:"severity"`
+	Subject    string  `json:"subject"`
+	Message    string  `json:"message"`
+	Value      float64 `json:"value"`
+	FiredAt    string  `json:"fired_at"`
+	ResolvedAt *string `json:"resolved_at,omitempty"`
+}
+
+// alertCondition is a subject for which the condition of a rule holds
+type alertCondition struct {
+	Subject   string
+	AccountID int // 0 for fleet wide conditions
+	Value     float64
+	Message   string
+}
+
+// ValidateAlertRule checks the name, kind, severity, threshold and window of a rule
+func ValidateAlertRule(r db.AlertRule) []FieldError {
+	errs := []FieldError{}
+
+	if strings.TrimSpace(r.Name) == "" {
+		errs = append(errs, FieldError{"name", "is empty"})
+	} else if len(r.Name) > 255 {
+		errs = append(errs, FieldError{"name", "must be at most 255 characters"})
+	}
+
+	if !contains(AlertKinds, r.Kind) {
+		errs = append(errs, FieldError{"kind", "must be one of " + strings.Join(AlertKinds, ", ")})
+	}
+	if !contains(AlertSeverities, r.Severity) {
+		errs = append(errs, FieldError{"severity", "must be one of " + strings.Join(AlertSeverities, ", ")})
+	}
+
+	if r.Threshold < 0 {
+		errs = append(errs, FieldError{"threshold", "must be 0 or more"})
+	}
+	if r.Kind == AlertMinBots && r.Threshold < 1 {
+		errs = append(errs, FieldError{"threshold", "must be at least 1 bot"})
+	}
+
+	if r.WindowSeconds < 0 {
+		errs = append(errs, FieldError{"window_seconds", "must be 0 or more"})
+	}
+	if (r.Kind == AlertNoHeartbeat || r.Kind == AlertCrashes || r.Kind == AlertXPRate) && r.WindowSeconds == 0 {
+		errs = append(errs, FieldError{"window_seconds", "is required for " + r.Kind + " rules"})
+	}
+
+	if r.Kind == AlertXPRate && r.Script == "" {
+		errs = append(errs, FieldError{"script", "is required for xp_rate rules"})
+	}
+
+	return errs
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// evaluateAlerts checks the enabled alert rules, at most once per evaluation interval. new conditions fire
+// alerts and conditions that no longer hold resolve them. firing and resolving are published on the bus
+// unless a silence matches the alert
+func (s *Server) evaluateAlerts() {
+	now := time.Now()
+	if s.Config.AlertEvaluationInterval <= 0 || now.Sub(s.lastAlertEvaluation) < s.Config.AlertEvaluationInterval {
+		return
+	}
+	s.lastAlertEvaluation = now
+
+	rules, err := s.DB.GetAlertRules()
+	if err != nil {
+		slog.Error("error getting alert rules", "error", err)
+		return
+	}
+
+	alerts, err := s.DB.GetFiringAlerts()
+	if err != nil {
+		slog.Error("error getting firing alerts", "error", err)
+		return
+	}
+	firing := map[int]map[string]db.Alert{}
+	for _, a := range alerts {
+		if firing[a.RuleID] == nil {
+			firing[a.RuleID] = map[string]db.Alert{}
+		}
+		firing[a.RuleID][a.Subject] = a
+	}
+
+	silences, err := s.DB.GetAlertSilences(now)
+	if err != nil {
+		slog.Error("error getting alert silences", "error", err)
+		return
+	}
+
+	for _, rule := range rules {
+		if !rule.Enabled {
+			continue
+		}
+
+		conditions, err := s.checkAlertRule(rule, now)
+		if err != nil {
+			// keep the alerts of the rule as they are rather than resolving them on a failed check
+			slog.Error("error evaluating alert rule", "rule_id", rule.ID, "rule", rule.Name, "error", err)
+			delete(firing, rule.ID)
+			continue
+		}
+
+		for _, cond := range conditions {
+			a, ok := firing[rule.ID][cond.Subject]
+			delete(firing[rule.ID], cond.Subject)
+			if !ok {
+				a = db.Alert{RuleID: rule.ID, RuleName: rule.Name, Severity: rule.Severity, Subject: cond.Subject, Status: db.AlertFiring}
+				if cond.AccountID != 0 {
+					id := cond.AccountID
+					a.AccountID = &id
+				}
+			}
+			s.fireAlert(a, cond, silenced(silences, rule.ID, cond.AccountID, now), now)
+		}
+	}
+
+	// what is left no longer holds, or belongs to a rule that was deleted or disabled
+	for _, bySubject := range firing {
+		for _, a := range bySubject {
+			s.resolveAlert(a, now)
+		}
+	}
+}
+
+// fireAlert stores a new or still firing alert and publishes it the first time it isn't silenced
+func (s *Server) fireAlert(a db.Alert, cond alertCondition, silenced bool, now time.Time) {
+	changed := a.ID == 0 || a.Message != cond.Message || a.Value != cond.Value || a.Silenced != silenced
+	a.Message, a.Value, a.Silenced = cond.Message, cond.Value, silenced
+
+	notify := !a.Notified && !silenced
+	if notify {
+		a.Notified = true
+	}
+
+	if a.ID == 0 {
+		slog.Warn("alert fired", alertAttrs(a)...)
+		id, err := s.DB.InsertAlert(a, now)
+		if err != nil {
+			slog.Error("error storing alert", append(alertAttrs(a), "error", err)...)
+			return
+		}
+		a.ID = id
+		a.FiredAt = now.Format(db.TimeFormat)
+	} else if changed || notify {
+		if err := s.DB.UpdateAlert(a, now); err != nil {
+			slog.Error("error updating alert", append(alertAttrs(a), "error", err)...)
+			return
+		}
+	}
+
+	if notify {
+		s.Bus.Publish(EventAlertFiring, alertAccountID(a), alertEvent(a))
+	}
+}
+
+// resolveAlert marks an alert resolved, publishing it if its firing was published
+func (s *Server) resolveAlert(a db.Alert, now time.Time) {
+	resolved := now.Format(db.TimeFormat)
+	a.Status = db.AlertResolved
+	a.ResolvedAt = &resolved
+
+	slog.Info("alert resolved", alertAttrs(a)...)
+	if err := s.DB.UpdateAlert(a, now); err != nil {
+		slog.Error("error resolving alert", append(alertAttrs(a), "error", err)...)
+		return
+	}
+
+	if a.Notified {
+		s.Bus.Publish(EventAlertResolved, alertAccountID(a), alertEvent(a))
+	}
+}
+
+// alertAttrs are the log attributes of an alert
+func alertAttrs(a db.Alert) []any {
+	attrs := []any{"alert_id", a.ID, "rule_id", a.RuleID, "rule", a.RuleName, "severity", a.Severity, "subject", a.Subject, "message", a.Message}
+	if a.AccountID != nil {
+		attrs = append(attrs, "account_id", *a.AccountID)
+	}
+
+	return attrs
+}
+
+func alertAccountID(a db.Alert) int {
+	if a.AccountID == nil {
+		return 0
+	}
+
+	return *a.AccountID
+}
+
+func alertEvent(a db.Alert) AlertEvent {
+	return AlertEvent{AlertID: a.ID, RuleID: a.RuleID, Rule: a.RuleName, Severity: a.Severity, Subject: a.Subject,
+		Message: a.Message, Value: a.Value, FiredAt: a.FiredAt, ResolvedAt: a.ResolvedAt}
+}
+
+// silenced reports whether one of the silences matches an alert of the rule for the account at now
+func silenced(silences []db.AlertSilence, ruleID int, accountID int, now time.Time) bool {
+	at := now.Format(db.TimeFormat)
+	for _, si := range silences {
+		if si.StartsAt > at || si.EndsAt <= at {
+			continue
+		}
+		if si.RuleID != nil && *si.RuleID != ruleID {
+			continue
+		}
+		if si.AccountID != nil && *si.AccountID != accountID {
+			continue
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// checkAlertRule returns the subjects for which the condition of a rule holds at now
+func (s *Server) checkAlertRule(rule db.AlertRule, now time.Time) ([]alertCondition, error) {
+	window := time.Duration(rule.WindowSeconds) * time.Second
+
+	switch rule.Kind {
+	case AlertNoHeartbeat:
+		running, err := s.DB.GetRunningActivities(rule.Script)
+		if err != nil {
+			return nil, err
+		}
+
+		s.hbMu.Lock()
+		defer s.hbMu.Unlock()
+
+		conditions := []alertCondition{}
+		for _, a := range running {
+			// bots the monitor hasn't seen yet have no last heartbeat to go by
+			last, ok := s.lastSeen[a.AccountID]
+			if !ok || now.Sub(last) < window {
+				continue
+			}
+			silence := now.Sub(last).Round(time.Second)
+			conditions = append(conditions, alertCondition{
+				Subject:   accountSubject(a.AccountID),
+				AccountID: a.AccountID,
+				Value:     silence.Seconds(),
+				Message:   fmt.Sprintf("no heartbeat from %s for %s", a.Username, silence),
+			})
+		}
+		return conditions, nil
+
+	case AlertCrashes:
+		crashes, err := s.DB.CountCrashes(now.Add(-window), rule.Script)
+		if err != nil {
+			return nil, err
+		}
+
+		conditions := []alertCondition{}
+		for accountID, n := range crashes {
+			if float64(n) <= rule.Threshold {
+				continue
+			}
+			conditions = append(conditions, alertCondition{
+				Subject:   accountSubject(accountID),
+				AccountID: accountID,
+				Value:     float64(n),
+				Message:   fmt.Sprintf("account %d crashed %d times in the last %s", accountID, n, window),
+			})
+		}
+		return conditions, nil
+
+	case AlertXPRate:
+		running, err := s.DB.GetRunningActivities(rule.Script)
+		if err != nil {
+			return nil, err
+		}
+
+		conditions := []alertCondition{}
+		for _, a := range running {
+			started, err := time.ParseInLocation(db.TimeFormat, a.StartedAt, time.Local)
+			if err != nil {
+				return nil, err
+			}
+			runtime := now.Sub(started)
+			if runtime < window {
+				// too early to judge
+				continue
+			}
+
+			rate := float64(a.XPGained) / runtime.Hours()
+			if rate >= rule.Threshold {
+				continue
+			}
+			conditions = append(conditions, alertCondition{
+				Subject:   accountSubject(a.AccountID),
+				AccountID: a.AccountID,
+				Value:     rate,
+				Message:   fmt.Sprintf("%s gains %.0f xp/hour running %s, below %.0f", a.Username, rate, rule.Script, rule.Threshold),
+			})
+		}
+		return conditions, nil
+
+	case AlertMinBots:
+		running, err := s.DB.GetRunningActivities(rule.Script)
+		if err != nil {
+			return nil, err
+		}
+		if float64(len(running)) >= rule.Threshold {
+			return nil, nil
+		}
+
+		subject, what := "fleet", "bots"
+		if rule.Script != "" {
+			subject, what = "script:"+rule.Script, rule.Script+" bots"
+		}
+		return []alertCondition{{
+			Subject: subject,
+			Value:   float64(len(running)),
+			Message: fmt.Sprintf("%d %s running, fewer than %.0f", len(running), what, rule.Threshold),
+		}}, nil
+	}
+
+	return nil, fmt.Errorf("unknown alert rule kind %q", rule.Kind)
+}
+
+func accountSubject(accountID int) string {
+	return fmt.Sprintf("account:%d", accountID)
+}
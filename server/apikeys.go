@@ -0,0 +1,127 @@
+package server
+
+import (
+	db "bot-api/db"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Role controls which routes an api key may call. every role may do everything the roles below it can
+type Role string
+
+const (
+	// read-only access to bots, accounts, activity and prices
+	RoleViewer Role = "viewer"
+
+	// may also start and stop bots and change accounts
+	RoleOperator Role = "operator"
+
+	// may also manage api keys
+	RoleAdmin Role = "admin"
+)
+
+var roleRank = map[Role]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
+
+const apiKeyPrefix = "obk_"
+
+var ErrInvalidAPIKey = errors.New("missing or invalid api key")
+
+// ParseRole validates a role name
+func ParseRole(name string) (Role, error) {
+	r := Role(strings.ToLower(name))
+	if _, ok := roleRank[r]; !ok {
+		return "", fmt.Errorf("unknown role: %s", name)
+	}
+
+	return r, nil
+}
+
+// Allows reports whether a key with this role may call a route that requires the given role
+func (r Role) Allows(required Role) bool {
+	return roleRank[r] >= roleRank[required]
+}
+
+// CreateAPIKey generates and stores a new api key. the plain key is only returned here, the database
+// keeps its hash
+func (s *Server) CreateAPIKey(name string, role Role) (string, db.APIKey, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", db.APIKey{}, err
+	}
+	key := apiKeyPrefix + hex.EncodeToString(buf)
+
+	id, err := s.DB.InsertAPIKey(name, displayPrefix(key), HashToken(key), string(role))
+	if err != nil {
+		return "", db.APIKey{}, err
+	}
+
+	return key, db.APIKey{ID: id, Name: name, Prefix: displayPrefix(key), Role: string(role)}, nil
+}
+
+// AuthenticateAPIKey returns the stored api key matching a plain key
+func (s *Server) AuthenticateAPIKey(key string) (db.APIKey, error) {
+	if key == "" {
+		return db.APIKey{}, ErrInvalidAPIKey
+	}
+
+	apiKey, err := s.DB.GetAPIKeyByHash(HashToken(key))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return db.APIKey{}, ErrInvalidAPIKey
+		}
+		return db.APIKey{}, err
+	}
+
+	if err := s.DB.TouchAPIKey(apiKey.ID); err != nil {
+		slog.Error("error updating last use of api key", "key_prefix", apiKey.Prefix, "error", err)
+	}
+
+	return apiKey, nil
+}
+
+// bootstrapAdminKey makes sure there is a way to call the admin routes. if no admin key exists, the
+// configured bootstrap key is stored, or a new key is generated and printed once
+func (s *Server) bootstrapAdminKey() error {
+	count, err := s.DB.CountActiveAPIKeys(string(RoleAdmin))
+	if err != nil || count > 0 {
+		return err
+	}
+
+	if key := s.Config.BootstrapAdminKey; key != "" {
+		_, err := s.DB.InsertAPIKey("bootstrap", displayPrefix(key), HashToken(key), string(RoleAdmin))
+		if err == nil {
+			slog.Info("stored bootstrap admin api key from BOOTSTRAP_ADMIN_KEY")
+		}
+		return err
+	}
+
+	key, _, err := s.CreateAPIKey("bootstrap", RoleAdmin)
+	if err != nil {
+		return err
+	}
+
+	slog.Warn("no admin api key found, generated a bootstrap admin key and printed it to stderr", "prefix", displayPrefix(key))
+	fmt.Fprintf(os.Stderr, "bootstrap admin api key, it will not be shown again: %s\n", key)
+
+	return nil
+}
+
+// the part of a key that is stored in plain text to identify it
+func displayPrefix(key string) string {
+	n := len(apiKeyPrefix) + 8
+	if len(key) < n {
+		return key
+	}
+
+	return key[:n]
+}
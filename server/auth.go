@@ -0,0 +1,78 @@
+package server
+
+import (
+	db "bot-api/db"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+)
+
+// Heartbeat authentication modes, see Config.HeartbeatAuthMode
+const (
+	// unauthenticated heartbeats are rejected
+	HeartbeatAuthReject = "reject"
+
+	// unauthenticated heartbeats are stored in the quarantined_heartbeats table and otherwise ignored
+	HeartbeatAuthQuarantine = "quarantine"
+)
+
+var (
+	ErrHeartbeatUnauthenticated = errors.New("missing or invalid heartbeat token")
+	ErrHeartbeatForbidden       = errors.New("heartbeat token was not issued for this account")
+)
+
+// NewHeartbeatToken generates a random token to be handed to a launched client
+func NewHeartbeatToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf), nil
+}
+
+// HashToken returns the hex encoded sha256 of a token - only hashes are stored in the database
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// RegisterHeartbeatToken stores a token issued to the client of an activity so its heartbeats can be authenticated
+func (s *Server) RegisterHeartbeatToken(activityID int, accountID int, token string) error {
+	return s.DB.InsertHeartbeatToken(activityID, accountID, HashToken(token))
+}
+
+// AuthenticateHeartbeat checks the bearer token presented with a heartbeat and returns the account it belongs to.
+// the token must belong to a running activity of the account the heartbeat claims to be from
+func (s *Server) AuthenticateHeartbeat(token string, hb Heartbeat) (db.Account, error) {
+	if token == "" {
+		return db.Account{}, ErrHeartbeatUnauthenticated
+	}
+
+	account, _, err := s.DB.GetAccountForHeartbeatToken(HashToken(token))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return db.Account{}, ErrHeartbeatUnauthenticated
+		}
+		return db.Account{}, err
+	}
+
+	if account.Email != hb.Email {
+		return db.Account{}, ErrHeartbeatForbidden
+	}
+
+	return account, nil
+}
+
+// QuarantineHeartbeat stores a heartbeat that failed authentication so it can be reviewed later
+func (s *Server) QuarantineHeartbeat(hb Heartbeat, remoteAddr string, reason string) error {
+	payload, err := json.Marshal(hb)
+	if err != nil {
+		return err
+	}
+
+	return s.DB.InsertQuarantinedHeartbeat(hb.Email, remoteAddr, reason, payload)
+}
@@ -0,0 +1,206 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// what Publish does when a subscriber's queue is full
+const (
+	BusBlock = "block" // wait for room, slowing the publisher down. for subscribers that must see every event
+	BusDrop  = "drop"  // discard the event for that subscriber
+)
+
+// Bus is an in-process publish/subscribe event bus. every subscriber has its own bounded queue drained by
+// its own goroutine, so a slow subscriber only holds up the publishers (BusBlock) or loses events
+// (BusDrop), never the other subscribers
+type Bus struct {
+	// serializes publishes so every subscriber sees the events in id order
+	publishMu sync.Mutex
+	lastID    int64
+
+	mu          sync.RWMutex
+	subscribers []*busSubscriber
+}
+
+type busSubscriber struct {
+	name   string
+	types  map[string]bool
+	policy string
+	queue  chan Event
+	handle func(Event)
+
+	queued         atomic.Uint64
+	handled        atomic.Uint64
+	dropped        atomic.Uint64
+	failed         atomic.Uint64
+	blocked        atomic.Uint64
+	blockedNanos   atomic.Int64
+	handlingNanos  atomic.Int64
+	lastHandledUTC atomic.Int64
+}
+
+// SubscriberStats reports the queue and throughput of a bus subscriber
+type SubscriberStats struct {
+	Name     string   `json:"name"`
+	Types    []string `json:"types,omitempty"` // empty when subscribed to every type
+	Policy   string   `json:"policy"`
+	Queued   int      `json:"queued"` // events waiting in the queue
+	Capacity int      `json:"capacity"`
+
+	Published uint64 `json:"published"` // events put in the queue
+	Handled   uint64 `json:"handled"`
+	Dropped   uint64 `json:"dropped"` // events discarded because the queue was full (BusDrop)
+	Failed    uint64 `json:"failed"`  // events whose handler panicked
+
+	// publishes that had to wait for room in the queue (BusBlock) and how long they waited in total
+	Blocked        uint64  `json:"blocked"`
+	BlockedSeconds float64 `json:"blocked_seconds"`
+
+	HandlingSeconds float64    `json:"handling_seconds"` // total time spent in the handler
+	LastHandledAt   *time.Time `json:"last_handled_at,omitempty"`
+}
+
+func NewBus() *Bus {
+	// ids continue from the start time so they keep increasing across restarts and a stale Last-Event-ID
+	// can't match events of the new process
+	return &Bus{lastID: time.Now().UnixMilli()}
+}
+
+// Subscribe registers a handler for the given event types, or every type when none are given. events are
+// handled one at a time on a goroutine of the subscriber
+func (b *Bus) Subscribe(name string, queueSize int, policy string, handle func(Event), types ...string) {
+	sub := &busSubscriber{
+		name:   name,
+		types:  make(map[string]bool),
+		policy: policy,
+		queue:  make(chan Event, queueSize),
+		handle: handle,
+	}
+	for _, t := range types {
+		sub.types[t] = true
+	}
+
+	b.mu.Lock()
+	b.subscribers = append(b.subscribers, sub)
+	b.mu.Unlock()
+
+	go sub.run()
+}
+
+// Publish stamps an event with the next id and the current time and queues it for every subscriber of
+// its type. handlers may publish too
+func (b *Bus) Publish(eventType string, accountID int, data interface{}) Event {
+	b.publishMu.Lock()
+	b.lastID++
+	e := Event{ID: b.lastID, Type: eventType, Time: time.Now(), AccountID: accountID, Data: data}
+
+	b.mu.RLock()
+	var full []*busSubscriber
+	for _, sub := range b.subscribers {
+		if len(sub.types) > 0 && !sub.types[eventType] {
+			continue
+		}
+		if !sub.tryEnqueue(e) {
+			full = append(full, sub)
+		}
+	}
+	b.mu.RUnlock()
+	b.publishMu.Unlock()
+
+	// wait for room outside of the lock, so handlers can still publish while a publisher waits for their queue.
+	// subscribers get events in id order unless their queue was full
+	for _, sub := range full {
+		sub.wait(e)
+	}
+
+	return e
+}
+
+// Stats returns the stats of every subscriber in subscription order
+func (b *Bus) Stats() []SubscriberStats {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	stats := make([]SubscriberStats, 0, len(b.subscribers))
+	for _, sub := range b.subscribers {
+		st := SubscriberStats{
+			Name:            sub.name,
+			Policy:          sub.policy,
+			Queued:          len(sub.queue),
+			Capacity:        cap(sub.queue),
+			Published:       sub.queued.Load(),
+			Handled:         sub.handled.Load(),
+			Dropped:         sub.dropped.Load(),
+			Failed:          sub.failed.Load(),
+			Blocked:         sub.blocked.Load(),
+			BlockedSeconds:  time.Duration(sub.blockedNanos.Load()).Seconds(),
+			HandlingSeconds: time.Duration(sub.handlingNanos.Load()).Seconds(),
+		}
+		for _, t := range EventTypes {
+			if sub.types[t] {
+				st.Types = append(st.Types, t)
+			}
+		}
+		if last := sub.lastHandledUTC.Load(); last != 0 {
+			t := time.Unix(0, last)
+			st.LastHandledAt = &t
+		}
+		stats = append(stats, st)
+	}
+
+	return stats
+}
+
+// queues the event if there is room, or drops it for BusDrop subscribers. returns false if the publisher
+// has to wait
+func (sub *busSubscriber) tryEnqueue(e Event) bool {
+	select {
+	case sub.queue <- e:
+		sub.queued.Add(1)
+		return true
+	default:
+	}
+
+	if sub.policy == BusDrop {
+		sub.dropped.Add(1)
+		return true
+	}
+
+	return false
+}
+
+func (sub *busSubscriber) wait(e Event) {
+	start := time.Now()
+	sub.queue <- e
+	sub.queued.Add(1)
+	sub.blocked.Add(1)
+	sub.blockedNanos.Add(int64(time.Since(start)))
+}
+
+func (sub *busSubscriber) run() {
+	for e := range sub.queue {
+		start := time.Now()
+		sub.handleEvent(e)
+		sub.handlingNanos.Add(int64(time.Since(start)))
+		sub.lastHandledUTC.Store(time.Now().UnixNano())
+		sub.handled.Add(1)
+	}
+}
+
+// a panicking handler loses the event but keeps the subscriber running
+func (sub *busSubscriber) handleEvent(e Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			sub.failed.Add(1)
+			slog.Error("event subscriber panicked", "subscriber", sub.name, "event_type", e.Type, "event_id", e.ID,
+				"panic", fmt.Sprint(r), "stack", string(debug.Stack()))
+		}
+	}()
+
+	sub.handle(e)
+}
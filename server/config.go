@@ -0,0 +1,155 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Config holds the tunable settings of the server. values are read from the environment by LoadConfig
+type Config struct {
+	// minimum time between two stored heartbeats of the same account. heartbeats with a changed status are always stored
+	HeartbeatSampleInterval time.Duration
+
+	// heartbeats older than this are deleted, 0 keeps them forever
+	HeartbeatRetention time.Duration
+
+	// quarantined heartbeats older than this are deleted, 0 keeps them forever. anyone can add to the
+	// quarantine, so it is kept for less time than the heartbeats
+	HeartbeatQuarantineRetention time.Duration
+
+	// heartbeats older than this are thinned out to one per HeartbeatDownsampleInterval, 0 disables downsampling
+	HeartbeatDownsampleAfter    time.Duration
+	HeartbeatDownsampleInterval time.Duration
+
+	// how often retention and downsampling are applied
+	HeartbeatMaintenanceInterval time.Duration
+
+	// a running bot without a heartbeat for this long is reported as unresponsive, 0 disables the check
+	HeartbeatUnresponsiveAfter time.Duration
+
+	// delivered and suppressed webhook deliveries older than this are deleted, 0 keeps them forever
+	WebhookDeliveryRetention time.Duration
+
+	// how often the alert rules are evaluated, 0 disables alerting
+	AlertEvaluationInterval time.Duration
+
+	// what to do with heartbeats that don't present a valid token, HeartbeatAuthReject or HeartbeatAuthQuarantine
+	HeartbeatAuthMode string
+
+	// where item prices come from: "file", "http" or empty to only use cached prices
+	PriceSource string
+
+	// price dump read by the file source (.json or .csv)
+	PriceFile string
+
+	// price api queried by the http source, and the user agent sent to it
+	PriceURL       string
+	PriceUserAgent string
+
+	// prices older than this are reported as stale
+	PriceMaxAge time.Duration
+
+	// how often prices are fetched from the source
+	PriceRefreshInterval time.Duration
+
+	// admin api key stored on startup when no admin key exists yet. a key is generated when empty
+	BootstrapAdminKey string
+
+	// base64 encoded 32 byte key used to encrypt account credentials. the vault is disabled when empty
+	CredentialsKey string
+
+	// how accounts are labeled in the metrics: MetricsAccountNone, MetricsAccountID or MetricsAccountUsername
+	MetricsAccountLabel string
+
+	// most accounts labeled in the metrics, the others are counted as "other"
+	MetricsAccountLimit int
+
+	// bearer token required to scrape /metrics, open when empty
+	MetricsToken string
+
+	// format of the log records, logging.FormatText or logging.FormatJSON
+	LogFormat string
+
+	// least severe level logged: debug, info, warn or error
+	LogLevel string
+}
+
+// LoadConfig reads the server configuration from environment variables, falling back to defaults. values
+// the server can't run with are returned as an error
+func LoadConfig() (Config, error) {
+	config := Config{
+		HeartbeatSampleInterval:      envDuration("HEARTBEAT_SAMPLE_INTERVAL", time.Minute),
+		HeartbeatRetention:           envDuration("HEARTBEAT_RETENTION", 30*24*time.Hour),
+		HeartbeatQuarantineRetention: envDuration("HEARTBEAT_QUARANTINE_RETENTION", 7*24*time.Hour),
+		HeartbeatDownsampleAfter:     envDuration("HEARTBEAT_DOWNSAMPLE_AFTER", 24*time.Hour),
+		HeartbeatDownsampleInterval:  envDuration("HEARTBEAT_DOWNSAMPLE_INTERVAL", 15*time.Minute),
+		HeartbeatMaintenanceInterval: envDuration("HEARTBEAT_MAINTENANCE_INTERVAL", time.Hour),
+		HeartbeatUnresponsiveAfter:   envDuration("HEARTBEAT_UNRESPONSIVE_AFTER", 5*time.Minute),
+		WebhookDeliveryRetention:     envDuration("WEBHOOK_DELIVERY_RETENTION", 7*24*time.Hour),
+		AlertEvaluationInterval:      envDuration("ALERT_EVALUATION_INTERVAL", 30*time.Second),
+		HeartbeatAuthMode:            envString("HEARTBEAT_AUTH_MODE", HeartbeatAuthQuarantine),
+		PriceSource:                  envString("PRICE_SOURCE", ""),
+		PriceFile:                    envString("PRICE_FILE", "prices.json"),
+		PriceURL:                     envString("PRICE_URL", "https://prices.runescape.wiki/api/v1/osrs/latest"),
+		PriceUserAgent:               envString("PRICE_USER_AGENT", "osrs-bot-api"),
+		PriceMaxAge:                  envDuration("PRICE_MAX_AGE", 24*time.Hour),
+		PriceRefreshInterval:         envDuration("PRICE_REFRESH_INTERVAL", 6*time.Hour),
+		BootstrapAdminKey:            envString("BOOTSTRAP_ADMIN_KEY", ""),
+		CredentialsKey:               envString("CREDENTIALS_KEY", ""),
+		MetricsAccountLabel:          envString("METRICS_ACCOUNT_LABEL", MetricsAccountNone),
+		MetricsAccountLimit:          envInt("METRICS_ACCOUNT_LIMIT", 100),
+		MetricsToken:                 envString("METRICS_TOKEN", ""),
+		LogFormat:                    envString("LOG_FORMAT", "text"),
+		LogLevel:                     envString("LOG_LEVEL", "info"),
+	}
+
+	switch config.HeartbeatAuthMode {
+	case HeartbeatAuthReject, HeartbeatAuthQuarantine:
+	default:
+		return config, fmt.Errorf("invalid HEARTBEAT_AUTH_MODE %q, must be %s or %s", config.HeartbeatAuthMode, HeartbeatAuthReject, HeartbeatAuthQuarantine)
+	}
+
+	return config, nil
+}
+
+func envString(key string, def string) string {
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		return v
+	}
+
+	return def
+}
+
+// envDuration parses a duration such as "90s" or "24h" from the environment
+func envDuration(key string, def time.Duration) time.Duration {
+	v, ok := os.LookupEnv(key)
+	if !ok || v == "" {
+		return def
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		slog.Warn("invalid duration, using the default", "variable", key, "value", v, "default", def.String())
+		return def
+	}
+
+	return d
+}
+
+func envInt(key string, def int) int {
+	v, ok := os.LookupEnv(key)
+	if !ok || v == "" {
+		return def
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		slog.Warn("invalid number, using the default", "variable", key, "value", v, "default", def)
+		return def
+	}
+
+	return n
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+)
+
+// Credentials are the login details of an account. they are only ever written through the api and
+// read by the launcher
+type Credentials struct {
+	Password string `json:"password"`
+}
+
+// String keeps credentials out of logs
+func (c Credentials) String() string {
+	return "Credentials{REDACTED}"
+}
+
+func (c Credentials) GoString() string {
+	return c.String()
+}
+
+// SetCredentials encrypts and stores the credentials of an account
+func (s *Server) SetCredentials(accountID int, creds Credentials) error {
+	if s.Vault == nil {
+		return ErrVaultDisabled
+	}
+
+	plaintext, err := json.Marshal(creds)
+	if err != nil {
+		return err
+	}
+
+	nonce, ciphertext, err := s.Vault.Seal(accountID, plaintext)
+	if err != nil {
+		return err
+	}
+
+	return s.DB.UpsertAccountCredentials(accountID, nonce, ciphertext)
+}
+
+// GetCredentials returns the decrypted credentials of an account. ok is false if none are stored or the
+// vault is not configured
+func (s *Server) GetCredentials(accountID int) (creds Credentials, ok bool, err error) {
+	if s.Vault == nil {
+		return creds, false, nil
+	}
+
+	nonce, ciphertext, err := s.DB.GetAccountCredentials(accountID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return creds, false, nil
+		}
+		return creds, false, err
+	}
+
+	plaintext, err := s.Vault.Open(accountID, nonce, ciphertext)
+	if err != nil {
+		return creds, false, err
+	}
+
+	if err := json.Unmarshal(plaintext, &creds); err != nil {
+		return creds, false, err
+	}
+
+	return creds, true, nil
+}
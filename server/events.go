@@ -0,0 +1,215 @@
+package server
+
+import (
+	db "bot-api/db"
+	"sync"
+	"time"
+)
+
+// types of the events published on the bus
+const (
+	EventBotStarted        = "bot.started"      // a client was launched
+	EventBotStopped        = "bot.stopped"      // a bot was stopped through the api or its account was deleted
+	EventBotCrashed        = "bot.crashed"      // the client process of a running bot is gone without being stopped
+	EventBotStatus         = "bot.status"       // the status reported by a bot's heartbeats changed
+	EventHeartbeatReceived = "heartbeat"        // an authenticated heartbeat was received
+	EventLevelUp           = "level.up"         // a skill level reported by a heartbeat went up
+	EventBotUnresponsive   = "bot.unresponsive" // a running bot sent no heartbeat for HeartbeatUnresponsiveAfter
+	EventAccountStatus     = "account.status"   // the status of an account changed
+	EventAlertFiring       = "alert.firing"     // the condition of an alert rule started to hold, see alerts.go
+	EventAlertResolved     = "alert.resolved"   // the condition of a notified alert no longer holds
+)
+
+// EventTypes lists every event type
+var EventTypes = []string{EventBotStarted, EventBotStopped, EventBotCrashed, EventBotStatus, EventHeartbeatReceived,
+	EventLevelUp, EventBotUnresponsive, EventAccountStatus, EventAlertFiring, EventAlertResolved}
+
+// number of recent events kept so disconnected subscribers can resume
+const eventBufferSize = 1000
+
+// events queued per subscriber before it is considered too slow and dropped
+const subscriberQueueSize = 256
+
+// Event is something that happened in the fleet
+type Event struct {
+	ID        int64       `json:"id"` // increases with every event, used to resume a stream
+	Type      string      `json:"type"`
+	Time      time.Time   `json:"time"`
+	AccountID int         `json:"account_id,omitempty"`
+	Data      interface{} `json:"data,omitempty"`
+}
+
+// BotStateEvent is the data of the bot.started, bot.stopped and bot.crashed events
+type BotStateEvent struct {
+	Email    string `json:"email"`
+	Username string `json:"username"`
+	Script   string `json:"script,omitempty"`
+	PID      int    `json:"pid,omitempty"`
+	Reason   string `json:"reason,omitempty"` // exit reason of a stopped bot
+}
+
+// BotStatusEvent is the data of a bot.status event
+type BotStatusEvent struct {
+	Email string `json:"email"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+// LevelUpEvent is the data of a level.up event
+type LevelUpEvent struct {
+	Username string `json:"username"`
+	Skill    string `json:"skill"`
+	From     int    `json:"from"`
+	To       int    `json:"to"`
+}
+
+// BotUnresponsiveEvent is the data of a bot.unresponsive event
+type BotUnresponsiveEvent struct {
+	Email    string    `json:"email"`
+	Username string    `json:"username"`
+	Script   string    `json:"script,omitempty"`
+	PID      int       `json:"pid,omitempty"`
+	LastSeen time.Time `json:"last_seen"` // last heartbeat, or when the bot was launched or found running
+}
+
+// AccountStatusEvent is the data of an account.status event
+type AccountStatusEvent struct {
+	Username string `json:"username"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Reason   string `json:"reason,omitempty"`
+	Actor    string `json:"actor,omitempty"`
+}
+
+// EventFilter selects the events of a subscription, empty sets match everything
+type EventFilter struct {
+	AccountIDs map[int]bool
+	Types      map[string]bool
+}
+
+// Match reports whether the event passes the filter
+func (f EventFilter) Match(e Event) bool {
+	if len(f.Types) > 0 && !f.Types[e.Type] {
+		return false
+	}
+	if len(f.AccountIDs) > 0 && !f.AccountIDs[e.AccountID] {
+		return false
+	}
+
+	return true
+}
+
+// HeartbeatReceivedEvent is the data of a heartbeat event, serialized as the heartbeat
+type HeartbeatReceivedEvent struct {
+	Heartbeat
+	Account       db.Account `json:"-"`
+	StatusChanged bool       `json:"-"` // the status differs from the previous heartbeat of the bot
+}
+
+// EventStream fans the events of the bus out to api clients and keeps the most recent ones for resuming
+type EventStream struct {
+	mu          sync.Mutex
+	lastID      int64
+	lostBefore  int64   // events before this id were dropped by the bus and can't be replayed
+	buffer      []Event // ring of the last eventBufferSize events
+	next        int     // position of the next event in buffer
+	subscribers map[*Subscription]bool
+}
+
+// Subscription receives the events matching its filter on C. C is closed when the subscription ends,
+// either through Unsubscribe or because the subscriber fell too far behind
+type Subscription struct {
+	C      <-chan Event
+	ch     chan Event
+	filter EventFilter
+}
+
+func NewEventStream() *EventStream {
+	return &EventStream{subscribers: make(map[*Subscription]bool)}
+}
+
+// add buffers an event published on the bus and sends it to every matching subscriber
+func (es *EventStream) add(e Event) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	if es.lastID > 0 && e.ID > es.lastID+1 {
+		// the bus dropped events while the stream was behind. the clients missed them, so they are ended
+		// to resume, which tells them
+		es.lostBefore = e.ID
+		for sub := range es.subscribers {
+			es.drop(sub)
+		}
+	}
+	if e.ID > es.lastID {
+		es.lastID = e.ID
+	}
+
+	if len(es.buffer) < eventBufferSize {
+		es.buffer = append(es.buffer, e)
+	} else {
+		es.buffer[es.next] = e
+	}
+	es.next = (es.next + 1) % eventBufferSize
+
+	for sub := range es.subscribers {
+		if !sub.filter.Match(e) {
+			continue
+		}
+		select {
+		case sub.ch <- e:
+		default:
+			// a slow subscriber doesn't hold up the others, it can reconnect and resume from its last event
+			es.drop(sub)
+		}
+	}
+}
+
+// Subscribe starts a subscription. when lastID is not 0 the buffered events after it are returned for
+// replay, complete is false if some events after lastID are no longer buffered
+func (es *EventStream) Subscribe(filter EventFilter, lastID int64) (sub *Subscription, replay []Event, complete bool) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	ch := make(chan Event, subscriberQueueSize)
+	sub = &Subscription{C: ch, ch: ch, filter: filter}
+	es.subscribers[sub] = true
+
+	if lastID == 0 {
+		return sub, nil, true
+	}
+
+	events := es.ordered()
+	complete = lastID <= es.lastID && lastID >= es.lostBefore-1 && (len(events) == 0 || lastID >= events[0].ID-1)
+	for _, e := range events {
+		if e.ID > lastID && filter.Match(e) {
+			replay = append(replay, e)
+		}
+	}
+
+	return sub, replay, complete
+}
+
+// Unsubscribe ends a subscription
+func (es *EventStream) Unsubscribe(sub *Subscription) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	es.drop(sub)
+}
+
+func (es *EventStream) drop(sub *Subscription) {
+	if es.subscribers[sub] {
+		delete(es.subscribers, sub)
+		close(sub.ch)
+	}
+}
+
+// returns the buffered events, oldest first
+func (es *EventStream) ordered() []Event {
+	if len(es.buffer) < eventBufferSize {
+		return append([]Event{}, es.buffer...)
+	}
+
+	return append(append([]Event{}, es.buffer[es.next:]...), es.buffer[:es.next]...)
+}
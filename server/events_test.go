@@ -0,0 +1,24 @@
+package server
+
+import "testing"
+
+func TestEventStreamReportsDroppedEvents(t *testing.T) {
+	es := NewEventStream()
+	es.add(Event{ID: 1, Type: EventBotStarted})
+	es.add(Event{ID: 2, Type: EventBotStarted})
+
+	sub, _, _ := es.Subscribe(EventFilter{}, 0)
+
+	// the bus dropped events 3 and 4 for the stream
+	es.add(Event{ID: 5, Type: EventBotStarted})
+	if _, open := <-sub.C; open {
+		t.Error("expected the subscription to end when the stream missed events")
+	}
+
+	if _, replay, complete := es.Subscribe(EventFilter{}, 2); complete || len(replay) != 1 {
+		t.Errorf("resuming from before the dropped events: complete = %v, replay = %v", complete, replay)
+	}
+	if _, _, complete := es.Subscribe(EventFilter{}, 4); !complete {
+		t.Error("resuming from after the dropped events should be complete")
+	}
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	db "bot-api/db"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// tags and group names are lowercase and may contain digits, dots, hyphens and underscores
+var labelPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,63}$`)
+
+// GroupLaunchResult is the outcome of starting or stopping the bot of a single group member
+type GroupLaunchResult struct {
+	AccountID int    `json:"account_id"`
+	Username  string `json:"username"`
+	OK        bool   `json:"ok"`
+	PID       int    `json:"pid,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// NormalizeLabel lowercases a tag or group name and checks that it is valid
+func NormalizeLabel(label string) (string, error) {
+	l := strings.ToLower(strings.TrimSpace(label))
+	if !labelPattern.MatchString(l) {
+		return "", fmt.Errorf("invalid name %q: must be 1-64 lowercase letters, digits, dots, hyphens or underscores", label)
+	}
+
+	return l, nil
+}
+
+// StartGroup launches the script on every account in the group. accounts that fail to launch, e.g.
+// because they are already running, are reported without stopping the others
+func (s *Server) StartGroup(group string, script string, params []string) ([]GroupLaunchResult, error) {
+	accounts, err := s.DB.FindAccounts(db.AccountFilter{Group: group})
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]GroupLaunchResult, 0, len(accounts))
+	for _, acc := range accounts {
+		res := GroupLaunchResult{AccountID: acc.ID, Username: acc.Username}
+
+		bot, err := s.LaunchBot(acc, script, params)
+		if err != nil {
+			res.Error = err.Error()
+		} else {
+			res.OK = true
+			res.PID = bot.PID
+		}
+
+		results = append(results, res)
+	}
+
+	return results, nil
+}
+
+// StopGroup stops the bots of every account in the group
+func (s *Server) StopGroup(group string) ([]GroupLaunchResult, error) {
+	accounts, err := s.DB.FindAccounts(db.AccountFilter{Group: group})
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]GroupLaunchResult, 0, len(accounts))
+	for _, acc := range accounts {
+		res := GroupLaunchResult{AccountID: acc.ID, Username: acc.Username}
+		if s.StopBot(fmt.Sprint(acc.ID)) {
+			res.OK = true
+		} else {
+			res.Error = "bot not running"
+		}
+
+		results = append(results, res)
+	}
+
+	return results, nil
+}
@@ -0,0 +1,178 @@
+package server
+
+import (
+	db "bot-api/db"
+	"database/sql"
+	"log/slog"
+	"time"
+)
+
+// storeHeartbeatEvent is the storage subscriber of heartbeat events: it activates new accounts, records
+// the membership, levels, xp and loot reported by the heartbeat and stores the heartbeat itself
+func (s *Server) storeHeartbeatEvent(e Event) {
+	data := e.Data.(HeartbeatReceivedEvent)
+	hb, account := data.Heartbeat, data.Account
+
+	// the first heartbeat of a new account means it is in use
+	if account.Status == StatusNew {
+		if err := s.SetAccountStatus(account, StatusActive, "first heartbeat", "heartbeat"); err != nil {
+			slog.Error("error activating account", append(accountAttrs(account), "error", err)...)
+		} else {
+			account.Status = StatusActive
+		}
+	}
+
+	s.recordMembership(account, hb.Membership, e.Time)
+
+	s.updateLevels(account, hb.Stats)
+
+	activityID, err := s.DB.GetActiveActivityIDForAccount(account.ID)
+	if err != nil && err != sql.ErrNoRows {
+		slog.Error("error getting active activity", append(accountAttrs(account), "error", err)...)
+	}
+
+	// Store XP gained from heartbeat if present
+	if len(hb.GainedXP) > 0 && activityID != 0 {
+		for skill, xp := range hb.GainedXP {
+			if err := s.DB.UpsertActivityXP(activityID, skill, xp); err != nil {
+				slog.Error("error storing xp", append(accountAttrs(account), "activity_id", activityID, "skill", skill, "error", err)...)
+			}
+		}
+	}
+
+	// Store items and gp gained from heartbeat if present
+	if activityID != 0 {
+		for _, item := range hb.Items {
+			if err := s.DB.UpsertActivityItem(activityID, item); err != nil {
+				slog.Error("error storing item", append(accountAttrs(account), "activity_id", activityID, "item_id", item.ItemID, "error", err)...)
+			}
+		}
+		if hb.GPDelta != nil {
+			if err := s.DB.UpsertActivityGP(activityID, *hb.GPDelta); err != nil {
+				slog.Error("error storing gp delta", append(accountAttrs(account), "activity_id", activityID, "error", err)...)
+			}
+		}
+	}
+
+	if err := s.storeHeartbeat(hb, account.ID, activityID, data.StatusChanged); err != nil {
+		slog.Error("error storing heartbeat", append(accountAttrs(account), "activity_id", activityID, "error", err)...)
+	}
+
+	s.metrics.heartbeatStored(account, hb, e.Time)
+}
+
+// updateLevels stores the levels of an account and publishes a level.up event for every skill that went
+// up. skills without a previous level (0) are skipped, they are new to the server rather than gained
+func (s *Server) updateLevels(account db.Account, levels db.Levels) {
+	previous, ok := s.storedLevels[account.ID]
+	if !ok {
+		// first heartbeat of the account since the server started
+		var err error
+		if previous, err = s.DB.GetLevelsForAccount(account.ID); err != nil && err != sql.ErrNoRows {
+			slog.Error("error getting levels", append(accountAttrs(account), "error", err)...)
+			return
+		}
+	}
+
+	if err := s.DB.UpdateLevelsForAccount(account, levels); err != nil {
+		slog.Error("error updating levels", append(accountAttrs(account), "error", err)...)
+		return
+	}
+	s.storedLevels[account.ID] = levels
+
+	before := previous.Map()
+	for skill, level := range levels.Map() {
+		if from := before[skill]; from > 0 && level > from {
+			s.Bus.Publish(EventLevelUp, account.ID, LevelUpEvent{Username: account.Username, Skill: skill, From: from, To: level})
+		}
+	}
+}
+
+// storeHeartbeat persists a heartbeat, skipping it if the account already had one stored within the
+// configured sample interval. heartbeats with a changed status are always stored
+func (s *Server) storeHeartbeat(hb Heartbeat, accountID int, activityID int, statusChanged bool) error {
+	now := time.Now()
+
+	s.hbMu.Lock()
+	last, ok := s.lastStoredHeartbeat[accountID]
+	if ok && !statusChanged && now.Sub(last) < s.Config.HeartbeatSampleInterval {
+		s.hbMu.Unlock()
+		return nil
+	}
+	s.lastStoredHeartbeat[accountID] = now
+	s.hbMu.Unlock()
+
+	levels := hb.Stats
+	record := db.HeartbeatRecord{
+		AccountID:  accountID,
+		Status:     hb.Status,
+		PID:        hb.PID,
+		Levels:     &levels,
+		GainedXP:   hb.GainedXP,
+		ReceivedAt: now.Format(db.TimeFormat),
+	}
+	if activityID != 0 {
+		record.ActivityID = &activityID
+	}
+	if !hb.BotState.IsZero() {
+		state := hb.BotState
+		record.State = &state
+	}
+
+	return s.DB.InsertHeartbeat(record)
+}
+
+// maintainHeartbeats applies the heartbeat, quarantine and webhook delivery retention and the downsampling
+// settings, at most once per maintenance interval
+func (s *Server) maintainHeartbeats() {
+	now := time.Now()
+	if now.Sub(s.lastHeartbeatMaintenance) < s.Config.HeartbeatMaintenanceInterval {
+		return
+	}
+	s.lastHeartbeatMaintenance = now
+
+	if s.Config.HeartbeatRetention > 0 {
+		deleted, err := s.DB.DeleteHeartbeatsBefore(now.Add(-s.Config.HeartbeatRetention))
+		if err != nil {
+			slog.Error("error deleting expired heartbeats", "error", err)
+		} else if deleted > 0 {
+			slog.Info("deleted expired heartbeats", "deleted", deleted)
+		}
+	}
+
+	if s.Config.HeartbeatQuarantineRetention > 0 {
+		deleted, err := s.DB.DeleteQuarantinedHeartbeatsBefore(now.Add(-s.Config.HeartbeatQuarantineRetention))
+		if err != nil {
+			slog.Error("error deleting expired quarantined heartbeats", "error", err)
+		} else if deleted > 0 {
+			slog.Info("deleted expired quarantined heartbeats", "deleted", deleted)
+		}
+	}
+
+	if s.Config.HeartbeatDownsampleAfter > 0 && s.Config.HeartbeatDownsampleInterval > 0 {
+		deleted, err := s.DB.DownsampleHeartbeats(now.Add(-s.Config.HeartbeatDownsampleAfter), s.Config.HeartbeatDownsampleInterval)
+		if err != nil {
+			slog.Error("error downsampling heartbeats", "error", err)
+		} else if deleted > 0 {
+			slog.Info("downsampled heartbeats", "deleted", deleted)
+		}
+	}
+
+	if s.Config.WebhookDeliveryRetention > 0 {
+		deleted, err := s.DB.DeleteWebhookDeliveriesBefore(now.Add(-s.Config.WebhookDeliveryRetention))
+		if err != nil {
+			slog.Error("error deleting expired webhook deliveries", "error", err)
+		} else if deleted > 0 {
+			slog.Info("deleted expired webhook deliveries", "deleted", deleted)
+		}
+	}
+}
+
+// LatestHeartbeat returns the last heartbeat received from the bot with the given email since the server started
+func (s *Server) LatestHeartbeat(email string) (Heartbeat, bool) {
+	s.hbMu.Lock()
+	defer s.hbMu.Unlock()
+
+	hb, ok := s.LatestHeartbeats[email]
+	return hb, ok
+}
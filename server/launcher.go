@@ -0,0 +1,89 @@
+package server
+
+import (
+	b "bot-api/bot"
+	db "bot-api/db"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+var ErrBotAlreadyRunning = errors.New("bot with given ID is already running")
+
+var ErrAccountDeleted = errors.New("account is deleted")
+
+// LaunchBot starts a dreambot client running the script on the given account, records the activity and
+// registers the heartbeat token handed to the client. accounts that aren't in a runnable status are refused
+func (s *Server) LaunchBot(acc db.Account, script string, params []string) (b.Bot, error) {
+	if acc.DeletedAt != nil {
+		return b.Bot{}, ErrAccountDeleted
+	}
+	if !Runnable(acc.Status) {
+		return b.Bot{}, ErrAccountNotRunnable
+	}
+
+	bots, err := s.DB.GetActiveBots()
+	if err != nil {
+		return b.Bot{}, err
+	}
+
+	for _, running := range bots {
+		if running.ID == fmt.Sprint(acc.ID) {
+			return b.Bot{}, ErrBotAlreadyRunning
+		}
+	}
+
+	creds, hasCreds, err := s.GetCredentials(acc.ID)
+	if err != nil {
+		slog.Error("error reading credentials", append(accountAttrs(acc), "error", err)...)
+		return b.Bot{}, errors.New("could not read stored credentials")
+	}
+
+	token, err := NewHeartbeatToken()
+	if err != nil {
+		return b.Bot{}, err
+	}
+
+	var newBot b.Bot
+	newBot.ID = fmt.Sprint(acc.ID)
+	newBot.Username = acc.Username
+	newBot.Email = acc.Email
+	newBot.Status = "Stopped"
+	newBot.Script = script
+	newBot.Params = params
+	newBot.World = WorldType(acc, time.Now())
+	newBot.HeartbeatToken = token
+	if hasCreds {
+		newBot.Password = creds.Password
+	}
+	newBot.Start()
+
+	s.AddBot(newBot)
+	s.markSeen(acc.ID)
+
+	command := script
+	if len(params) > 0 {
+		command += " " + strings.Join(params, " ")
+	}
+	activityID, err := s.DB.InsertActivity(acc.ID, command, newBot.PID)
+	if err != nil {
+		return newBot, err
+	}
+
+	// the token can only be stored once the activity exists. without it every heartbeat of the bot would
+	// be rejected, so the client is stopped again
+	if err := s.RegisterHeartbeatToken(activityID, acc.ID, token); err != nil {
+		slog.Error("error registering heartbeat token, stopping the bot", append(newBot.LogAttrs(), "activity_id", activityID, "error", err)...)
+		s.stopBot(newBot.ID, db.ExitLaunchFailed)
+		return b.Bot{}, fmt.Errorf("registering heartbeat token: %w", err)
+	}
+
+	s.metrics.botLaunched(acc, script)
+	s.Bus.Publish(EventBotStarted, acc.ID, botStateEvent(newBot, ""))
+
+	slog.Info("bot launched", append(newBot.LogAttrs(), "activity_id", activityID)...)
+
+	return newBot, nil
+}
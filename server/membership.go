@@ -0,0 +1,105 @@
+package server
+
+import (
+	db "bot-api/db"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// world types passed to the client's -world flag
+const (
+	WorldMembers = "members"
+	WorldF2P     = "f2p"
+)
+
+// date format of membership expiry dates
+const dateFormat = "2006-01-02"
+
+// MembershipReport is the membership status optionally reported by heartbeats
+type MembershipReport struct {
+	Member   bool `json:"member"`
+	DaysLeft *int `json:"days_left,omitempty"` // days of membership remaining, as shown in game
+}
+
+// ValidateMembershipExpiry checks that an expiry date is formatted as YYYY-MM-DD
+func ValidateMembershipExpiry(date string) error {
+	if _, err := time.Parse(dateFormat, date); err != nil {
+		return fmt.Errorf("invalid date %q, expected YYYY-MM-DD", date)
+	}
+
+	return nil
+}
+
+// IsMember reports whether an account has membership on the day of now. the expiry date is the last day
+// of membership, members without a known expiry date are assumed to still be members
+func IsMember(acc db.Account, now time.Time) bool {
+	if !acc.Member {
+		return false
+	}
+	if acc.MembershipExpires == nil {
+		return true
+	}
+
+	// dates in this format compare chronologically as strings
+	return now.Format(dateFormat) <= *acc.MembershipExpires
+}
+
+// WorldType returns the kind of world an account should be launched on
+func WorldType(acc db.Account, now time.Time) string {
+	if IsMember(acc, now) {
+		return WorldMembers
+	}
+
+	return WorldF2P
+}
+
+// SetMembership stores the membership of an account. expires is a YYYY-MM-DD date, or nil if unknown
+func (s *Server) SetMembership(accountID int, member bool, expires *string) error {
+	if expires != nil {
+		if err := ValidateMembershipExpiry(*expires); err != nil {
+			return err
+		}
+	}
+
+	return s.DB.UpdateAccountMembership(accountID, member, expires)
+}
+
+// ExpiringMemberships returns the member accounts whose membership runs out within the given number of
+// days, including those that have already expired
+func (s *Server) ExpiringMemberships(days int, now time.Time) ([]db.Account, error) {
+	return s.DB.GetExpiringMemberships(now.AddDate(0, 0, days).Format(dateFormat))
+}
+
+// recordMembership stores the membership reported by a heartbeat if it differs from what is known. the
+// expiry date is derived from the days left, so it is only updated when the reported date changes
+func (s *Server) recordMembership(acc db.Account, report *MembershipReport, now time.Time) {
+	if report == nil {
+		return
+	}
+
+	var expires *string
+	if report.Member && report.DaysLeft != nil {
+		date := now.AddDate(0, 0, *report.DaysLeft).Format(dateFormat)
+		expires = &date
+	} else if report.Member {
+		// keep the known expiry date if the client couldn't read the days left
+		expires = acc.MembershipExpires
+	}
+
+	if acc.Member == report.Member && sameDate(acc.MembershipExpires, expires) {
+		return
+	}
+
+	if err := s.DB.UpdateAccountMembership(acc.ID, report.Member, expires); err != nil {
+		slog.Error("error updating membership", append(accountAttrs(acc), "error", err)...)
+	}
+}
+
+func sameDate(a *string, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return *a == *b
+}
@@ -0,0 +1,253 @@
+package server
+
+import (
+	db "bot-api/db"
+	"bot-api/metrics"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how accounts are labeled in the metrics, see Config.MetricsAccountLabel. every labeled account is a
+// series of its own, so the label is off unless the fleet is small enough for the time series database
+const (
+	MetricsAccountNone     = "none"
+	MetricsAccountID       = "id"
+	MetricsAccountUsername = "username"
+)
+
+// account label value of the accounts over Config.MetricsAccountLimit
+const otherAccounts = "other"
+
+// prefix of the names of the api's metrics
+const metricsNamespace = "botapi_"
+
+// reasons a heartbeat is rejected, see RecordHeartbeatRejected
+const (
+	HeartbeatMalformed       = "malformed"       // the body isn't a heartbeat
+	HeartbeatInvalid         = "invalid"         // the heartbeat failed validation
+	HeartbeatUnauthenticated = "unauthenticated" // no valid token
+	HeartbeatForbidden       = "forbidden"       // the token belongs to another account
+)
+
+// buckets of heartbeat_latency_seconds, from receiving a heartbeat until it is stored
+var heartbeatLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// serverMetrics are the fleet metrics kept by the server, registered on Server.Metrics
+type serverMetrics struct {
+	accountLabel string
+	accountLimit int
+
+	bots               *metrics.GaugeVec
+	heartbeatsReceived *metrics.CounterVec
+	heartbeatsRejected *metrics.CounterVec
+	heartbeatLatency   *metrics.HistogramVec
+	launches           *metrics.CounterVec
+	crashes            *metrics.CounterVec
+	restarts           *metrics.CounterVec
+	xpGained           *metrics.CounterVec
+	dbQueries          *metrics.HistogramVec
+	dbErrors           *metrics.CounterVec
+
+	mu sync.Mutex
+
+	// account label values given out so far, at most accountLimit
+	accounts map[string]bool
+
+	// accounts whose bot crashed and wasn't launched since, their next launch is a restart
+	crashed map[int]bool
+
+	// map of account id to the xp per skill last reported for the current session, to count the xp gained
+	// between two heartbeats
+	sessionXP map[int]map[string]int
+}
+
+func newServerMetrics(reg *metrics.Registry, config Config) *serverMetrics {
+	m := &serverMetrics{
+		accountLabel: config.MetricsAccountLabel,
+		accountLimit: config.MetricsAccountLimit,
+		accounts:     make(map[string]bool),
+		crashed:      make(map[int]bool),
+		sessionXP:    make(map[int]map[string]int),
+	}
+	switch m.accountLabel {
+	case MetricsAccountNone, MetricsAccountID, MetricsAccountUsername:
+	default:
+		slog.Warn("invalid METRICS_ACCOUNT_LABEL, accounts are not labeled", "value", m.accountLabel)
+		m.accountLabel = MetricsAccountNone
+	}
+
+	m.bots = reg.Gauge(metricsNamespace+"bots", "Running bots by state: running or unresponsive.", "state")
+	m.heartbeatsReceived = reg.Counter(metricsNamespace+"heartbeats_received_total", "Authenticated heartbeats received.", m.withAccount()...)
+	m.heartbeatsRejected = reg.Counter(metricsNamespace+"heartbeats_rejected_total", "Heartbeats rejected by reason: malformed, invalid, unauthenticated or forbidden.", "reason")
+	m.heartbeatLatency = reg.Histogram(metricsNamespace+"heartbeat_latency_seconds", "Time from receiving a heartbeat until it is stored.", heartbeatLatencyBuckets)
+	m.launches = reg.Counter(metricsNamespace+"bot_launches_total", "Bots launched by script.", m.withAccount("script")...)
+	m.crashes = reg.Counter(metricsNamespace+"bot_crashes_total", "Bots whose client exited without being stopped, by script.", m.withAccount("script")...)
+	m.restarts = reg.Counter(metricsNamespace+"bot_restarts_total", "Launches of accounts whose previous bot crashed, by script.", m.withAccount("script")...)
+	m.xpGained = reg.Counter(metricsNamespace+"xp_gained_total", "XP gained by skill, as reported by heartbeats.", m.withAccount("skill")...)
+	m.dbQueries = reg.Histogram(metricsNamespace+"db_query_duration_seconds", "Time the database took to answer, by the function running the statement.", metrics.DefaultBuckets, "query")
+	m.dbErrors = reg.Counter(metricsNamespace+"db_query_errors_total", "Statements that failed, by the function running them.", "query")
+
+	return m
+}
+
+// registerMetrics adds the metrics read from the state of the server and the process at scrape time
+func (s *Server) registerMetrics() {
+	s.Metrics.CollectGauge(metricsNamespace+"bus_queued_events", "Events waiting in the queue of a bus subscriber.", func(emit metrics.Emit) {
+		for _, st := range s.Bus.Stats() {
+			emit(float64(st.Queued), st.Name)
+		}
+	}, "subscriber")
+	s.Metrics.CollectCounter(metricsNamespace+"bus_events_total", "Events of a bus subscriber by outcome: handled, dropped or failed.", func(emit metrics.Emit) {
+		for _, st := range s.Bus.Stats() {
+			emit(float64(st.Handled), st.Name, "handled")
+			emit(float64(st.Dropped), st.Name, "dropped")
+			emit(float64(st.Failed), st.Name, "failed")
+		}
+	}, "subscriber", "outcome")
+	s.Metrics.CollectCounter(metricsNamespace+"bus_publish_blocked_total", "Publishes that waited for room in the queue of a bus subscriber.", func(emit metrics.Emit) {
+		for _, st := range s.Bus.Stats() {
+			emit(float64(st.Blocked), st.Name)
+		}
+	}, "subscriber")
+	s.Metrics.CollectCounter(metricsNamespace+"bus_publish_blocked_seconds_total", "Time publishers waited for room in the queue of a bus subscriber.", func(emit metrics.Emit) {
+		for _, st := range s.Bus.Stats() {
+			emit(st.BlockedSeconds, st.Name)
+		}
+	}, "subscriber")
+
+	started := float64(time.Now().Unix())
+	s.Metrics.CollectGauge("process_start_time_seconds", "Start time of the process since the unix epoch.", func(emit metrics.Emit) {
+		emit(started)
+	})
+	s.Metrics.CollectGauge("go_goroutines", "Number of goroutines that currently exist.", func(emit metrics.Emit) {
+		emit(float64(runtime.NumGoroutine()))
+	})
+}
+
+// withAccount appends the account label to the given labels if accounts are labeled
+func (m *serverMetrics) withAccount(labels ...string) []string {
+	if m.accountLabel == MetricsAccountNone {
+		return labels
+	}
+
+	return append(labels, "account")
+}
+
+// labels appends the account label value of an account to the given values if accounts are labeled.
+// accounts over the limit share the "other" value
+func (m *serverMetrics) labels(accountID int, username string, values ...string) []string {
+	if m.accountLabel == MetricsAccountNone {
+		return values
+	}
+
+	account := fmt.Sprint(accountID)
+	if m.accountLabel == MetricsAccountUsername && username != "" {
+		account = username
+	}
+
+	m.mu.Lock()
+	if !m.accounts[account] {
+		if len(m.accounts) >= m.accountLimit {
+			account = otherAccounts
+		} else {
+			m.accounts[account] = true
+		}
+	}
+	m.mu.Unlock()
+
+	return append(values, account)
+}
+
+// scriptLabel is the script of a bot, "unknown" for bots found running that were launched elsewhere
+func scriptLabel(script string) string {
+	if script = strings.TrimSpace(script); script == "" {
+		return "unknown"
+	}
+
+	return script
+}
+
+// observeQuery records a statement run on the database, see db.Open
+func (m *serverMetrics) observeQuery(caller string, elapsed time.Duration, err error) {
+	m.dbQueries.Observe(elapsed.Seconds(), caller)
+	if err != nil {
+		m.dbErrors.Inc(caller)
+	}
+}
+
+func (m *serverMetrics) botLaunched(acc db.Account, script string) {
+	m.mu.Lock()
+	restart := m.crashed[acc.ID]
+	delete(m.crashed, acc.ID)
+	delete(m.sessionXP, acc.ID)
+	m.mu.Unlock()
+
+	labels := m.labels(acc.ID, acc.Username, scriptLabel(script))
+	m.launches.Inc(labels...)
+	if restart {
+		m.restarts.Inc(labels...)
+	}
+}
+
+func (m *serverMetrics) botCrashed(accountID int, username string, script string) {
+	m.mu.Lock()
+	m.crashed[accountID] = true
+	delete(m.sessionXP, accountID)
+	m.mu.Unlock()
+
+	m.crashes.Inc(m.labels(accountID, username, scriptLabel(script))...)
+}
+
+func (m *serverMetrics) heartbeatReceived(acc db.Account) {
+	m.heartbeatsReceived.Inc(m.labels(acc.ID, acc.Username)...)
+}
+
+// heartbeatStored records how long storing a heartbeat took since it was received and counts the xp
+// gained since the previous heartbeat of the session
+func (m *serverMetrics) heartbeatStored(acc db.Account, hb Heartbeat, received time.Time) {
+	m.heartbeatLatency.Observe(time.Since(received).Seconds())
+
+	m.mu.Lock()
+	previous := m.sessionXP[acc.ID]
+	gained := make(map[string]int, len(hb.GainedXP))
+	for skill, xp := range hb.GainedXP {
+		// less than before means the script started a new session without a launch through the api
+		if delta := xp - previous[skill]; delta >= 0 {
+			gained[skill] = delta
+		} else {
+			gained[skill] = xp
+		}
+	}
+	m.sessionXP[acc.ID] = hb.GainedXP
+	m.mu.Unlock()
+
+	for skill, xp := range gained {
+		if xp > 0 {
+			m.xpGained.Add(float64(xp), m.labels(acc.ID, acc.Username, skill)...)
+		}
+	}
+}
+
+// RecordHeartbeatRejected counts a heartbeat that was turned away, reason is one of the Heartbeat*
+// rejection reasons
+func (s *Server) RecordHeartbeatRejected(reason string) {
+	s.metrics.heartbeatsRejected.Inc(reason)
+}
+
+// updateBotMetrics sets the number of running bots by state from the bots found running by the monitor
+func (s *Server) updateBotMetrics(running int) {
+	s.hbMu.Lock()
+	unresponsive := len(s.unresponsive)
+	s.hbMu.Unlock()
+
+	if unresponsive > running {
+		// bots can turn unresponsive between the monitor's query and now
+		unresponsive = running
+	}
+	s.metrics.bots.Set(float64(running-unresponsive), "running")
+	s.metrics.bots.Set(float64(unresponsive), "unresponsive")
+}
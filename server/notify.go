@@ -0,0 +1,319 @@
+package server
+
+import (
+	db "bot-api/db"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// formats of the payloads of a webhook
+const (
+	WebhookFormatJSON    = "json"    // the event itself
+	WebhookFormatDiscord = "discord" // a message with an embed, for a Discord incoming webhook
+	WebhookFormatSlack   = "slack"   // a message with an attachment, for a Slack incoming webhook
+)
+
+// WebhookFormats lists every webhook format
+var WebhookFormats = []string{WebhookFormatJSON, WebhookFormatDiscord, WebhookFormatSlack}
+
+// name the chat messages are posted as
+const notificationSender = "osrs-bot-api"
+
+// skill icons of the osrs wiki, named after the skill except for runecrafting
+const skillIconURL = "https://oldschool.runescape.wiki/images/%s_icon.png"
+
+var skillIconNames = map[string]string{"runecrafting": "Runecraft"}
+
+// colors of the chat messages
+const (
+	colorInfo    = 0x3498db
+	colorSuccess = 0x2ecc71
+	colorWarning = 0xf39c12
+	colorDanger  = 0xe74c3c
+	colorMuted   = 0x95a5a6
+)
+
+var severityColors = map[string]int{SeverityInfo: colorInfo, SeverityWarning: colorWarning, SeverityCritical: colorDanger}
+
+// notification is an event described for people, rendered as a chat message by discordMessage and
+// slackMessage
+type notification struct {
+	Title  string
+	Text   string // in the markup of the chat service
+	Color  int
+	Icon   string // url of a thumbnail, e.g. the icon of the skill of a level-up
+	Fields []notificationField
+	Footer string
+	Time   time.Time
+}
+
+type notificationField struct {
+	Name  string
+	Value string // in the markup of the chat service
+}
+
+// markup escapes names and values for a chat service and makes them bold
+type markup struct {
+	escape func(string) string
+	bold   string
+}
+
+func (m markup) strong(s string) string {
+	return m.bold + m.escape(s) + m.bold
+}
+
+// webhookPayload renders the body sent to a webhook for an event. suppressed is the number of events
+// held back by the rate limit since the previous delivery, mentioned in chat messages
+func webhookPayload(format string, e Event, suppressed int) ([]byte, error) {
+	switch format {
+	case WebhookFormatDiscord:
+		return json.Marshal(discordMessage(e, suppressed))
+	case WebhookFormatSlack:
+		return json.Marshal(slackMessage(e, suppressed))
+	}
+
+	return json.Marshal(e)
+}
+
+// describeEvent turns an event into a notification, escaping every name and value with m
+func describeEvent(e Event, suppressed int, m markup) notification {
+	n := notification{Title: e.Type, Color: colorInfo, Time: e.Time}
+	field := func(name string, value string) {
+		if value == "" {
+			value = "none"
+		}
+		n.Fields = append(n.Fields, notificationField{name, m.escape(value)})
+	}
+
+	switch data := e.Data.(type) {
+	case BotStateEvent:
+		account := m.strong(accountName(data.Username, data.Email))
+		switch e.Type {
+		case EventBotStarted:
+			n.Title, n.Color = "Bot started", colorSuccess
+			n.Text = account + " was launched"
+		case EventBotStopped:
+			n.Title, n.Color = "Bot stopped", colorMuted
+			n.Text = account + " was stopped"
+		case EventBotCrashed:
+			n.Title, n.Color = "Bot crashed", colorDanger
+			n.Text = "The client of " + account + " is gone without being stopped"
+		}
+		field("Script", data.Script)
+		if data.PID != 0 {
+			field("PID", fmt.Sprint(data.PID))
+		}
+		if data.Reason != "" {
+			field("Reason", exitReasonText(data.Reason))
+		}
+	case BotUnresponsiveEvent:
+		n.Title, n.Color = "Bot unresponsive", colorWarning
+		n.Text = fmt.Sprintf("%s has not sent a heartbeat for %s", m.strong(accountName(data.Username, data.Email)), e.Time.Sub(data.LastSeen).Round(time.Second))
+		field("Script", data.Script)
+		field("Last seen", data.LastSeen.UTC().Format(time.RFC1123))
+	case BotStatusEvent:
+		n.Title = "Bot status"
+		n.Text = m.strong(data.Email) + " is now: " + m.escape(data.To)
+		field("Previous status", data.From)
+	case LevelUpEvent:
+		n.Title, n.Color = "Level up", colorSuccess
+		n.Text = fmt.Sprintf("%s reached level %d %s", m.strong(data.Username), data.To, skillName(data.Skill))
+		n.Icon = skillIcon(data.Skill)
+		field("Skill", skillName(data.Skill))
+		field("Level", fmt.Sprintf("%d → %d", data.From, data.To))
+	case AccountStatusEvent:
+		n.Title = "Account status"
+		n.Text = fmt.Sprintf("%s went from %s to %s", m.strong(data.Username), m.escape(data.From), m.strong(data.To))
+		switch data.To {
+		case StatusBanned:
+			n.Color = colorDanger
+		case StatusLocked:
+			n.Color = colorWarning
+		}
+		if data.Reason != "" {
+			field("Reason", data.Reason)
+		}
+		if data.Actor != "" {
+			field("By", data.Actor)
+		}
+	case AlertEvent:
+		n.Text = m.escape(data.Message)
+		field("Severity", data.Severity)
+		field("Subject", data.Subject)
+		if e.Type == EventAlertResolved {
+			n.Title, n.Color = "Resolved: "+data.Rule, colorSuccess
+			field("Fired at", data.FiredAt)
+			break
+		}
+		n.Title, n.Color = "Alert: "+data.Rule, severityColors[data.Severity]
+	default:
+		n.Text = fmt.Sprintf("Event %d", e.ID)
+	}
+
+	n.Footer = fmt.Sprintf("%s · event %d", e.Type, e.ID)
+	if e.AccountID != 0 {
+		n.Footer = fmt.Sprintf("account %d · %s", e.AccountID, n.Footer)
+	}
+	if suppressed > 0 {
+		n.Footer += fmt.Sprintf(" · %d earlier notifications held back by the rate limit", suppressed)
+	}
+
+	return n
+}
+
+func accountName(username string, email string) string {
+	if username != "" {
+		return username
+	}
+
+	return email
+}
+
+func exitReasonText(reason string) string {
+	switch reason {
+	case db.ExitStopped:
+		return "stopped through the api"
+	case db.ExitExited:
+		return "the client process exited"
+	case db.ExitAccountDeleted:
+		return "the account was deleted"
+	case db.ExitLaunchFailed:
+		return "the launch failed"
+	}
+
+	return reason
+}
+
+func skillName(skill string) string {
+	if skill == "" {
+		return skill
+	}
+
+	return strings.ToUpper(skill[:1]) + skill[1:]
+}
+
+func skillIcon(skill string) string {
+	name, ok := skillIconNames[skill]
+	if !ok {
+		name = skillName(skill)
+	}
+
+	return fmt.Sprintf(skillIconURL, name)
+}
+
+// discord incoming webhook body, see https://discord.com/developers/docs/resources/webhook#execute-webhook
+type discordWebhookMessage struct {
+	Username        string                 `json:"username"`
+	Embeds          []discordEmbed         `json:"embeds"`
+	AllowedMentions discordAllowedMentions `json:"allowed_mentions"`
+}
+
+type discordEmbed struct {
+	Title       string              `json:"title"`
+	Description string              `json:"description"`
+	Color       int                 `json:"color"`
+	Fields      []discordEmbedField `json:"fields,omitempty"`
+	Thumbnail   *discordImage       `json:"thumbnail,omitempty"`
+	Footer      discordFooter       `json:"footer"`
+	Timestamp   string              `json:"timestamp"`
+}
+
+type discordEmbedField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+type discordImage struct {
+	URL string `json:"url"`
+}
+
+type discordFooter struct {
+	Text string `json:"text"`
+}
+
+// an empty parse list keeps names that look like mentions from pinging anyone
+type discordAllowedMentions struct {
+	Parse []string `json:"parse"`
+}
+
+var discordMarkup = markup{escape: strings.NewReplacer(`\`, `\\`, "*", `\*`, "_", `\_`, "~", `\~`, "`", "\\`", "|", `\|`, ">", `\>`).Replace, bold: "**"}
+
+func discordMessage(e Event, suppressed int) discordWebhookMessage {
+	n := describeEvent(e, suppressed, discordMarkup)
+
+	embed := discordEmbed{
+		Title:       n.Title,
+		Description: n.Text,
+		Color:       n.Color,
+		Footer:      discordFooter{Text: n.Footer},
+		Timestamp:   n.Time.UTC().Format(time.RFC3339),
+	}
+	for _, f := range n.Fields {
+		embed.Fields = append(embed.Fields, discordEmbedField{Name: f.Name, Value: f.Value, Inline: true})
+	}
+	if n.Icon != "" {
+		embed.Thumbnail = &discordImage{URL: n.Icon}
+	}
+
+	return discordWebhookMessage{
+		Username:        notificationSender,
+		Embeds:          []discordEmbed{embed},
+		AllowedMentions: discordAllowedMentions{Parse: []string{}},
+	}
+}
+
+// slack incoming webhook body, see https://api.slack.com/messaging/webhooks. the text is shown above the
+// attachment, which carries the color bar
+type slackWebhookMessage struct {
+	Username    string            `json:"username"`
+	Text        string            `json:"text"`
+	Attachments []slackAttachment `json:"attachments"`
+}
+
+type slackAttachment struct {
+	Color  string       `json:"color"`
+	Blocks []slackBlock `json:"blocks"`
+}
+
+type slackBlock struct {
+	Type      string      `json:"type"`
+	Text      *slackText  `json:"text,omitempty"`
+	Fields    []slackText `json:"fields,omitempty"`
+	Accessory *slackImage `json:"accessory,omitempty"`
+	Elements  []slackText `json:"elements,omitempty"`
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type slackImage struct {
+	Type     string `json:"type"`
+	ImageURL string `json:"image_url"`
+	AltText  string `json:"alt_text"`
+}
+
+var slackMarkup = markup{escape: strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace, bold: "*"}
+
+func slackMessage(e Event, suppressed int) slackWebhookMessage {
+	n := describeEvent(e, suppressed, slackMarkup)
+
+	section := slackBlock{Type: "section", Text: &slackText{"mrkdwn", n.Text}}
+	for _, f := range n.Fields {
+		section.Fields = append(section.Fields, slackText{"mrkdwn", "*" + f.Name + "*\n" + f.Value})
+	}
+	if n.Icon != "" {
+		section.Accessory = &slackImage{Type: "image", ImageURL: n.Icon, AltText: n.Title}
+	}
+	context := slackBlock{Type: "context", Elements: []slackText{{"mrkdwn", n.Footer}}}
+
+	return slackWebhookMessage{
+		Username:    notificationSender,
+		Text:        "*" + n.Title + "*",
+		Attachments: []slackAttachment{{Color: fmt.Sprintf("#%06x", n.Color), Blocks: []slackBlock{section, context}}},
+	}
+}
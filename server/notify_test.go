@@ -0,0 +1,302 @@
+package server
+
+import (
+	db "bot-api/db"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// standIn records the bodies posted to a fake chat service, answering like the real one
+type standIn struct {
+	*httptest.Server
+	mu     sync.Mutex
+	bodies [][]byte
+}
+
+func newStandIn(t *testing.T, answer func(w http.ResponseWriter, body []byte)) *standIn {
+	si := &standIn{}
+	si.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "expected a json POST", http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+
+		si.mu.Lock()
+		si.bodies = append(si.bodies, body)
+		si.mu.Unlock()
+
+		answer(w, body)
+	}))
+	t.Cleanup(si.Close)
+
+	return si
+}
+
+// discordStandIn checks the limits of an execute webhook request and answers 204 like discord does
+// without ?wait=true
+func discordStandIn(t *testing.T) *standIn {
+	return newStandIn(t, func(w http.ResponseWriter, body []byte) {
+		var msg discordWebhookMessage
+		if err := json.Unmarshal(body, &msg); err != nil || len(msg.Embeds) == 0 || len(msg.Embeds) > 10 {
+			http.Error(w, `{"message": "Cannot send an empty message", "code": 50006}`, http.StatusBadRequest)
+			return
+		}
+		for _, e := range msg.Embeds {
+			if len(e.Title) > 256 || len(e.Description) > 4096 || len(e.Fields) > 25 {
+				http.Error(w, `{"message": "Invalid Form Body", "code": 50035}`, http.StatusBadRequest)
+				return
+			}
+			for _, f := range e.Fields {
+				if f.Name == "" || f.Value == "" {
+					http.Error(w, `{"message": "Invalid Form Body", "code": 50035}`, http.StatusBadRequest)
+					return
+				}
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// slackStandIn answers "ok" like a slack incoming webhook, or invalid_payload for a message without text
+func slackStandIn(t *testing.T) *standIn {
+	return newStandIn(t, func(w http.ResponseWriter, body []byte) {
+		var msg slackWebhookMessage
+		if err := json.Unmarshal(body, &msg); err != nil || msg.Text == "" {
+			http.Error(w, "invalid_payload", http.StatusBadRequest)
+			return
+		}
+		for _, a := range msg.Attachments {
+			for _, b := range a.Blocks {
+				if b.Type == "section" && len(b.Fields) > 10 {
+					http.Error(w, "invalid_blocks", http.StatusBadRequest)
+					return
+				}
+			}
+		}
+		io.WriteString(w, "ok")
+	})
+}
+
+// send renders an event for a webhook and posts it to the stand-in
+func send(t *testing.T, si *standIn, format string, e Event, suppressed int) {
+	t.Helper()
+
+	payload, err := webhookPayload(format, e, suppressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := db.Webhook{ID: 1, URL: si.URL, Format: format, Secret: "secret"}
+	if status, err := postWebhook(w, db.WebhookDelivery{ID: e.ID, EventType: e.Type, Payload: payload}, time.Now()); err != nil {
+		t.Fatalf("%s %s: status %d: %v", format, e.Type, status, err)
+	}
+}
+
+var testEventTime = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func testEvents() []Event {
+	state := BotStateEvent{Email: "zezima@example.com", Username: "Ze_zima", Script: "MotherlodeMiner", PID: 4242}
+	crashed := state
+	crashed.Reason = db.ExitExited
+
+	return []Event{
+		{ID: 1, Type: EventBotStarted, Time: testEventTime, AccountID: 7, Data: state},
+		{ID: 2, Type: EventBotCrashed, Time: testEventTime, AccountID: 7, Data: crashed},
+		{ID: 3, Type: EventBotUnresponsive, Time: testEventTime, AccountID: 7, Data: BotUnresponsiveEvent{Email: state.Email, Username: state.Username, LastSeen: testEventTime.Add(-6 * time.Minute)}},
+		{ID: 4, Type: EventBotStatus, Time: testEventTime, AccountID: 7, Data: BotStatusEvent{Email: state.Email, From: "Mining", To: "Banking <ore>"}},
+		{ID: 5, Type: EventLevelUp, Time: testEventTime, AccountID: 7, Data: LevelUpEvent{Username: "Ze_zima", Skill: "runecrafting", From: 43, To: 44}},
+		{ID: 6, Type: EventAccountStatus, Time: testEventTime, AccountID: 7, Data: AccountStatusEvent{Username: "Ze_zima", From: StatusActive, To: StatusBanned, Reason: "ban wave", Actor: "ops"}},
+	}
+}
+
+func TestDiscordNotifications(t *testing.T) {
+	si := discordStandIn(t)
+	for _, e := range testEvents() {
+		send(t, si, WebhookFormatDiscord, e, 0)
+	}
+
+	var crash, levelUp discordWebhookMessage
+	json.Unmarshal(si.bodies[1], &crash)
+	json.Unmarshal(si.bodies[4], &levelUp)
+
+	embed := crash.Embeds[0]
+	if embed.Title != "Bot crashed" || embed.Color != colorDanger {
+		t.Errorf("crash embed has title %q and color %x", embed.Title, embed.Color)
+	}
+	if !strings.Contains(embed.Description, `**Ze\_zima**`) {
+		t.Errorf("crash description doesn't contain the escaped account name: %q", embed.Description)
+	}
+	if !hasDiscordField(embed, "Reason", "the client process exited") || !hasDiscordField(embed, "Script", "MotherlodeMiner") {
+		t.Errorf("crash embed lacks the script or reason: %+v", embed.Fields)
+	}
+	if embed.Timestamp != "2024-05-01T12:00:00Z" || embed.Footer.Text != "account 7 · bot.crashed · event 2" {
+		t.Errorf("crash embed has timestamp %q and footer %q", embed.Timestamp, embed.Footer.Text)
+	}
+	if crash.AllowedMentions.Parse == nil || len(crash.AllowedMentions.Parse) != 0 {
+		t.Errorf("mentions are not disabled: %+v", crash.AllowedMentions)
+	}
+
+	embed = levelUp.Embeds[0]
+	if embed.Thumbnail == nil || embed.Thumbnail.URL != "https://oldschool.runescape.wiki/images/Runecraft_icon.png" {
+		t.Errorf("level-up embed has thumbnail %+v", embed.Thumbnail)
+	}
+	if !strings.Contains(embed.Description, "reached level 44 Runecrafting") || !hasDiscordField(embed, "Level", "43 → 44") {
+		t.Errorf("level-up embed is %+v", embed)
+	}
+}
+
+func hasDiscordField(e discordEmbed, name string, value string) bool {
+	for _, f := range e.Fields {
+		if f.Name == name && f.Value == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestSlackNotifications(t *testing.T) {
+	si := slackStandIn(t)
+	for _, e := range testEvents() {
+		send(t, si, WebhookFormatSlack, e, 0)
+	}
+
+	var status, banned, levelUp slackWebhookMessage
+	json.Unmarshal(si.bodies[3], &status)
+	json.Unmarshal(si.bodies[5], &banned)
+	json.Unmarshal(si.bodies[4], &levelUp)
+
+	if section := status.Attachments[0].Blocks[0]; !strings.Contains(section.Text.Text, "Banking &lt;ore&gt;") {
+		t.Errorf("status text isn't escaped: %q", section.Text.Text)
+	}
+
+	if banned.Text != "*Account status*" || banned.Attachments[0].Color != "#e74c3c" {
+		t.Errorf("ban message has text %q and color %s", banned.Text, banned.Attachments[0].Color)
+	}
+	section := banned.Attachments[0].Blocks[0]
+	if section.Text.Text != "*Ze_zima* went from active to *banned*" {
+		t.Errorf("ban section text is %q", section.Text.Text)
+	}
+	if len(section.Fields) != 2 || section.Fields[0].Text != "*Reason*\nban wave" || section.Fields[1].Text != "*By*\nops" {
+		t.Errorf("ban section fields are %+v", section.Fields)
+	}
+
+	if accessory := levelUp.Attachments[0].Blocks[0].Accessory; accessory == nil || accessory.Type != "image" || !strings.HasSuffix(accessory.ImageURL, "/Runecraft_icon.png") {
+		t.Errorf("level-up accessory is %+v", accessory)
+	}
+}
+
+func TestWebhookPayloadMentionsSuppressed(t *testing.T) {
+	e := testEvents()[1]
+
+	for _, format := range []string{WebhookFormatDiscord, WebhookFormatSlack} {
+		payload, err := webhookPayload(format, e, 12)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(payload), "12 earlier notifications held back by the rate limit") {
+			t.Errorf("%s payload doesn't mention the suppressed notifications: %s", format, payload)
+		}
+	}
+
+	// json webhooks get the event unchanged
+	payload, _ := webhookPayload(WebhookFormatJSON, e, 12)
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(payload, &decoded); err != nil || decoded["type"] != EventBotCrashed || decoded["id"] != float64(2) {
+		t.Errorf("json payload is %s", payload)
+	}
+}
+
+func TestWebhookRateLimit(t *testing.T) {
+	var l webhookLimiter
+	now := testEventTime
+
+	// a crash loop: ten crashes within a minute on a webhook limited to 3 per minute
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		if ok, _ := l.allow(1, 3, now.Add(time.Duration(i)*time.Second)); ok {
+			allowed++
+		}
+	}
+	if allowed != 3 {
+		t.Errorf("%d of 10 events were allowed, want 3", allowed)
+	}
+
+	// other webhooks have their own window
+	if ok, _ := l.allow(2, 3, now); !ok {
+		t.Error("the limit of one webhook held back another")
+	}
+
+	// the next window sends again and reports what was held back
+	ok, suppressed := l.allow(1, 3, now.Add(time.Minute))
+	if !ok || suppressed != 7 {
+		t.Errorf("next window: allowed %v with %d suppressed, want true with 7", ok, suppressed)
+	}
+	if _, suppressed := l.allow(1, 3, now.Add(time.Minute+time.Second)); suppressed != 0 {
+		t.Errorf("suppressed events were reported twice")
+	}
+
+	// no limit
+	for i := 0; i < 100; i++ {
+		if ok, _ := l.allow(3, 0, now); !ok {
+			t.Fatal("an unlimited webhook was limited")
+		}
+	}
+}
+
+func TestWebhookRouting(t *testing.T) {
+	tests := []struct {
+		name      string
+		w         db.Webhook
+		accountID int
+		groups    []string
+		want      bool
+	}{
+		{"no rules", db.Webhook{}, 7, nil, true},
+		{"no rules, no account", db.Webhook{}, 0, nil, true},
+		{"account listed", db.Webhook{AccountIDs: []int{3, 7}}, 7, nil, true},
+		{"account not listed", db.Webhook{AccountIDs: []int{3}}, 7, []string{"miners"}, false},
+		{"group member", db.Webhook{Groups: []string{"miners"}}, 7, []string{"fishers", "miners"}, true},
+		{"not a group member", db.Webhook{Groups: []string{"miners"}}, 7, []string{"fishers"}, false},
+		{"rules, no account", db.Webhook{Groups: []string{"miners"}}, 0, nil, false},
+	}
+
+	for _, tt := range tests {
+		if got := webhookWantsAccount(tt.w, tt.accountID, tt.groups); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	w := db.Webhook{EventTypes: []string{EventBotCrashed, EventBotUnresponsive}}
+	if !webhookWantsType(w, EventBotCrashed) || webhookWantsType(w, EventLevelUp) || !webhookWantsType(db.Webhook{}, EventLevelUp) {
+		t.Error("event type filter doesn't match")
+	}
+}
+
+func TestValidateWebhook(t *testing.T) {
+	w := db.Webhook{URL: "https://discord.com/api/webhooks/1/abc", Format: WebhookFormatDiscord, Groups: []string{" Miners "}, AccountIDs: []int{7}}
+	if errs := ValidateWebhook(&w); len(errs) != 0 {
+		t.Fatalf("valid webhook rejected: %v", errs)
+	}
+	if w.Groups[0] != "miners" {
+		t.Errorf("group name wasn't normalized: %q", w.Groups[0])
+	}
+
+	w = db.Webhook{URL: "ftp://example.com", Format: "teams", EventTypes: []string{EventHeartbeatReceived}, AccountIDs: []int{0}, RateLimit: -1}
+	fields := map[string]bool{}
+	for _, err := range ValidateWebhook(&w) {
+		fields[err.Field] = true
+	}
+	for _, f := range []string{"url", "format", "event_types", "account_ids", "rate_limit"} {
+		if !fields[f] {
+			t.Errorf("invalid %s wasn't reported", f)
+		}
+	}
+}
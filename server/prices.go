@@ -0,0 +1,44 @@
+package server
+
+import (
+	"bot-api/prices"
+	"context"
+	"log/slog"
+	"time"
+)
+
+// initPrices creates the price book from the configured price source and loads the cached prices
+func (s *Server) initPrices() {
+	var provider prices.Provider
+	switch s.Config.PriceSource {
+	case "file":
+		provider = &prices.FileProvider{Path: s.Config.PriceFile}
+	case "http":
+		provider = &prices.HTTPProvider{URL: s.Config.PriceURL, UserAgent: s.Config.PriceUserAgent}
+	case "":
+	default:
+		slog.Warn("unknown price source, only cached prices will be used", "source", s.Config.PriceSource)
+	}
+
+	s.Prices = prices.NewBook(provider, &prices.DBStore{DB: s.DB}, s.Config.PriceMaxAge)
+	if err := s.Prices.Load(); err != nil {
+		slog.Error("error loading cached item prices", "error", err)
+	}
+}
+
+// refreshPrices fetches new prices from the price source once the refresh interval has passed
+func (s *Server) refreshPrices() {
+	if !s.Prices.NeedsRefresh(s.Config.PriceRefreshInterval) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	if err := s.Prices.Refresh(ctx); err != nil {
+		slog.Error("error refreshing item prices", "error", err)
+		return
+	}
+
+	slog.Info("refreshed item prices", "items", s.Prices.Status().Items, "source", s.Prices.Status().Source)
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	db "bot-api/db"
+	_ "embed"
+	"fmt"
+	"sort"
+)
+
+// Heartbeat protocol versions.
+//
+// v0 is the original, unversioned payload sent by older scripts. only the email is required and any
+// levels, pid or xp that are present are range checked. xp of skill names it doesn't know is accepted.
+//
+// v1 requires "version": 1, an email, a status, the client pid and every skill level.
+//
+// the in-game state fields (world, position, inventory, gold, bank_value, bank, combat, task) and the session
+// loot (items, gp_delta) and membership are optional in every version and only checked when present.
+const (
+	HeartbeatV0 = 0
+	HeartbeatV1 = 1
+
+	LatestHeartbeatVersion = HeartbeatV1
+)
+
+const (
+	minLevel = 1
+	maxLevel = 99
+
+	// windows and linux both keep pids well below this
+	maxPID = 1<<22 - 1
+)
+
+// JSON Schema describing the latest heartbeat version, served by the api
+//
+//go:embed heartbeat.schema.json
+var HeartbeatSchema []byte
+
+// FieldError describes a single invalid field of a request body
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e FieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// Validate checks the heartbeat against the rules of its protocol version and returns every invalid field
+func (hb *Heartbeat) Validate() []FieldError {
+	errs := []FieldError{}
+
+	switch hb.Version {
+	case HeartbeatV0, HeartbeatV1:
+	default:
+		return append(errs, FieldError{"version", fmt.Sprintf("unsupported version %d, latest is %d", hb.Version, LatestHeartbeatVersion)})
+	}
+
+	strict := hb.Version >= HeartbeatV1
+
+	if hb.Email == "" {
+		errs = append(errs, FieldError{"email", "is required"})
+	}
+
+	if strict && hb.Status == "" {
+		errs = append(errs, FieldError{"status", "is required"})
+	}
+
+	if strict && hb.PID == 0 {
+		errs = append(errs, FieldError{"pid", "is required"})
+	} else if hb.PID < 0 || hb.PID > maxPID {
+		errs = append(errs, FieldError{"pid", fmt.Sprintf("must be between 1 and %d", maxPID)})
+	}
+
+	levels := hb.Stats.Map()
+	for _, skill := range sortedKeys(levels) {
+		lvl := levels[skill]
+		if lvl == 0 && !strict {
+			// legacy scripts may leave out skills they don't track
+			continue
+		}
+		if lvl < minLevel || lvl > maxLevel {
+			errs = append(errs, FieldError{"levels." + skill, fmt.Sprintf("must be between %d and %d", minLevel, maxLevel)})
+		}
+	}
+
+	for _, skill := range sortedKeys(hb.GainedXP) {
+		if _, ok := levels[skill]; !ok && strict {
+			errs = append(errs, FieldError{"xp_gained." + skill, "unknown skill"})
+			continue
+		}
+		if hb.GainedXP[skill] < 0 {
+			errs = append(errs, FieldError{"xp_gained." + skill, "must not be negative"})
+		}
+	}
+
+	for i, item := range hb.Items {
+		if item.ItemID < 0 {
+			errs = append(errs, FieldError{fmt.Sprintf("items[%d].id", i), "must not be negative"})
+		}
+		if item.Gained < 0 || item.Consumed < 0 {
+			errs = append(errs, FieldError{fmt.Sprintf("items[%d]", i), "gained and consumed must not be negative"})
+		}
+	}
+
+	if m := hb.Membership; m != nil && m.DaysLeft != nil {
+		if *m.DaysLeft < 0 {
+			errs = append(errs, FieldError{"membership.days_left", "must not be negative"})
+		} else if !m.Member && *m.DaysLeft > 0 {
+			errs = append(errs, FieldError{"membership.days_left", "must be 0 for free to play accounts"})
+		}
+	}
+
+	return append(errs, validateState(hb.BotState)...)
+}
+
+const (
+	minWorld = 301
+	maxWorld = 640
+
+	maxInventoryItems = 28
+)
+
+// validateState range checks the optional in-game state, fields that weren't reported are skipped
+func validateState(st db.BotState) []FieldError {
+	errs := []FieldError{}
+
+	if st.World != nil && (*st.World < minWorld || *st.World > maxWorld) {
+		errs = append(errs, FieldError{"world", fmt.Sprintf("must be between %d and %d", minWorld, maxWorld)})
+	}
+
+	if p := st.Position; p != nil {
+		if p.X < 0 || p.Y < 0 {
+			errs = append(errs, FieldError{"position", "coordinates must not be negative"})
+		}
+		if p.Plane < 0 || p.Plane > 3 {
+			errs = append(errs, FieldError{"position.plane", "must be between 0 and 3"})
+		}
+		if p.Region < 0 {
+			errs = append(errs, FieldError{"position.region", "must not be negative"})
+		}
+	}
+
+	if len(st.Inventory) > maxInventoryItems {
+		errs = append(errs, FieldError{"inventory", fmt.Sprintf("must not have more than %d entries", maxInventoryItems)})
+	}
+	for i, item := range st.Inventory {
+		if item.ID < 0 {
+			errs = append(errs, FieldError{fmt.Sprintf("inventory[%d].id", i), "must not be negative"})
+		}
+		if item.Quantity <= 0 {
+			errs = append(errs, FieldError{fmt.Sprintf("inventory[%d].quantity", i), "must be positive"})
+		}
+	}
+
+	for i, item := range st.Bank {
+		if item.ID < 0 {
+			errs = append(errs, FieldError{fmt.Sprintf("bank[%d].id", i), "must not be negative"})
+		}
+		if item.Quantity <= 0 {
+			errs = append(errs, FieldError{fmt.Sprintf("bank[%d].quantity", i), "must be positive"})
+		}
+	}
+
+	if st.Gold != nil && *st.Gold < 0 {
+		errs = append(errs, FieldError{"gold", "must not be negative"})
+	}
+
+	if st.BankValue != nil && *st.BankValue < 0 {
+		errs = append(errs, FieldError{"bank_value", "must not be negative"})
+	}
+
+	if st.Combat != nil && st.Combat.Hitpoints < 0 {
+		errs = append(errs, FieldError{"combat.hitpoints", "must not be negative"})
+	}
+
+	return errs
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
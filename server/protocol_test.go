@@ -0,0 +1,31 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestValidateUnknownXPSkill(t *testing.T) {
+	var legacy Heartbeat
+	if err := json.Unmarshal([]byte(`{"email": "bot@example.com", "xp_gained": {"Sailing": 1200, "Mining": 300}}`), &legacy); err != nil {
+		t.Fatal(err)
+	}
+	if errs := legacy.Validate(); len(errs) > 0 {
+		t.Errorf("v0 heartbeat with an unknown skill rejected: %v", errs)
+	}
+
+	legacy.GainedXP["Sailing"] = -5
+	if errs := legacy.Validate(); len(errs) != 1 || errs[0].Field != "xp_gained.Sailing" {
+		t.Errorf("expected negative xp of an unknown v0 skill to be rejected, got %v", errs)
+	}
+
+	strict := legacy
+	strict.Version = HeartbeatV1
+	strict.GainedXP = map[string]int{"Sailing": 1200}
+	for _, err := range strict.Validate() {
+		if err.Field == "xp_gained.Sailing" {
+			return
+		}
+	}
+	t.Error("expected v1 heartbeat with an unknown skill to be rejected")
+}
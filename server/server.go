@@ -3,9 +3,13 @@ package server
 import (
 	b "bot-api/bot"
 	db "bot-api/db"
+	"bot-api/metrics"
+	"bot-api/prices"
 	"database/sql"
 	"fmt"
+	"log/slog"
 	"strconv"
+	"sync"
 	"time"
 )
 
@@ -21,26 +25,131 @@ type Server struct {
 
 	// stores whether the server should be running or not
 	isRunning bool
+
+	// settings for the server, see LoadConfig
+	Config Config
+
+	// current item prices used to value loot, inventories and banks
+	Prices *prices.Book
+
+	// encrypts stored account credentials, nil if no credentials key is configured
+	Vault *Vault
+
+	// publishes bot state changes, heartbeats and level-ups to the subscribers registered in Start
+	Bus *Bus
+
+	// events of the bus for api clients
+	Events *EventStream
+
+	// metrics served on /metrics, the fleet's are kept in metrics
+	Metrics *metrics.Registry
+	metrics *serverMetrics
+
+	// wakes the webhook dispatcher when deliveries are queued
+	webhookWake chan struct{}
+
+	// rate limits of the webhooks, used by the webhook subscriber
+	webhookLimits webhookLimiter
+
+	// guards LatestHeartbeats, lastStoredHeartbeat, lastSeen and unresponsive
+	hbMu sync.Mutex
+
+	// map of account id to the last time its bot was known to be alive: its last heartbeat, its launch or
+	// when the monitor found it running
+	lastSeen map[int]time.Time
+
+	// account ids of the bots reported as unresponsive, until their next heartbeat
+	unresponsive map[int]bool
+
+	// map of account id to the time the last heartbeat was stored in the database, used for sampling
+	lastStoredHeartbeat map[int]time.Time
+
+	// last time heartbeat retention and downsampling were applied
+	lastHeartbeatMaintenance time.Time
+
+	// last time the alert rules were evaluated
+	lastAlertEvaluation time.Time
+
+	// map of account id to the last stored levels, used by the storage subscriber to detect level-ups
+	storedLevels map[int]db.Levels
 }
 
+// Heartbeat is the payload periodically posted by running bots. see protocol.go for the versions and
+// validation rules
 type Heartbeat struct {
+	Version  int            `json:"version"`  // protocol version, omitted (0) by legacy scripts
 	Email    string         `json:"email"`    // dreambot username / osrs login email
 	Status   string         `json:"status"`   // current task status description
 	Username string         `json:"username"` // osrs username
 	Stats    db.Levels      `json:"levels"`
 	PID      int            `json:"pid"`
 	GainedXP map[string]int `json:"xp_gained"` // map of skill name to gained XP for the current session
+
+	// items gained/consumed and gp gained (or lost) during the current session, optional
+	Items   []db.ItemDelta `json:"items,omitempty"`
+	GPDelta *int64         `json:"gp_delta,omitempty"`
+
+	// membership status, optional
+	Membership *MembershipReport `json:"membership,omitempty"`
+
+	// optional in-game state (world, position, inventory, gold, ...), flattened into the payload
+	db.BotState
 }
 
 // Start the server and begin bot monitoring goroutine(s)
 func (s *Server) Start() {
+	s.Metrics = metrics.NewRegistry()
+	s.metrics = newServerMetrics(s.Metrics, s.Config)
+
 	// initialize database
-	s.DB = &db.Database{Driver: initDatabase()}
+	s.DB = &db.Database{Driver: initDatabase(s.metrics.observeQuery)}
+	if err := s.DB.Migrate(); err != nil {
+		panic(err.Error())
+	}
+
+	if err := s.bootstrapAdminKey(); err != nil {
+		panic(err.Error())
+	}
+
+	if s.Config.CredentialsKey != "" {
+		vault, err := NewVault(s.Config.CredentialsKey)
+		if err != nil {
+			panic(err.Error())
+		}
+		s.Vault = vault
+	}
+
 	s.LatestHeartbeats = make(map[string]Heartbeat)
+	s.lastStoredHeartbeat = make(map[int]time.Time)
+	s.storedLevels = make(map[int]db.Levels)
+	s.lastSeen = make(map[int]time.Time)
+	s.unresponsive = make(map[int]bool)
+
+	s.Bus = NewBus()
+	s.Events = NewEventStream()
+	s.webhookWake = make(chan struct{}, 1)
+	s.subscribe()
+	s.registerMetrics()
 
+	s.initPrices()
+
+	go s.dispatchWebhooks()
 	go s.run()
 }
 
+// subscribe registers the subscribers of the server's own events
+func (s *Server) subscribe() {
+	// heartbeats are never dropped, a database that can't keep up slows the heartbeat requests down instead
+	s.Bus.Subscribe("storage", 1024, BusBlock, s.storeHeartbeatEvent, EventHeartbeatReceived)
+
+	// the stream is best effort and never holds up the publishers. events it drops are reported to its
+	// clients as missed, like the ones that fell out of its buffer
+	s.Bus.Subscribe("stream", 1024, BusDrop, s.Events.add)
+
+	// deliveries are stored before they are sent, so webhooks see every event even when their endpoint is down
+	s.Bus.Subscribe("webhooks", 1024, BusBlock, s.queueWebhooks, WebhookEventTypes...)
+}
+
 // Stop the server and any bot monitoring goroutine(s)
 func (s *Server) Stop() {
 	s.isRunning = false
@@ -53,12 +162,24 @@ func (s *Server) IsRunning() bool {
 
 // Stops a bot and remove it the server's list of bots
 func (s *Server) StopBot(id string) bool {
-	// TODO - remove bot from database
+	return s.stopBot(id, db.ExitStopped)
+}
+
+// stops a bot and ends its activity with the given exit reason
+func (s *Server) stopBot(id string, reason string) bool {
 	for i, b := range s.bots {
 		if b.ID == id {
 			s.bots = append(s.bots[:i], s.bots[i+1:]...)
 
 			b.Stop()
+			slog.Info("bot stopped", append(b.LogAttrs(), "reason", reason)...)
+
+			if accountID, err := strconv.Atoi(id); err == nil {
+				s.forgetSeen(accountID)
+				if err := s.DB.UpdateBotStoppedAt(accountID, reason); err != nil {
+					slog.Error("error ending activity of bot", append(b.LogAttrs(), "error", err)...)
+				}
+			}
 			return true
 		}
 	}
@@ -74,105 +195,51 @@ func (s *Server) GetBots() []b.Bot {
 	return s.bots
 }
 
-func (s *Server) HandleHeartbeat(hb Heartbeat) error {
-	// TODO - move logic to server
+// HandleHeartbeat processes an authenticated heartbeat for the given account. only the in-memory state is
+// updated here, storing the heartbeat and its side effects are left to the subscribers of the
+// heartbeat event so the client gets its response without waiting for the database
+func (s *Server) HandleHeartbeat(hb Heartbeat, account db.Account) {
 	// check if bot is known
 	for _, b := range s.bots {
 		if b.Email == hb.Email {
-			if err := s.handleKnownHeartbeat(hb, b); err != nil {
-				fmt.Println("Error handling heartbeat for known bot: " + hb.Email)
-				fmt.Println(err)
-				return err
-			}
-
-			return nil
+			s.handleKnownHeartbeat(hb, b, account)
+			return
 		}
 	}
 
-	// bot is not known, add it to the list of known bots
-	fmt.Println("Heartbeat received from unknown bot with username: " + hb.Email)
-	s.bots = append(s.bots, b.Bot{Email: hb.Email, Status: hb.Status})
-	fmt.Println("Levels: " + fmt.Sprint(hb.Stats) + "\n")
-
-	fmt.Println("Adding bot to database: " + hb.Email)
-	s.DB.InsertAccount(hb.Email, hb.Username, "active")
+	// bot is authenticated but not tracked yet (e.g. the server restarted), add it to the list of known bots
+	bot := b.Bot{ID: fmt.Sprint(account.ID), Email: account.Email, Username: account.Username, Status: hb.Status, PID: hb.PID}
+	slog.Info("heartbeat received from untracked bot", bot.LogAttrs()...)
+	s.bots = append(s.bots, bot)
 
-	return nil
+	s.handleKnownHeartbeat(hb, bot, account)
 }
 
-func (s *Server) handleKnownHeartbeat(hb Heartbeat, bot b.Bot) error {
-	fmt.Printf("Heartbeat received from known bot with username: %s\n", hb.Email)
+func (s *Server) handleKnownHeartbeat(hb Heartbeat, bot b.Bot, account db.Account) {
+	slog.Debug("heartbeat received", append(bot.LogAttrs(), "status", hb.Status, "version", hb.Version)...)
 
 	bot.Status = hb.Status
 
+	s.hbMu.Lock()
+	previous := s.LatestHeartbeats[hb.Email]
 	hb_changed := false
-	if s.LatestHeartbeats[hb.Email].Status != hb.Status {
+	if previous.Status != hb.Status {
 		hb_changed = true
 	}
 
 	s.LatestHeartbeats[hb.Email] = hb
+	s.lastSeen[account.ID] = time.Now()
+	delete(s.unresponsive, account.ID)
+	s.hbMu.Unlock()
 
-	if hb_changed {
-		fmt.Println("Bot " + hb.Email + " status has changed to: " + hb.Status)
-	}
+	s.metrics.heartbeatReceived(account)
 
-	account, err := s.DB.GetAccountByEmail(hb.Email)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			fmt.Println("Account not found for username: " + hb.Email)
-
-		}
-		fmt.Println("Error getting account for username: " + hb.Email)
-		fmt.Println(err)
-		return err
-	}
-
-	err = s.DB.UpdateLevelsForAccount(account, hb.Stats)
-	if err != nil {
-		fmt.Println("Error updating levels for account: " + account.Username)
-		fmt.Println(err)
-		return err
-	}
-
-	// Store XP gained from heartbeat if present
-	if len(hb.GainedXP) > 0 {
-		activityID, err := s.DB.GetActiveActivityIDForAccount(account.ID)
-		if err != nil {
-			if err != sql.ErrNoRows {
-				fmt.Println("Error getting active activity for account: " + account.Username)
-				fmt.Println(err)
-			}
-			// No active activity, skip storing XP
-		} else {
-			for skill, xp := range hb.GainedXP {
-				if err := s.DB.UpsertActivityXP(activityID, skill, xp); err != nil {
-					fmt.Printf("Error storing XP for skill %s: %v\n", skill, err)
-				}
-			}
-		}
-	}
-
-	bots, err := s.DB.GetActiveBots()
-	if err != nil {
-		fmt.Println("Error getting active bots from database")
-		fmt.Println(err)
-		return err
-	}
-
-	for _, b := range bots {
-		if b.Email == hb.Email {
-			fmt.Printf("Updating activity based on heartbeat for account: %s with script: %s\n", account.Username, b.Script+" "+fmt.Sprint(b.Params))
-
-			//TODO - implement this later
-			// s.DB.UpdateActivity(account.ID, b.Script+" "+fmt.Sprint(b.Params), b.PID)
-			return nil
-		}
+	if hb_changed {
+		slog.Info("bot status changed", append(bot.LogAttrs(), "from", previous.Status, "to", hb.Status)...)
+		s.Bus.Publish(EventBotStatus, account.ID, BotStatusEvent{Email: hb.Email, From: previous.Status, To: hb.Status})
 	}
 
-	// fmt.Println("Bot not found in database: " + hb.Email)
-	// s.DB.UpdateActivity(account.ID, "Unknown (heartbeat)", hb.PID)
-
-	return nil
+	s.Bus.Publish(EventHeartbeatReceived, account.ID, HeartbeatReceivedEvent{Heartbeat: hb, Account: account, StatusChanged: hb_changed})
 }
 
 func (s *Server) run() {
@@ -183,13 +250,16 @@ func (s *Server) run() {
 		time.Sleep(10 * time.Second)
 
 		s.monitorActiveBots()
+		s.maintainHeartbeats()
+		s.evaluateAlerts()
+		s.refreshPrices()
 	}
 
-	fmt.Println("Server has stopped.")
+	slog.Info("server has stopped")
 }
 
-func initDatabase() *sql.DB {
-	sql_db, err := sql.Open("mysql", "admin:FredLongBottoms2$@/osrs-bots")
+func initDatabase(observe db.QueryObserver) *sql.DB {
+	sql_db, err := db.Open("admin:FredLongBottoms2$@/osrs-bots", observe)
 	if err != nil {
 		panic(err.Error())
 	}
@@ -206,20 +276,20 @@ func (s *Server) monitorActiveBots() {
 
 	bots, err := s.DB.GetActiveBots()
 	if err != nil {
-		fmt.Println("Error getting active bots from database")
-		fmt.Println(err)
+		slog.Error("error getting active bots", "error", err)
 		return
 	}
 
 	// check if each bot is still running
 	// if not, update the bot's stopped_at field in the database
 	// if the bot is still running, update the bot's status in the database
+	running := 0
 	for _, b := range bots {
 
 		// check if bot is still running - for now just check if the process is still running and assume
 		// the server is on the same machine as the bot
 		if b.PID != 0 && b.IsRunning() {
-			fmt.Printf("Bot %s is still running with PID %d\n", b.Email, b.PID)
+			slog.Debug("bot is still running", b.LogAttrs()...)
 
 			// add the bot to the list of known bots (if not already present)
 			found := false
@@ -232,18 +302,79 @@ func (s *Server) monitorActiveBots() {
 			if !found {
 				s.bots = append(s.bots, b)
 			}
+
+			s.checkResponsive(b)
+			running++
 			continue
 		}
 
 		// bot is not running, update the bot's stopped_at field in the database
 		id, err := strconv.Atoi(b.ID)
 		if err != nil {
-			fmt.Println("Error converting bot id to int: " + b.ID)
-			fmt.Println(err)
+			slog.Error("error converting bot id to int", append(b.LogAttrs(), "error", err)...)
 			continue
 		}
 
-		fmt.Printf("Bot %s is not running, updating stopped_at field in database\n", b.Email)
-		s.DB.UpdateBotStoppedAt(id)
+		s.forgetSeen(id)
+
+		slog.Warn("bot is not running, ending its activity", b.LogAttrs()...)
+		if err := s.DB.UpdateBotStoppedAt(id, db.ExitExited); err != nil {
+			slog.Error("error ending activity of bot", append(b.LogAttrs(), "error", err)...)
+		}
+		s.metrics.botCrashed(id, b.Username, b.Script)
+		s.Bus.Publish(EventBotCrashed, id, botStateEvent(b, db.ExitExited))
+	}
+
+	s.updateBotMetrics(running)
+}
+
+// checkResponsive publishes a bot.unresponsive event the first time a running bot has been silent for
+// longer than HeartbeatUnresponsiveAfter. bots are seen alive when they are first found running
+func (s *Server) checkResponsive(bot b.Bot) {
+	id, err := strconv.Atoi(bot.ID)
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	s.hbMu.Lock()
+	last, ok := s.lastSeen[id]
+	if !ok {
+		s.lastSeen[id] = now
 	}
+	report := ok && s.Config.HeartbeatUnresponsiveAfter > 0 && !s.unresponsive[id] && now.Sub(last) > s.Config.HeartbeatUnresponsiveAfter
+	if report {
+		s.unresponsive[id] = true
+	}
+	s.hbMu.Unlock()
+
+	if report {
+		slog.Warn("bot has not sent a heartbeat", append(bot.LogAttrs(), "last_seen", last)...)
+		s.Bus.Publish(EventBotUnresponsive, id, BotUnresponsiveEvent{Email: bot.Email, Username: bot.Username, Script: bot.Script, PID: bot.PID, LastSeen: last})
+	}
+}
+
+// forgetSeen drops the liveness of a bot that is no longer running
+func (s *Server) forgetSeen(accountID int) {
+	s.hbMu.Lock()
+	delete(s.lastSeen, accountID)
+	delete(s.unresponsive, accountID)
+	s.hbMu.Unlock()
+}
+
+// markSeen records that the bot of an account is alive without a heartbeat, e.g. because it was just launched
+func (s *Server) markSeen(accountID int) {
+	s.hbMu.Lock()
+	s.lastSeen[accountID] = time.Now()
+	delete(s.unresponsive, accountID)
+	s.hbMu.Unlock()
+}
+
+// accountAttrs are the log attributes of an account
+func accountAttrs(acc db.Account) []any {
+	return []any{"account_id", acc.ID, "username", acc.Username}
+}
+
+func botStateEvent(bot b.Bot, reason string) BotStateEvent {
+	return BotStateEvent{Email: bot.Email, Username: bot.Username, Script: bot.Script, PID: bot.PID, Reason: reason}
 }
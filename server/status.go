@@ -0,0 +1,131 @@
+package server
+
+import (
+	db "bot-api/db"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// account statuses
+const (
+	// imported or created, never run
+	StatusNew = "new"
+
+	// in use by the bot farm
+	StatusActive = "active"
+
+	// temporarily benched, e.g. to let it cool down between sessions
+	StatusResting = "resting"
+
+	// locked by jagex, needs to be recovered before it can run again
+	StatusLocked = "locked"
+
+	// banned, can only be retired
+	StatusBanned = "banned"
+
+	// permanently out of rotation
+	StatusRetired = "retired"
+)
+
+// the statuses each status may change to
+var statusTransitions = map[string][]string{
+	StatusNew:     {StatusActive, StatusResting, StatusLocked, StatusBanned, StatusRetired},
+	StatusActive:  {StatusResting, StatusLocked, StatusBanned, StatusRetired},
+	StatusResting: {StatusActive, StatusLocked, StatusBanned, StatusRetired},
+	StatusLocked:  {StatusActive, StatusResting, StatusBanned, StatusRetired},
+	StatusBanned:  {StatusRetired},
+	StatusRetired: {},
+}
+
+// statuses a bot may be launched in
+var runnableStatuses = map[string]bool{
+	StatusNew:    true,
+	StatusActive: true,
+}
+
+var ErrAccountNotRunnable = errors.New("account status does not allow starting a bot")
+
+// TransitionError is returned when an account can't change from its current status to the requested one
+type TransitionError struct {
+	From string
+	To   string
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("account status can't change from %s to %s", e.From, e.To)
+}
+
+// Statuses returns every known account status with the statuses it may change to
+func Statuses() map[string][]string {
+	return statusTransitions
+}
+
+// ValidStatus reports whether status is one of the known account statuses
+func ValidStatus(status string) bool {
+	_, ok := statusTransitions[status]
+	return ok
+}
+
+// CanTransition reports whether an account may change from one status to another. accounts with a
+// status from before the status model may change to any known status
+func CanTransition(from string, to string) bool {
+	if !ValidStatus(to) {
+		return false
+	}
+	if from == to || !ValidStatus(from) {
+		return true
+	}
+
+	for _, s := range statusTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Runnable reports whether a bot may be launched on an account with the given status
+func Runnable(status string) bool {
+	return runnableStatuses[status]
+}
+
+// SetAccountStatus validates and applies a status change, recording it in the account's status history
+func (s *Server) SetAccountStatus(acc db.Account, to string, reason string, actor string) error {
+	if acc.Status == to {
+		return nil
+	}
+	if !CanTransition(acc.Status, to) {
+		return &TransitionError{From: acc.Status, To: to}
+	}
+
+	err := s.DB.ChangeAccountStatus(acc.ID, acc.Status, to, reason, actor)
+	if err == sql.ErrNoRows {
+		// the status was changed by someone else in the meantime
+		return fmt.Errorf("account %d was modified concurrently, retry", acc.ID)
+	}
+	if err != nil {
+		return err
+	}
+
+	s.publishAccountStatus(acc, to, reason, actor)
+	return nil
+}
+
+// publishAccountStatus publishes an account.status event for a status change of acc that was stored
+func (s *Server) publishAccountStatus(acc db.Account, to string, reason string, actor string) {
+	s.Bus.Publish(EventAccountStatus, acc.ID, AccountStatusEvent{Username: acc.Username, From: acc.Status, To: to, Reason: reason, Actor: actor})
+}
+
+// statusNames returns the known statuses in a stable order for error messages
+func statusNames() []string {
+	names := make([]string, 0, len(statusTransitions))
+	for s := range statusTransitions {
+		names = append(names, s)
+	}
+	sort.Strings(names)
+
+	return names
+}
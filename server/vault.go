@@ -0,0 +1,57 @@
+package server
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+var ErrVaultDisabled = errors.New("credential vault is not configured, set CREDENTIALS_KEY")
+
+// Vault encrypts account credentials at rest with AES-256-GCM. the account id is used as additional
+// data, so a ciphertext can't be moved to another account
+type Vault struct {
+	aead cipher.AEAD
+}
+
+// NewVault creates a vault from a base64 encoded 32 byte key
+func NewVault(encodedKey string) (*Vault, error) {
+	key, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil {
+		return nil, fmt.Errorf("credentials key is not valid base64: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("credentials key must be 32 bytes, got %d", len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Vault{aead: aead}, nil
+}
+
+// Seal encrypts a secret for an account, returning the nonce and ciphertext
+func (v *Vault) Seal(accountID int, plaintext []byte) ([]byte, []byte, error) {
+	nonce := make([]byte, v.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+
+	return nonce, v.aead.Seal(nil, nonce, plaintext, []byte(strconv.Itoa(accountID))), nil
+}
+
+// Open decrypts a secret sealed for an account
+func (v *Vault) Open(accountID int, nonce []byte, ciphertext []byte) ([]byte, error) {
+	return v.aead.Open(nil, nonce, ciphertext, []byte(strconv.Itoa(accountID)))
+}
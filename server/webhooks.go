@@ -0,0 +1,466 @@
+package server
+
+import (
+	db "bot-api/db"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// WebhookEventTypes lists the event types webhooks can subscribe to. heartbeats are too frequent to be
+// sent anywhere, a webhook without event types gets all of these
+var WebhookEventTypes = []string{EventBotStarted, EventBotStopped, EventBotCrashed, EventBotUnresponsive, EventBotStatus,
+	EventLevelUp, EventAccountStatus, EventAlertFiring, EventAlertResolved}
+
+// headers of a webhook request
+const (
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookDeliveryHeader  = "X-Webhook-Delivery"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// delivery attempts of a webhook event before it becomes a dead letter
+const webhookMaxAttempts = 8
+
+// delay before the second attempt, doubled for each following attempt up to webhookMaxBackoff
+const (
+	webhookBackoff    = 30 * time.Second
+	webhookMaxBackoff = time.Hour
+)
+
+// how long an endpoint has to answer
+const webhookTimeout = 10 * time.Second
+
+// how often due deliveries are looked up when no new event wakes the dispatcher, how many are sent per
+// round and how many at the same time
+const (
+	webhookPollInterval = 5 * time.Second
+	webhookBatchSize    = 50
+	webhookConcurrency  = 8
+)
+
+var webhookClient = &http.Client{Timeout: webhookTimeout}
+
+// ValidateWebhook checks the url, event types, format, routing rules and rate limit of a webhook. group
+// names are normalized
+func ValidateWebhook(w *db.Webhook) []FieldError {
+	errs := []FieldError{}
+
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, FieldError{"url", "must be an absolute http or https url"})
+	} else if len(w.URL) > 2048 {
+		errs = append(errs, FieldError{"url", "must be at most 2048 characters"})
+	}
+
+	for _, t := range w.EventTypes {
+		if !validWebhookEventType(t) {
+			errs = append(errs, FieldError{"event_types", fmt.Sprintf("unknown event type %s, must be one of %s", t, strings.Join(WebhookEventTypes, ", "))})
+		}
+	}
+
+	if !validWebhookFormat(w.Format) {
+		errs = append(errs, FieldError{"format", "must be one of " + strings.Join(WebhookFormats, ", ")})
+	}
+
+	for _, id := range w.AccountIDs {
+		if id <= 0 {
+			errs = append(errs, FieldError{"account_ids", fmt.Sprintf("invalid account id %d", id)})
+		}
+	}
+	for i, g := range w.Groups {
+		name, err := NormalizeLabel(g)
+		if err != nil {
+			errs = append(errs, FieldError{"groups", err.Error()})
+			continue
+		}
+		w.Groups[i] = name
+	}
+
+	if w.RateLimit < 0 {
+		errs = append(errs, FieldError{"rate_limit", "must be 0 (no limit) or more"})
+	}
+
+	return errs
+}
+
+func validWebhookFormat(format string) bool {
+	for _, f := range WebhookFormats {
+		if f == format {
+			return true
+		}
+	}
+
+	return false
+}
+
+func validWebhookEventType(t string) bool {
+	for _, et := range WebhookEventTypes {
+		if et == t {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NewWebhookSecret generates the key used to sign the payloads of a webhook
+func NewWebhookSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return "whsec_" + hex.EncodeToString(buf), nil
+}
+
+// SignWebhook returns the signature header value of a payload: the hex encoded HMAC-SHA256 of
+// "<timestamp>.<body>" keyed with the webhook's secret
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// queueWebhooks is the webhook subscriber of the bus: it stores a delivery of the event, rendered in the
+// webhook's format, for every active webhook whose routing rules match it and wakes the dispatcher. events
+// over a webhook's rate limit are logged as suppressed instead
+func (s *Server) queueWebhooks(e Event) {
+	webhooks, err := s.DB.GetWebhooks()
+	if err != nil {
+		slog.Error("error getting webhooks", "event_type", e.Type, "event_id", e.ID, "error", err)
+		return
+	}
+
+	// the groups of the account are only looked up if a webhook routes on them
+	var groups []string
+	groupsLoaded := false
+
+	queued := false
+	for _, w := range webhooks {
+		if !w.Active || !webhookWantsType(w, e.Type) {
+			continue
+		}
+		if len(w.Groups) > 0 && !groupsLoaded && e.AccountID != 0 {
+			if acc, err := s.DB.GetAccount(fmt.Sprint(e.AccountID)); err == nil {
+				groups = acc.Groups
+			} else {
+				slog.Error("error getting groups of account for webhooks", "account_id", e.AccountID, "event_id", e.ID, "error", err)
+			}
+			groupsLoaded = true
+		}
+		if !webhookWantsAccount(w, e.AccountID, groups) {
+			continue
+		}
+
+		status := db.DeliveryPending
+		allowed, suppressed := s.webhookLimits.allow(w.ID, w.RateLimit, e.Time)
+		if !allowed {
+			status = db.DeliverySuppressed
+		}
+
+		payload, err := webhookPayload(w.Format, e, suppressed)
+		if err != nil {
+			slog.Error("error encoding webhook payload", "webhook_id", w.ID, "event_type", e.Type, "event_id", e.ID, "error", err)
+			continue
+		}
+
+		if _, err := s.DB.InsertWebhookDelivery(w.ID, e.ID, e.Type, payload, status, e.Time); err != nil {
+			slog.Error("error queueing webhook delivery", "webhook_id", w.ID, "event_type", e.Type, "event_id", e.ID, "error", err)
+			continue
+		}
+		queued = queued || allowed
+	}
+
+	if queued {
+		s.wakeWebhooks()
+	}
+}
+
+func webhookWantsType(w db.Webhook, eventType string) bool {
+	if len(w.EventTypes) == 0 {
+		return true
+	}
+	for _, t := range w.EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// webhookWantsAccount applies the routing rules of a webhook to the account of an event and the groups
+// it is a member of. events without an account only go to webhooks without rules
+func webhookWantsAccount(w db.Webhook, accountID int, groups []string) bool {
+	if len(w.AccountIDs) == 0 && len(w.Groups) == 0 {
+		return true
+	}
+	for _, id := range w.AccountIDs {
+		if id == accountID {
+			return true
+		}
+	}
+	for _, g := range w.Groups {
+		for _, member := range groups {
+			if g == member {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// webhookLimiter enforces the rate limits of the webhooks over fixed one minute windows. it is only used
+// by the webhook subscriber, so it isn't synchronized
+type webhookLimiter struct {
+	windows map[int]*webhookWindow
+}
+
+type webhookWindow struct {
+	start      time.Time
+	sent       int
+	suppressed int // held back since the last allowed event
+}
+
+// allow reports whether a webhook with the given limit may be sent an event at now, and how many events
+// were held back since the last one it was sent
+func (l *webhookLimiter) allow(webhookID int, limit int, now time.Time) (bool, int) {
+	if limit <= 0 {
+		return true, 0
+	}
+	if l.windows == nil {
+		l.windows = make(map[int]*webhookWindow)
+	}
+
+	w, ok := l.windows[webhookID]
+	if !ok {
+		w = &webhookWindow{start: now}
+		l.windows[webhookID] = w
+	}
+	if now.Sub(w.start) >= time.Minute {
+		w.start, w.sent = now, 0
+	}
+
+	if w.sent >= limit {
+		w.suppressed++
+		return false, 0
+	}
+
+	w.sent++
+	suppressed := w.suppressed
+	w.suppressed = 0
+	return true, suppressed
+}
+
+// wakeWebhooks makes the dispatcher look for due deliveries now instead of at its next poll
+func (s *Server) wakeWebhooks() {
+	select {
+	case s.webhookWake <- struct{}{}:
+	default:
+	}
+}
+
+// dispatchWebhooks sends the due deliveries until the server stops. deliveries are stored, so the ones
+// pending when the server stops are sent after it restarts
+func (s *Server) dispatchWebhooks() {
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.webhookWake:
+		}
+
+		for s.sendDueWebhooks() == webhookBatchSize {
+			// a full batch was sent and recorded, more may be due. after a database error the rest waits for
+			// the next poll
+		}
+	}
+}
+
+// sendDueWebhooks attempts a batch of due deliveries and returns how many were attempted and recorded
+func (s *Server) sendDueWebhooks() int {
+	deliveries, err := s.DB.GetDueWebhookDeliveries(time.Now(), webhookBatchSize)
+	if err != nil {
+		slog.Error("error getting due webhook deliveries", "error", err)
+		return 0
+	}
+
+	webhooks := map[int]db.Webhook{}
+	var recorded atomic.Int64
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, webhookConcurrency)
+	for _, wd := range deliveries {
+		w, ok := webhooks[wd.WebhookID]
+		if !ok {
+			if w, err = s.DB.GetWebhook(wd.WebhookID); err == sql.ErrNoRows {
+				// deleted since the delivery was read, there is nothing left to send it to
+				if s.abandonWebhookDelivery(wd, "webhook no longer exists") {
+					recorded.Add(1)
+				}
+				continue
+			} else if err != nil {
+				slog.Error("error getting webhook", "webhook_id", wd.WebhookID, "delivery_id", wd.ID, "error", err)
+				continue
+			}
+			webhooks[w.ID] = w
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(w db.Webhook, wd db.WebhookDelivery) {
+			defer func() { <-sem; wg.Done() }()
+			if s.deliverWebhook(w, wd) {
+				recorded.Add(1)
+			}
+		}(w, wd)
+	}
+	wg.Wait()
+
+	return int(recorded.Load())
+}
+
+// abandonWebhookDelivery makes a delivery that can't be attempted a dead letter, reporting whether that
+// was recorded
+func (s *Server) abandonWebhookDelivery(wd db.WebhookDelivery, reason string) bool {
+	wd.Status = db.DeliveryDead
+	wd.NextAttemptAt = nil
+	wd.LastError = reason
+
+	if err := s.DB.UpdateWebhookDelivery(wd); err != nil {
+		slog.Error("error updating webhook delivery", "webhook_id", wd.WebhookID, "delivery_id", wd.ID, "error", err)
+		return false
+	}
+
+	return true
+}
+
+// deliverWebhook makes one attempt of a delivery and stores its outcome: delivered on a 2xx response,
+// otherwise retried with an exponential backoff until it becomes a dead letter. reports whether the outcome
+// was recorded, an unrecorded delivery is still due and sent again
+func (s *Server) deliverWebhook(w db.Webhook, wd db.WebhookDelivery) bool {
+	now := time.Now()
+	status, err := postWebhook(w, wd, now)
+
+	wd.Attempts++
+	attempted := now.Format(db.TimeFormat)
+	wd.LastAttemptAt = &attempted
+	wd.ResponseStatus = nil
+	if status != 0 {
+		wd.ResponseStatus = &status
+	}
+
+	switch {
+	case err == nil:
+		wd.Status = db.DeliveryDelivered
+		wd.NextAttemptAt = nil
+		wd.DeliveredAt = &attempted
+		wd.LastError = ""
+	case wd.Attempts >= webhookMaxAttempts:
+		slog.Warn("webhook delivery failed too many times, giving up", "webhook_id", w.ID, "delivery_id", wd.ID, "attempts", wd.Attempts, "error", err)
+		wd.Status = db.DeliveryDead
+		wd.NextAttemptAt = nil
+		wd.LastError = err.Error()
+	default:
+		next := now.Add(webhookRetryDelay(wd.Attempts)).Format(db.TimeFormat)
+		slog.Debug("webhook delivery failed, retrying", "webhook_id", w.ID, "delivery_id", wd.ID, "attempts", wd.Attempts, "next_attempt_at", next, "error", err)
+		wd.NextAttemptAt = &next
+		wd.LastError = err.Error()
+	}
+
+	if err := s.DB.UpdateWebhookDelivery(wd); err != nil {
+		slog.Error("error updating webhook delivery", "webhook_id", w.ID, "delivery_id", wd.ID, "error", err)
+		return false
+	}
+
+	return true
+}
+
+// webhookRetryDelay returns the delay before the next attempt after the given number of failed attempts
+func webhookRetryDelay(attempts int) time.Duration {
+	delay := webhookBackoff
+	for i := 1; i < attempts && delay < webhookMaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > webhookMaxBackoff {
+		delay = webhookMaxBackoff
+	}
+
+	return delay
+}
+
+// postWebhook sends the signed payload of a delivery and returns the response status. an error is
+// returned unless the endpoint answered with a 2xx status
+func postWebhook(w db.Webhook, wd db.WebhookDelivery, now time.Time) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(wd.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := now.Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "osrs-bot-api-webhooks")
+	req.Header.Set(WebhookEventHeader, wd.EventType)
+	req.Header.Set(WebhookDeliveryHeader, strconv.FormatInt(wd.ID, 10))
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(w.Secret, timestamp, wd.Payload))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint answered %s", resp.Status)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// CreateWebhook stores a new active webhook with a generated secret and returns it, secret included
+func (s *Server) CreateWebhook(w db.Webhook) (db.Webhook, error) {
+	secret, err := NewWebhookSecret()
+	if err != nil {
+		return w, err
+	}
+
+	w.Secret = secret
+	w.Active = true
+	if w.ID, err = s.DB.InsertWebhook(w); err != nil {
+		return w, err
+	}
+
+	return s.DB.GetWebhook(w.ID)
+}
+
+// RetryWebhookDelivery queues a dead letter again with a fresh set of attempts and sends it right away.
+// sql.ErrNoRows is returned if the delivery isn't a dead letter
+func (s *Server) RetryWebhookDelivery(id int64) error {
+	if err := s.DB.RetryWebhookDelivery(id, time.Now()); err != nil {
+		return err
+	}
+
+	s.wakeWebhooks()
+	return nil
+}